@@ -5,18 +5,27 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/CDeX-Labs/CDeX-Socket-Service/config"
 	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/auth"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/connlimit"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/contest"
 	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/handlers"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/history"
 	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/hub"
 	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/kafka"
 	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
 	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/middleware"
 	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/presence"
 	redisclient "github.com/CDeX-Labs/CDeX-Socket-Service/internal/redis"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/revocation"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/routing"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/tracing"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/events"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 )
@@ -39,10 +48,29 @@ func main() {
 		Str("version", "1.0.0").
 		Msg("Starting Socket Service")
 
-	if cfg.JWT.Secret == "" {
-		logger.Fatal().Msg("JWT_SECRET is required")
+	if cfg.JWT.Secret == "" && cfg.JWT.JWKSURL == "" && cfg.JWT.JWKSFile == "" {
+		logger.Fatal().Msg("JWT_SECRET, JWT_JWKS_URL or JWT_JWKS_FILE is required")
 	}
 
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		Insecure:    cfg.Tracing.Insecure,
+		ServiceName: cfg.Tracing.ServiceName,
+		Version:     "1.0.0",
+		SampleRatio: cfg.Tracing.SampleRatio,
+	}, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error().Err(err).Msg("Failed to flush traces")
+		}
+	}()
+
 	redisClient, err := redisclient.NewClient(
 		cfg.Redis.Host,
 		cfg.Redis.Port,
@@ -57,10 +85,79 @@ func main() {
 
 	appMetrics := metrics.New()
 
-	wsHub := hub.NewHub(logger)
+	wsHub := hub.NewHub(appMetrics, logger)
+	wsHub.SetInboundLimits(inboundLimits(cfg.Limits, logger))
+	configureRoomTypes(wsHub, cfg.Rooms, logger)
+	if len(cfg.Rooms.History) > 0 {
+		var historyStore hub.HistoryStore
+		if cfg.Rooms.PersistHistory {
+			redisHistory := history.NewRedisStore(redisClient, appMetrics, logger)
+			redisHistory.Start()
+			defer redisHistory.Stop()
+			historyStore = redisHistory
+		}
+		wsHub.StartHistory(historyStore)
+	}
 	go wsHub.Run()
 
-	jwtValidator := auth.NewJWTValidator(cfg.JWT.Secret)
+	if cfg.Metrics.Enabled {
+		wsHub.StartRoomMetrics(hub.RoomMetricsConfig{
+			TrackedRooms: cfg.Metrics.TrackedRooms,
+			TopK:         cfg.Metrics.RoomTopK,
+			MinMembers:   cfg.Metrics.RoomMinMembers,
+			Interval:     cfg.Metrics.RoomUpdateInterval,
+		})
+	}
+
+	var keySet *auth.KeySet
+	if cfg.JWT.JWKSURL != "" || cfg.JWT.JWKSFile != "" {
+		keySet, err = auth.NewKeySet(auth.JWKSConfig{
+			URL:             cfg.JWT.JWKSURL,
+			File:            cfg.JWT.JWKSFile,
+			RefreshInterval: cfg.JWT.JWKSRefreshInterval,
+		}, logger)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to load JWKS")
+		}
+		keySet.Start()
+		defer keySet.Stop()
+	}
+
+	jwtValidator, err := auth.NewJWTValidator(auth.ValidatorConfig{
+		Secrets:   append([]string{cfg.JWT.Secret}, cfg.JWT.PreviousSecrets...),
+		KeySet:    keySet,
+		Issuer:    cfg.JWT.Issuer,
+		Audience:  cfg.JWT.Audience,
+		ClockSkew: cfg.JWT.ClockSkew,
+	})
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create JWT validator")
+	}
+	revocations := revocation.NewStore(redisClient, revocation.Config{
+		TTL:      cfg.Revocation.TTL,
+		CacheTTL: cfg.Revocation.CacheTTL,
+	}, appMetrics, logger)
+	revocations.OnRevoke(func(rev revocation.Revocation) {
+		wsHub.DisconnectRevoked(rev.UserID, rev.TokenID, rev.Reason)
+	})
+	if err := revocations.Start(); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to start revocation store")
+	}
+	defer revocations.Stop()
+
+	jwtValidator.SetRevocations(revocations)
+	wsHub.StartRevocationChecks(revocations, cfg.Revocation.CheckInterval)
+
+	permissionModel, err := auth.NewPermissionModel(cfg.JWT.RoleIDs, cfg.JWT.RolePermissions)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid role configuration")
+	}
+	wsHub.SetPermissionModel(permissionModel)
+
+	wsHub.SetAuthenticator(jwtValidator, hub.SessionConfig{
+		WarnBefore: cfg.JWT.ExpiryWarning,
+		Grace:      cfg.JWT.ReauthGrace,
+	})
 
 	redisPubSub := redisclient.NewPubSub(redisClient, func(envelope *redisclient.PubSubEnvelope) {
 		if envelope.TargetRoom != "" {
@@ -70,41 +167,225 @@ func main() {
 		} else {
 			wsHub.Broadcast(envelope.Message)
 		}
-	}, logger)
+	}, appMetrics, logger)
 
 	if err := redisPubSub.Start(); err != nil {
 		logger.Fatal().Err(err).Msg("Failed to start Redis PubSub")
 	}
 	defer redisPubSub.Stop()
 
-	presenceManager := presence.NewManager(redisClient, redisPubSub.GetInstanceID(), logger)
+	presenceManager := presence.NewManager(redisClient, redisPubSub.GetInstanceID(), appMetrics, logger)
+	presenceManager.StartRefresh(wsHub.ConnectedUsers)
+	wsHub.OnUnregister(func(client *hub.Client) {
+		if !wsHub.IsUserConnected(client.UserID) {
+			go presenceManager.SetOffline(context.Background(), client.UserID)
+		}
+	})
 
-	kafkaConsumer := kafka.NewConsumer(
-		cfg.Kafka.Brokers,
-		cfg.Kafka.ConsumerGroup,
-		cfg.Kafka.Topics,
-		logger,
-	)
+	kafkaProducer := kafka.NewProducer(cfg.Kafka.Brokers, appMetrics, logger)
+	defer kafkaProducer.Close()
+
+	exclusivity := presence.NewExclusivity(redisClient, presenceManager, cfg.Proctoring.ExclusiveTTL, appMetrics, logger)
+	exclusivity.OnClaim(func(claim presence.Claim) {
+		removed := wsHub.RemoveOtherSessions(claim.RoomID, claim.UserID, claim.ClientID)
+		if removed == 0 || hub.ParseRoomType(claim.RoomID) != hub.RoomTypeContest {
+			return
+		}
+		contestID := hub.ExtractRoomEntityID(claim.RoomID)
+		go kafkaProducer.Publish(context.Background(), cfg.Proctoring.SignalTopic, contestID+":"+claim.UserID, events.ProctoringSignalEvent{
+			ContestID:          contestID,
+			UserID:             claim.UserID,
+			Type:               events.SignalMultipleSessions,
+			ClientID:           claim.ClientID,
+			RemovedConnections: removed,
+			InstanceID:         redisPubSub.GetInstanceID(),
+			Timestamp:          time.Now().UTC().Format(time.RFC3339),
+		})
+	})
+	wsHub.SetExclusiveRooms(exclusivity)
+	if err := exclusivity.Start(); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to start room exclusivity")
+	}
+	defer exclusivity.Stop()
+
+	connPolicy, err := connlimit.ParsePolicy(cfg.Conns.Policy)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid connection limit policy")
+	}
+	connLimiter := connlimit.NewLimiter(redisClient, redisPubSub.GetInstanceID(), connlimit.Config{
+		MaxPerUser:     cfg.Conns.MaxPerUser,
+		MaxPerIP:       cfg.Conns.MaxPerIP,
+		MaxPerInstance: cfg.Conns.MaxPerInstance,
+		Policy:         connPolicy,
+		LeaseTTL:       cfg.Conns.LeaseTTL,
+	}, appMetrics, logger)
+	connLimiter.OnEvict(func(clientID string) {
+		wsHub.ReplaceClient(clientID, "connection limit reached")
+	})
+	wsHub.OnUnregister(func(client *hub.Client) {
+		go connLimiter.Release(client.ID)
+	})
+	if err := connLimiter.Start(); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to start connection limiter")
+	}
+	defer connLimiter.Stop()
+
+	contestRegistry := contest.NewRegistry(redisClient, appMetrics, logger)
+	contestTimers := contest.NewTimers(contestRegistry, wsHub, contest.TimerConfig{
+		TickInterval: cfg.Contest.TickInterval,
+		TimeLeft:     cfg.Contest.TimeLeft,
+	}, logger)
+	if err := contestTimers.Start(); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to start contest timers")
+	}
+	defer contestTimers.Stop()
+
+	if err := contestRegistry.Start(); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to start contest registry")
+	}
+	defer contestRegistry.Stop()
+	wsHub.SetContestRegistry(contestRegistry)
+
+	contestTeams := contest.NewTeams(redisClient, presenceManager, wsHub, hub.TeamRoomID, appMetrics, logger)
+	if cfg.Contest.TeamLookupURL != "" {
+		contestTeams.SetLookup(contest.NewHTTPTeamLookup(cfg.Contest.TeamLookupURL, cfg.Contest.TeamLookupTimeout))
+	}
+	if err := contestTeams.Start(); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to start contest teams")
+	}
+	defer contestTeams.Stop()
+	wsHub.SetTeams(contestTeams)
+
+	contestBoard := contest.NewBoard(redisClient, cfg.Contest.AnnouncementHistory, appMetrics, logger)
+	wsHub.SetContestBoard(contestBoard)
+	presenceManager.OnChange(func(userID string, _ presence.Status) {
+		go contestTeams.PresenceChanged(context.Background(), userID)
+	})
+
+	var router *routing.Router
+	if cfg.Routing.File != "" {
+		router, err = routing.NewRouter(cfg.Routing.File, wsHub, logger)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to load routing table")
+		}
+		defer router.Stop()
+
+		cfg.Kafka.Topics = mergeTopics(cfg.Kafka.Topics, router.Topics())
+	}
+
+	startOffset, err := kafka.ParseStartOffset(cfg.Kafka.StartOffset)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid Kafka start offset")
+	}
+
+	kafkaConsumer := kafka.NewConsumer(kafka.ConsumerConfig{
+		Brokers:           cfg.Kafka.Brokers,
+		GroupID:           cfg.Kafka.ConsumerGroup,
+		Topics:            cfg.Kafka.Topics,
+		StartOffset:       startOffset,
+		MinBytes:          cfg.Kafka.MinBytes,
+		MaxBytes:          cfg.Kafka.MaxBytes,
+		MaxWait:           cfg.Kafka.MaxWait,
+		CommitInterval:    cfg.Kafka.CommitInterval,
+		SessionTimeout:    cfg.Kafka.SessionTimeout,
+		HeartbeatInterval: cfg.Kafka.HeartbeatInterval,
+		RebalanceTimeout:  cfg.Kafka.RebalanceTimeout,
+		Workers:           cfg.Kafka.Workers,
+		MaxInFlight:       cfg.Kafka.MaxInFlight,
+	}, appMetrics, logger)
+
+	if cfg.Schema.Enabled {
+		schemas, err := events.DefaultSchemaRegistry()
+		if err != nil {
+			logger.Fatal().Err(err).Msg("Failed to load built-in event schemas")
+		}
+		if cfg.Schema.Dir != "" {
+			if err := schemas.LoadFS(os.DirFS(cfg.Schema.Dir)); err != nil {
+				logger.Fatal().Err(err).Str("dir", cfg.Schema.Dir).Msg("Failed to load event schemas")
+			}
+		}
+		kafkaConsumer.SetSchemas(schemas, kafka.SchemaOptions{
+			DefaultVersion: cfg.Schema.DefaultVersion,
+			RequireSchema:  cfg.Schema.RequireSchema,
+		})
+	}
 
 	kafkaHandlers := kafka.NewHandlers(wsHub, logger)
-	kafkaHandlers.RegisterAll(kafkaConsumer)
-	kafkaConsumer.Start()
+	kafkaHandlers.SetRevocations(revocations)
+	kafkaHandlers.SetExclusivity(exclusivity)
+	kafkaHandlers.SetRegistry(contestRegistry)
+	kafkaHandlers.SetTeams(contestTeams)
+	kafkaHandlers.SetBoard(contestBoard)
+	if router != nil {
+		kafkaHandlers.SetRouter(router)
+	}
+	if err := kafkaHandlers.RegisterAll(kafkaConsumer, cfg.Kafka.TopicHandlers); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to register Kafka handlers")
+	}
+	if router != nil {
+		router.Watch(cfg.Routing.ReloadInterval)
+	}
+	if err := kafkaConsumer.Start(); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to start Kafka consumer")
+	}
 	defer kafkaConsumer.Stop()
 
-	wsHandler := handlers.NewWebSocketHandler(wsHub, presenceManager, logger)
+	origins, err := middleware.NewOriginPolicy(cfg.Server.AllowedOrigins, appMetrics, logger)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid allowed origins")
+	}
 
-	rateLimiter := middleware.NewRateLimiter(100, time.Minute, logger)
+	wsHandler := handlers.NewWebSocketHandler(wsHub, presenceManager, origins, connLimiter, logger)
+
+	clientIPs, err := middleware.NewClientIPResolver(cfg.RateLimit.TrustedProxies)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid trusted proxies")
+	}
+
+	rateLimiter := middleware.NewRateLimiter(redisClient, appMetrics, logger)
+	defer rateLimiter.Stop()
+	upgradeLimit := rateLimiter.Limit(middleware.RateLimitPolicy{
+		Name:   "upgrade",
+		Limit:  cfg.RateLimit.Upgrade,
+		Window: cfg.RateLimit.Window,
+		Burst:  cfg.RateLimit.UpgradeBurst,
+	})
+	adminLimit := rateLimiter.Limit(middleware.RateLimitPolicy{
+		Name:   "admin",
+		Limit:  cfg.RateLimit.Admin,
+		Window: cfg.RateLimit.Window,
+		Burst:  cfg.RateLimit.AdminBurst,
+	})
+	healthLimit := rateLimiter.Limit(middleware.RateLimitPolicy{
+		Name:   "health",
+		Limit:  cfg.RateLimit.Health,
+		Window: cfg.RateLimit.Window,
+		Burst:  cfg.RateLimit.HealthBurst,
+	})
 
 	mux := http.NewServeMux()
-	mux.Handle("/ws", auth.AuthMiddleware(jwtValidator)(wsHandler))
-	mux.HandleFunc("/health", handlers.HealthHandler())
-	mux.HandleFunc("/ready", handlers.ReadyHandler(wsHub))
+	tickets := auth.NewTicketStore(redisClient, cfg.JWT.TicketTTL, appMetrics)
+
+	mux.Handle("/ws", upgradeLimit(auth.AuthMiddleware(jwtValidator, auth.MiddlewareConfig{
+		Tickets:         tickets,
+		AllowQueryToken: cfg.JWT.AllowQueryToken,
+	}, appMetrics)(wsHandler)))
+	mux.Handle("/ws/ticket", upgradeLimit(auth.HeaderAuthMiddleware(jwtValidator, appMetrics)(handlers.TicketHandler(tickets, logger))))
+	mux.Handle("/health", healthLimit(handlers.HealthHandler()))
+	mux.Handle("/ready", healthLimit(handlers.ReadyHandler(wsHub)))
+
+	adminMux := http.NewServeMux()
+	adminHandler := handlers.NewAdminHandler(cfg.Admin.Token, jwtValidator, permissionModel, revocations, logger)
+	adminHandler.SetExclusivity(exclusivity)
+	adminHandler.SetRegistry(contestRegistry)
+	adminHandler.Register(adminMux)
+	mux.Handle("/admin/", adminLimit(adminMux))
 
 	var handler http.Handler = mux
-	handler = middleware.CORS(middleware.DefaultCORSConfig())(handler)
-	handler = rateLimiter.Middleware(handler)
+	handler = middleware.CORS(middleware.DefaultCORSConfig(origins))(handler)
 	handler = middleware.Recovery(logger)(handler)
 	handler = middleware.Logging(logger)(handler)
+	handler = clientIPs.Middleware(handler)
 
 	if cfg.Metrics.Enabled {
 		go func() {
@@ -152,6 +433,77 @@ func main() {
 	}
 
 	logger.Info().Msg("Server stopped gracefully")
+}
+
+func inboundLimits(cfg config.LimitsConfig, logger zerolog.Logger) hub.InboundLimitConfig {
+	perType := make(map[protocol.MessageType]hub.Rate, len(cfg.TypeRates))
+	for msgType, value := range cfg.TypeRates {
+		rate, err := hub.ParseRate(value)
+		if err != nil {
+			logger.Fatal().Err(err).Str("type", msgType).Msg("Invalid message rate limit")
+		}
+		perType[protocol.MessageType(msgType)] = rate
+	}
+
+	return hub.InboundLimitConfig{
+		Connection:        hub.Rate{PerSecond: cfg.MessageRate, Burst: cfg.MessageBurst},
+		PerType:           perType,
+		User:              hub.Rate{PerSecond: cfg.UserRate, Burst: cfg.UserBurst},
+		MaxRoomsPerClient: cfg.MaxRoomsPerClient,
+		ViolationWindow:   cfg.ViolationWindow,
+		MuteAfter:         cfg.MuteAfter,
+		MuteDuration:      cfg.MuteDuration,
+		DisconnectAfter:   cfg.DisconnectAfter,
+	}
+}
+
+func configureRoomTypes(h *hub.Hub, cfg config.RoomsConfig, logger zerolog.Logger) {
+	for _, name := range cfg.PropagatingTypes {
+		spec, ok := h.RoomType(hub.RoomType(name))
+		if !ok {
+			logger.Fatal().Str("type", name).Msg("Unknown room type configured to propagate")
+		}
+		spec.Propagate = true
+		if err := h.RegisterRoomType(spec); err != nil {
+			logger.Fatal().Err(err).Str("type", name).Msg("Failed to configure room type")
+		}
+	}
 
-	_ = appMetrics
+	for key, value := range cfg.History {
+		name, msgType, ok := strings.Cut(key, "/")
+		if !ok || msgType == "" {
+			logger.Fatal().Str("key", key).Msg("Room history must be configured as <roomType>/<MESSAGE_TYPE>")
+		}
+		spec, ok := h.RoomType(hub.RoomType(name))
+		if !ok {
+			logger.Fatal().Str("type", name).Msg("Unknown room type configured to keep history")
+		}
+		policy, err := hub.ParseHistoryPolicy(value)
+		if err != nil {
+			logger.Fatal().Err(err).Str("key", key).Msg("Invalid room history policy")
+		}
+		policies := make(map[protocol.MessageType]hub.HistoryPolicy, len(spec.History)+1)
+		for t, p := range spec.History {
+			policies[t] = p
+		}
+		policies[protocol.MessageType(msgType)] = policy
+		spec.History = policies
+		if err := h.RegisterRoomType(spec); err != nil {
+			logger.Fatal().Err(err).Str("type", name).Msg("Failed to configure room type")
+		}
+	}
+}
+
+func mergeTopics(topics, extra []string) []string {
+	seen := make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		seen[topic] = true
+	}
+	for _, topic := range extra {
+		if !seen[topic] {
+			seen[topic] = true
+			topics = append(topics, topic)
+		}
+	}
+	return topics
 }
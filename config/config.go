@@ -2,28 +2,68 @@ package config
 
 import (
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/rs/zerolog/log"
 )
 
 type Config struct {
-	Server  ServerConfig
-	JWT     JWTConfig
-	Redis   RedisConfig
-	Kafka   KafkaConfig
-	Metrics MetricsConfig
+	Server     ServerConfig
+	JWT        JWTConfig
+	Redis      RedisConfig
+	Kafka      KafkaConfig
+	Routing    RoutingConfig
+	Schema     SchemaConfig
+	Metrics    MetricsConfig
+	Tracing    TracingConfig
+	Revocation RevocationConfig
+	Admin      AdminConfig
+	Limits     LimitsConfig
+	RateLimit  RateLimitConfig
+	Conns      ConnectionLimitsConfig
+	Proctoring ProctoringConfig
+	Contest    ContestConfig
+	Rooms      RoomsConfig
 }
 
 type ServerConfig struct {
 	Port string
 	Env  string
+	// AllowedOrigins are the browser origins accepted by CORS and the
+	// WebSocket upgrade: exact origins, "https://*.example.com" subdomain
+	// wildcards or "re:" regular expressions.
+	AllowedOrigins []string
 }
 
 type JWTConfig struct {
 	Secret string
+	// PreviousSecrets are still accepted while tokens signed with them
+	// expire after Secret has been rotated.
+	PreviousSecrets     []string
+	JWKSURL             string
+	JWKSFile            string
+	JWKSRefreshInterval time.Duration
+	Issuer              string
+	Audience            []string
+	ClockSkew           time.Duration
+	// ExpiryWarning is how long before token expiry a connection is sent
+	// TOKEN_EXPIRING; ReauthGrace is how long past expiry it may still
+	// re-authenticate before being closed.
+	ExpiryWarning time.Duration
+	ReauthGrace   time.Duration
+	// AllowQueryToken accepts ?token= on /ws. Off by default because URLs
+	// are logged by proxies; use connection tickets instead.
+	AllowQueryToken bool
+	TicketTTL       time.Duration
+	// RoleIDs maps the numeric role claim to role names and
+	// RolePermissions maps role names to "|"-separated permissions. Empty
+	// maps keep the built-in contestant/proctor/setter/admin model.
+	RoleIDs         map[string]string
+	RolePermissions map[string]string
 }
 
 type RedisConfig struct {
@@ -34,14 +74,142 @@ type RedisConfig struct {
 }
 
 type KafkaConfig struct {
-	Brokers       []string
-	ConsumerGroup string
-	Topics        []string
+	Brokers           []string
+	ConsumerGroup     string
+	Topics            []string
+	TopicHandlers     map[string]string
+	StartOffset       string
+	MinBytes          int
+	MaxBytes          int
+	MaxWait           time.Duration
+	CommitInterval    time.Duration
+	SessionTimeout    time.Duration
+	HeartbeatInterval time.Duration
+	RebalanceTimeout  time.Duration
+	Workers           int
+	MaxInFlight       int
+}
+
+type RoutingConfig struct {
+	File           string
+	ReloadInterval time.Duration
+}
+
+type SchemaConfig struct {
+	Enabled        bool
+	Dir            string
+	DefaultVersion string
+	RequireSchema  bool
 }
 
 type MetricsConfig struct {
 	Enabled bool
 	Port    string
+
+	TrackedRooms       []string
+	RoomTopK           int
+	RoomMinMembers     int
+	RoomUpdateInterval time.Duration
+}
+
+type TracingConfig struct {
+	// Exporter is "none", "stdout" or "otlp".
+	Exporter    string
+	Endpoint    string
+	Insecure    bool
+	ServiceName string
+	SampleRatio float64
+}
+
+type RevocationConfig struct {
+	// TTL must cover the longest token lifetime.
+	TTL           time.Duration
+	CacheTTL      time.Duration
+	CheckInterval time.Duration
+}
+
+// LimitsConfig bounds what a single WebSocket client may do. Rates are
+// messages per second with a burst allowance.
+type LimitsConfig struct {
+	MessageRate  float64
+	MessageBurst int
+	UserRate     float64
+	UserBurst    int
+	// TypeRates maps message types to "<perSecond>:<burst>".
+	TypeRates         map[string]string
+	MaxRoomsPerClient int
+	ViolationWindow   time.Duration
+	MuteAfter         int
+	MuteDuration      time.Duration
+	DisconnectAfter   int
+}
+
+// RateLimitConfig holds the per-IP HTTP rate limits. Each policy allows a
+// number of requests per Window with the given burst; 0 disables it.
+type RateLimitConfig struct {
+	// TrustedProxies are the CIDRs whose X-Forwarded-For and X-Real-IP
+	// headers are believed when resolving the client IP.
+	TrustedProxies []string
+	Window         time.Duration
+	Upgrade        int
+	UpgradeBurst   int
+	Admin          int
+	AdminBurst     int
+	Health         int
+	HealthBurst    int
+}
+
+// ConnectionLimitsConfig caps concurrent WebSocket connections; 0 disables
+// a cap. Per-user and per-IP caps are cluster-wide. Policy is "reject" or
+// "evict_oldest" and applies when a user is at their cap.
+type ConnectionLimitsConfig struct {
+	MaxPerUser     int
+	MaxPerIP       int
+	MaxPerInstance int
+	Policy         string
+	LeaseTTL       time.Duration
+}
+
+type ProctoringConfig struct {
+	// ExclusiveTTL is how long a room stays single-connection when no
+	// contest end time is known.
+	ExclusiveTTL time.Duration
+	SignalTopic  string
+}
+
+type ContestConfig struct {
+	// TickInterval is how often CONTEST_TICK is pushed to contest rooms;
+	// 0 disables ticks.
+	TickInterval time.Duration
+	// TimeLeft lists the remaining times announced as milestones.
+	TimeLeft []time.Duration
+	// TeamLookupURL, when set, is asked for the team of users not covered
+	// by contest.team.registered events; {contestId} and {userId} are
+	// substituted.
+	TeamLookupURL     string
+	TeamLookupTimeout time.Duration
+	// AnnouncementHistory is how many announcements per contest are kept
+	// for clients joining later.
+	AnnouncementHistory int
+}
+
+type RoomsConfig struct {
+	// PropagatingTypes lists the room types whose messages also reach the
+	// rooms nested under them, e.g. "contest" for "contest:42/team:7".
+	PropagatingTypes []string
+	// History maps "<roomType>/<MESSAGE_TYPE>" to how much of that type
+	// each room keeps for clients joining later, as
+	// "<maxMessages>[:<maxAge>]".
+	History map[string]string
+	// PersistHistory shares room history between instances through Redis.
+	// Without it, joining clients only get messages their instance sent.
+	PersistHistory bool
+}
+
+type AdminConfig struct {
+	// Token is a static credential for the /admin API. Users whose JWT
+	// grants admin:commands can use it as well.
+	Token string
 }
 
 func Load() *Config {
@@ -49,13 +217,30 @@ func Load() *Config {
 		log.Debug().Msg("No .env file found, using environment variables")
 	}
 
+	topicHandlers := getEnvAsMap("KAFKA_TOPIC_HANDLERS", defaultTopicHandlers())
+
 	return &Config{
 		Server: ServerConfig{
 			Port: getEnv("PORT", "6001"),
 			Env:  getEnv("ENV", "development"),
+
+			AllowedOrigins: getEnvAsSlice("ALLOWED_ORIGINS", []string{"http://localhost:3000"}),
 		},
 		JWT: JWTConfig{
-			Secret: getEnv("JWT_SECRET", ""),
+			Secret:              getEnv("JWT_SECRET", ""),
+			PreviousSecrets:     getEnvAsSlice("JWT_PREVIOUS_SECRETS", nil),
+			JWKSURL:             getEnv("JWT_JWKS_URL", ""),
+			JWKSFile:            getEnv("JWT_JWKS_FILE", ""),
+			JWKSRefreshInterval: getEnvAsDuration("JWT_JWKS_REFRESH_INTERVAL", 10*time.Minute),
+			Issuer:              getEnv("JWT_ISSUER", ""),
+			Audience:            getEnvAsSlice("JWT_AUDIENCE", nil),
+			ClockSkew:           getEnvAsDuration("JWT_CLOCK_SKEW", 30*time.Second),
+			ExpiryWarning:       getEnvAsDuration("JWT_EXPIRY_WARNING", 1*time.Minute),
+			ReauthGrace:         getEnvAsDuration("JWT_REAUTH_GRACE", 30*time.Second),
+			AllowQueryToken:     getEnvAsBool("JWT_ALLOW_QUERY_TOKEN", false),
+			TicketTTL:           getEnvAsDuration("WS_TICKET_TTL", 30*time.Second),
+			RoleIDs:             getEnvAsMap("AUTH_ROLE_IDS", nil),
+			RolePermissions:     getEnvAsMap("AUTH_ROLE_PERMISSIONS", nil),
 		},
 		Redis: RedisConfig{
 			Host:     getEnv("REDIS_HOST", "localhost"),
@@ -64,28 +249,155 @@ func Load() *Config {
 			DB:       getEnvAsInt("REDIS_DB", 0),
 		},
 		Kafka: KafkaConfig{
-			Brokers:       getEnvAsSlice("KAFKA_BROKERS", []string{"localhost:9092"}),
-			ConsumerGroup: getEnv("KAFKA_CONSUMER_GROUP", "socket-svc-group"),
-			Topics: []string{
-				"submission.created",
-				"submission.judged",
-				"leaderboard.updated",
-				"leaderboard.frozen",
-				"leaderboard.unfrozen",
-				"contest.created",
-				"contest.started",
-				"contest.ended",
-				"contest.participant.registered",
-				"proctoring.violation",
-			},
+			Brokers:           getEnvAsSlice("KAFKA_BROKERS", []string{"localhost:9092"}),
+			ConsumerGroup:     getEnv("KAFKA_CONSUMER_GROUP", "socket-svc-group"),
+			Topics:            topicsOf(topicHandlers),
+			TopicHandlers:     topicHandlers,
+			StartOffset:       getEnv("KAFKA_START_OFFSET", "latest"),
+			MinBytes:          getEnvAsInt("KAFKA_MIN_BYTES", 10e3),
+			MaxBytes:          getEnvAsInt("KAFKA_MAX_BYTES", 10e6),
+			MaxWait:           getEnvAsDuration("KAFKA_MAX_WAIT", 1*time.Second),
+			CommitInterval:    getEnvAsDuration("KAFKA_COMMIT_INTERVAL", 1*time.Second),
+			SessionTimeout:    getEnvAsDuration("KAFKA_SESSION_TIMEOUT", 30*time.Second),
+			HeartbeatInterval: getEnvAsDuration("KAFKA_HEARTBEAT_INTERVAL", 3*time.Second),
+			RebalanceTimeout:  getEnvAsDuration("KAFKA_REBALANCE_TIMEOUT", 30*time.Second),
+			Workers:           getEnvAsInt("KAFKA_WORKERS_PER_TOPIC", 8),
+			MaxInFlight:       getEnvAsInt("KAFKA_MAX_IN_FLIGHT", 256),
+		},
+		Routing: RoutingConfig{
+			File:           getEnv("ROUTING_FILE", ""),
+			ReloadInterval: getEnvAsDuration("ROUTING_RELOAD_INTERVAL", 5*time.Second),
+		},
+		Schema: SchemaConfig{
+			Enabled:        getEnvAsBool("SCHEMA_VALIDATION_ENABLED", true),
+			Dir:            getEnv("SCHEMA_DIR", ""),
+			DefaultVersion: getEnv("SCHEMA_DEFAULT_VERSION", "1"),
+			RequireSchema:  getEnvAsBool("SCHEMA_REQUIRE_ALL", false),
 		},
 		Metrics: MetricsConfig{
 			Enabled: getEnvAsBool("METRICS_ENABLED", true),
 			Port:    getEnv("METRICS_PORT", "9090"),
+
+			TrackedRooms:       getEnvAsSlice("METRICS_TRACKED_ROOMS", nil),
+			RoomTopK:           getEnvAsInt("METRICS_ROOM_TOP_K", 20),
+			RoomMinMembers:     getEnvAsInt("METRICS_ROOM_MIN_MEMBERS", 100),
+			RoomUpdateInterval: getEnvAsDuration("METRICS_ROOM_UPDATE_INTERVAL", 15*time.Second),
+		},
+		Tracing: TracingConfig{
+			Exporter:    getEnv("TRACING_EXPORTER", "none"),
+			Endpoint:    getEnv("TRACING_OTLP_ENDPOINT", "localhost:4318"),
+			Insecure:    getEnvAsBool("TRACING_INSECURE", true),
+			ServiceName: getEnv("TRACING_SERVICE_NAME", "socket-service"),
+			SampleRatio: getEnvAsFloat("TRACING_SAMPLE_RATIO", 1.0),
+		},
+		Revocation: RevocationConfig{
+			TTL:           getEnvAsDuration("REVOCATION_TTL", 24*time.Hour),
+			CacheTTL:      getEnvAsDuration("REVOCATION_CACHE_TTL", 30*time.Second),
+			CheckInterval: getEnvAsDuration("REVOCATION_CHECK_INTERVAL", 30*time.Second),
+		},
+		Admin: AdminConfig{
+			Token: getEnv("ADMIN_API_TOKEN", ""),
+		},
+		RateLimit: RateLimitConfig{
+			TrustedProxies: getEnvAsSlice("TRUSTED_PROXIES", nil),
+			Window:         getEnvAsDuration("RATE_LIMIT_WINDOW", 1*time.Minute),
+			Upgrade:        getEnvAsInt("RATE_LIMIT_UPGRADE", 60),
+			UpgradeBurst:   getEnvAsInt("RATE_LIMIT_UPGRADE_BURST", 20),
+			Admin:          getEnvAsInt("RATE_LIMIT_ADMIN", 30),
+			AdminBurst:     getEnvAsInt("RATE_LIMIT_ADMIN_BURST", 10),
+			Health:         getEnvAsInt("RATE_LIMIT_HEALTH", 600),
+			HealthBurst:    getEnvAsInt("RATE_LIMIT_HEALTH_BURST", 100),
+		},
+		Conns: ConnectionLimitsConfig{
+			MaxPerUser:     getEnvAsInt("WS_MAX_CONNECTIONS_PER_USER", 10),
+			MaxPerIP:       getEnvAsInt("WS_MAX_CONNECTIONS_PER_IP", 0),
+			MaxPerInstance: getEnvAsInt("WS_MAX_CONNECTIONS", 10000),
+			Policy:         getEnv("WS_CONNECTION_LIMIT_POLICY", "reject"),
+			LeaseTTL:       getEnvAsDuration("WS_CONNECTION_LEASE_TTL", 90*time.Second),
+		},
+		Proctoring: ProctoringConfig{
+			ExclusiveTTL: getEnvAsDuration("PROCTORING_EXCLUSIVE_TTL", 48*time.Hour),
+			SignalTopic:  getEnv("KAFKA_PROCTORING_SIGNAL_TOPIC", "proctoring.signal"),
+		},
+		Contest: ContestConfig{
+			TickInterval: getEnvAsDuration("CONTEST_TICK_INTERVAL", 30*time.Second),
+			TimeLeft: getEnvAsDurationSlice("CONTEST_TIME_LEFT_MILESTONES", []time.Duration{
+				time.Hour, 15 * time.Minute, 5 * time.Minute, time.Minute,
+			}),
+			TeamLookupURL:       getEnv("CONTEST_TEAM_LOOKUP_URL", ""),
+			TeamLookupTimeout:   getEnvAsDuration("CONTEST_TEAM_LOOKUP_TIMEOUT", 2*time.Second),
+			AnnouncementHistory: getEnvAsInt("CONTEST_ANNOUNCEMENT_HISTORY", 50),
+		},
+		Rooms: RoomsConfig{
+			PropagatingTypes: getEnvAsSlice("WS_PROPAGATING_ROOM_TYPES", nil),
+			History: getEnvAsMap("WS_ROOM_HISTORY", map[string]string{
+				"contest/LEADERBOARD_UPDATE": "1",
+				"contest/CONTEST_EVENT":      "10:1h",
+			}),
+			PersistHistory: getEnvAsBool("WS_ROOM_HISTORY_PERSIST", true),
+		},
+		Limits: LimitsConfig{
+			MessageRate:  getEnvAsFloat("WS_MESSAGE_RATE", 20),
+			MessageBurst: getEnvAsInt("WS_MESSAGE_BURST", 40),
+			UserRate:     getEnvAsFloat("WS_USER_MESSAGE_RATE", 50),
+			UserBurst:    getEnvAsInt("WS_USER_MESSAGE_BURST", 100),
+			TypeRates: getEnvAsMap("WS_MESSAGE_RATES_BY_TYPE", map[string]string{
+				"JOIN_ROOM":         "5:20",
+				"LEAVE_ROOM":        "5:20",
+				"AUTHENTICATE":      "0.2:3",
+				"TIME_SYNC":         "2:10",
+				"GET_CONTEST_STATE": "2:10",
+				"MARK_READ":         "2:10",
+				"GET_UNREAD":        "2:10",
+			}),
+			MaxRoomsPerClient: getEnvAsInt("WS_MAX_ROOMS_PER_CLIENT", 100),
+			ViolationWindow:   getEnvAsDuration("WS_VIOLATION_WINDOW", 1*time.Minute),
+			MuteAfter:         getEnvAsInt("WS_MUTE_AFTER", 10),
+			MuteDuration:      getEnvAsDuration("WS_MUTE_DURATION", 30*time.Second),
+			DisconnectAfter:   getEnvAsInt("WS_DISCONNECT_AFTER", 50),
 		},
 	}
 }
 
+// defaultTopicHandlers maps every known topic to the handler of the same name.
+func defaultTopicHandlers() map[string]string {
+	topics := []string{
+		"submission.created",
+		"submission.judged",
+		"leaderboard.updated",
+		"leaderboard.frozen",
+		"leaderboard.unfrozen",
+		"contest.created",
+		"contest.started",
+		"contest.ended",
+		"contest.participant.registered",
+		"contest.participant.unregistered",
+		"contest.team.registered",
+		"contest.clarification.requested",
+		"contest.clarification.answered",
+		"contest.announcement",
+		"contest.problem.added",
+		"contest.problem.removed",
+		"proctoring.violation",
+		"user.session.revoked",
+	}
+
+	handlers := make(map[string]string, len(topics))
+	for _, topic := range topics {
+		handlers[topic] = topic
+	}
+	return handlers
+}
+
+func topicsOf(topicHandlers map[string]string) []string {
+	topics := make([]string, 0, len(topicHandlers))
+	for topic := range topicHandlers {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -102,6 +414,15 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if floatVal, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatVal
+		}
+	}
+	return defaultValue
+}
+
 func getEnvAsBool(key string, defaultValue bool) bool {
 	if value := os.Getenv(key); value != "" {
 		if boolVal, err := strconv.ParseBool(value); err == nil {
@@ -117,3 +438,47 @@ func getEnvAsSlice(key string, defaultValue []string) []string {
 	}
 	return defaultValue
 }
+
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+// getEnvAsDurationSlice parses a comma separated list of durations,
+// skipping malformed entries.
+func getEnvAsDurationSlice(key string, defaultValue []time.Duration) []time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	var result []time.Duration
+	for _, item := range strings.Split(value, ",") {
+		if d, err := time.ParseDuration(strings.TrimSpace(item)); err == nil && d > 0 {
+			result = append(result, d)
+		}
+	}
+	return result
+}
+
+// getEnvAsMap parses a comma separated list of key=value pairs.
+func getEnvAsMap(key string, defaultValue map[string]string) map[string]string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	result := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || k == "" || v == "" {
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
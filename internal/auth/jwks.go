@@ -0,0 +1,351 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultJWKSRefreshInterval = 10 * time.Minute
+	// minJWKSRefreshInterval throttles the refreshes triggered by tokens
+	// signed with an unknown kid.
+	minJWKSRefreshInterval = 30 * time.Second
+	jwksFetchTimeout       = 10 * time.Second
+	maxJWKSSize            = 1 << 20
+)
+
+var ErrUnknownKey = errors.New("no verification key for token")
+
+// JWKSConfig selects where public keys are loaded from. Exactly one of URL
+// and File should be set.
+type JWKSConfig struct {
+	URL             string
+	File            string
+	RefreshInterval time.Duration
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+type publicKey struct {
+	kid string
+	alg string
+	key interface{}
+}
+
+// KeySet holds the public keys of a JSON Web Key Set and refreshes them
+// periodically so signing keys can be rotated without a restart.
+type KeySet struct {
+	config JWKSConfig
+	client *http.Client
+	logger zerolog.Logger
+
+	keys []publicKey
+	mu   sync.RWMutex
+
+	// lastAttempt is when the last refresh started, successful or not. It
+	// is guarded by refreshMu.
+	lastAttempt time.Time
+	refreshMu   sync.Mutex
+
+	stop chan struct{}
+}
+
+// NewKeySet loads the key set once and fails if it cannot be read, so a
+// misconfigured source is caught at startup.
+func NewKeySet(config JWKSConfig, logger zerolog.Logger) (*KeySet, error) {
+	if config.URL == "" && config.File == "" {
+		return nil, errors.New("JWKS URL or file is required")
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = defaultJWKSRefreshInterval
+	}
+
+	ks := &KeySet{
+		config: config,
+		client: &http.Client{Timeout: jwksFetchTimeout},
+		logger: logger.With().Str("component", "jwks").Logger(),
+		stop:   make(chan struct{}),
+	}
+
+	if err := ks.Refresh(context.Background()); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Start refreshes the key set every RefreshInterval. Failed refreshes are
+// logged and the previous keys stay active.
+func (ks *KeySet) Start() {
+	ticker := time.NewTicker(ks.config.RefreshInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := ks.Refresh(context.Background()); err != nil {
+					ks.logger.Error().Err(err).Msg("Failed to refresh JWKS, keeping previous keys")
+				}
+			case <-ks.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (ks *KeySet) Stop() {
+	close(ks.stop)
+}
+
+func (ks *KeySet) Refresh(ctx context.Context) error {
+	ks.refreshMu.Lock()
+	defer ks.refreshMu.Unlock()
+	return ks.refresh(ctx)
+}
+
+// refresh must be called with refreshMu held.
+func (ks *KeySet) refresh(ctx context.Context) error {
+	ks.lastAttempt = time.Now()
+
+	data, err := ks.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	keys, err := ks.parse(data)
+	if err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.mu.Unlock()
+
+	ks.logger.Info().Int("keys", len(keys)).Msg("JWKS loaded")
+	return nil
+}
+
+func (ks *KeySet) fetch(ctx context.Context) ([]byte, error) {
+	if ks.config.File != "" {
+		data, err := os.ReadFile(ks.config.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+		}
+		return data, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, jwksFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.config.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build JWKS request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS response: %w", err)
+	}
+	return data, nil
+}
+
+func (ks *KeySet) parse(data []byte) ([]publicKey, error) {
+	var set jwkSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := make([]publicKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			ks.logger.Warn().Err(err).Str("kid", k.Kid).Str("kty", k.Kty).Msg("Skipping unusable JWK")
+			continue
+		}
+		keys = append(keys, publicKey{kid: k.Kid, alg: k.Alg, key: key})
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS contains no usable signing keys")
+	}
+	return keys, nil
+}
+
+// Keys returns the candidate verification keys for a token. A token with a
+// kid that is not in the set triggers a refresh, which picks up keys
+// published after the last scheduled refresh. Refreshes are throttled
+// whether or not they succeed, so tokens with made-up kids cannot make
+// every validation fetch the key set.
+func (ks *KeySet) Keys(kid string, method jwt.SigningMethod) []jwt.VerificationKey {
+	keys := ks.match(kid, method)
+	if len(keys) > 0 || kid == "" {
+		return keys
+	}
+
+	ks.refreshMu.Lock()
+	defer ks.refreshMu.Unlock()
+
+	// A refresh that finished while we waited may have loaded the key or
+	// just failed; either way there is nothing to fetch yet.
+	if keys := ks.match(kid, method); len(keys) > 0 {
+		return keys
+	}
+	if time.Since(ks.lastAttempt) < minJWKSRefreshInterval {
+		return nil
+	}
+
+	if err := ks.refresh(context.Background()); err != nil {
+		ks.logger.Error().Err(err).Str("kid", kid).Msg("Failed to refresh JWKS for unknown key")
+		return nil
+	}
+	return ks.match(kid, method)
+}
+
+func (ks *KeySet) match(kid string, method jwt.SigningMethod) []jwt.VerificationKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	var keys []jwt.VerificationKey
+	for _, k := range ks.keys {
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		if k.alg != "" && k.alg != method.Alg() {
+			continue
+		}
+		if !keyFitsMethod(k.key, method) {
+			continue
+		}
+		keys = append(keys, k.key)
+	}
+	return keys
+}
+
+func keyFitsMethod(key interface{}, method jwt.SigningMethod) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		switch method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			return true
+		}
+	case *ecdsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodECDSA)
+		return ok
+	case ed25519.PublicKey:
+		_, ok := method.(*jwt.SigningMethodEd25519)
+		return ok
+	}
+	return false
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x coordinate: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y coordinate: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported OKP curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Ed25519 key: %w", err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, errors.New("missing value")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog"
+)
+
+// jwksServer serves a key set that tests can replace or break.
+type jwksServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	keys     []map[string]string
+	failing  bool
+	requests int
+}
+
+func newJWKSServer(t *testing.T, keys ...map[string]string) *jwksServer {
+	t.Helper()
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		if s.failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) publish(keys ...map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func (s *jwksServer) fail() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = true
+}
+
+func (s *jwksServer) requestCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// toJWK returns the public JWK of a private key.
+func toJWK(t *testing.T, kid string, key crypto.Signer) map[string]string {
+	t.Helper()
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig",
+			"n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return map[string]string{"kty": "EC", "kid": kid, "crv": pub.Curve.Params().Name,
+			"x": b64(pub.X.FillBytes(make([]byte, size))), "y": b64(pub.Y.FillBytes(make([]byte, size)))}
+	case ed25519.PublicKey:
+		return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "x": b64(pub)}
+	}
+	t.Fatalf("unsupported key type %T", key)
+	return nil
+}
+
+func generateKey(t *testing.T, method jwt.SigningMethod) crypto.Signer {
+	t.Helper()
+	var key crypto.Signer
+	var err error
+	switch method.(type) {
+	case *jwt.SigningMethodRSA:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case *jwt.SigningMethodECDSA:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case *jwt.SigningMethodEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		t.Fatalf("unsupported method %s", method.Alg())
+	}
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return key
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims *Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return signed
+}
+
+func userClaims(sub string) *Claims {
+	return &Claims{
+		Sub: sub,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+}
+
+func newKeySetValidator(t *testing.T, url string) (*KeySet, *JWTValidator) {
+	t.Helper()
+	ks, err := NewKeySet(JWKSConfig{URL: url}, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	v, err := NewJWTValidator(ValidatorConfig{KeySet: ks})
+	if err != nil {
+		t.Fatalf("NewJWTValidator: %v", err)
+	}
+	return ks, v
+}
+
+// allowRefresh lifts the throttle on refreshes for unknown kids.
+func allowRefresh(ks *KeySet) {
+	ks.refreshMu.Lock()
+	ks.lastAttempt = time.Now().Add(-minJWKSRefreshInterval)
+	ks.refreshMu.Unlock()
+}
+
+func TestKeySetAlgorithms(t *testing.T) {
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+	}{
+		{"RS256", jwt.SigningMethodRS256},
+		{"ES256", jwt.SigningMethodES256},
+		{"EdDSA", jwt.SigningMethodEdDSA},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := generateKey(t, tt.method)
+			server := newJWKSServer(t, toJWK(t, "k1", key))
+			_, v := newKeySetValidator(t, server.URL)
+
+			claims, err := v.ValidateToken(signToken(t, tt.method, key, "k1", userClaims("user-1")))
+			if err != nil {
+				t.Fatalf("ValidateToken: %v", err)
+			}
+			if claims.GetUserID() != "user-1" {
+				t.Errorf("user = %q, want user-1", claims.GetUserID())
+			}
+
+			other := generateKey(t, tt.method)
+			if _, err := v.ValidateToken(signToken(t, tt.method, other, "k1", userClaims("user-1"))); err == nil {
+				t.Error("token signed with an unpublished key was accepted")
+			}
+		})
+	}
+}
+
+func TestKeySetSwitchesToNewKid(t *testing.T) {
+	oldKey := generateKey(t, jwt.SigningMethodES256)
+	newKey := generateKey(t, jwt.SigningMethodES256)
+	server := newJWKSServer(t, toJWK(t, "old", oldKey))
+	ks, v := newKeySetValidator(t, server.URL)
+
+	oldToken := signToken(t, jwt.SigningMethodES256, oldKey, "old", userClaims("user-1"))
+	newToken := signToken(t, jwt.SigningMethodES256, newKey, "new", userClaims("user-1"))
+
+	server.publish(toJWK(t, "new", newKey))
+	if _, err := v.ValidateToken(newToken); err == nil {
+		t.Fatal("new kid was accepted before the refresh throttle passed")
+	}
+
+	allowRefresh(ks)
+	if _, err := v.ValidateToken(newToken); err != nil {
+		t.Fatalf("new kid after refresh: %v", err)
+	}
+	if _, err := v.ValidateToken(oldToken); err == nil {
+		t.Error("retired kid is still accepted")
+	}
+}
+
+func TestKeySetThrottlesFailedRefreshes(t *testing.T) {
+	key := generateKey(t, jwt.SigningMethodRS256)
+	server := newJWKSServer(t, toJWK(t, "k1", key))
+	ks, v := newKeySetValidator(t, server.URL)
+
+	server.fail()
+	allowRefresh(ks)
+	before := server.requestCount()
+
+	unknown := signToken(t, jwt.SigningMethodRS256, key, "unknown", userClaims("user-1"))
+	for i := 0; i < 5; i++ {
+		if _, err := v.ValidateToken(unknown); err == nil {
+			t.Fatal("token with unknown kid was accepted")
+		}
+	}
+	if got := server.requestCount() - before; got != 1 {
+		t.Errorf("fetched the key set %d times, want 1", got)
+	}
+
+	if _, err := v.ValidateToken(signToken(t, jwt.SigningMethodRS256, key, "k1", userClaims("user-1"))); err != nil {
+		t.Errorf("known kid after failed refresh: %v", err)
+	}
+}
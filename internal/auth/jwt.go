@@ -1,8 +1,10 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -11,36 +13,122 @@ var (
 	ErrInvalidToken  = errors.New("invalid token")
 	ErrExpiredToken  = errors.New("token has expired")
 	ErrInvalidClaims = errors.New("invalid token claims")
+	ErrRevokedToken  = errors.New("token has been revoked")
+)
+
+// RevocationChecker reports whether a token has been revoked after it was
+// issued; *revocation.Store satisfies it.
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, userID, tokenID string, issuedAt time.Time) (bool, error)
+}
+
+var (
+	hmacMethods       = []string{"HS256", "HS384", "HS512"}
+	asymmetricMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
 )
 
 type Claims struct {
 	Sub   string `json:"sub"`
 	Email string `json:"email"`
 	Role  int    `json:"role"`
+	// Roles and Scope are optional alternatives to Role; see
+	// PermissionModel.Resolve.
+	Roles []string `json:"roles,omitempty"`
+	Scope string   `json:"scope,omitempty"`
+	// Orgs are the organizations or groups the user belongs to, whose
+	// "org:<id>" rooms they may join.
+	Orgs []string `json:"orgs,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// ValidatorConfig configures token verification. Secrets enables HMAC and
+// KeySet enables RSA, ECDSA and EdDSA; at least one of them is required.
+type ValidatorConfig struct {
+	// Secrets are all accepted HMAC secrets. Listing the old and the new
+	// secret during a rotation keeps existing tokens valid.
+	Secrets []string
+	KeySet  *KeySet
+	// Issuer and Audience are enforced when non-empty. A token must carry
+	// at least one of the configured audiences.
+	Issuer    string
+	Audience  []string
+	ClockSkew time.Duration
+}
+
 type JWTValidator struct {
-	secret []byte
+	secrets     []jwt.VerificationKey
+	keys        *KeySet
+	parser      *jwt.Parser
+	revocations RevocationChecker
 }
 
-func NewJWTValidator(secret string) *JWTValidator {
-	return &JWTValidator{
-		secret: []byte(secret),
+func NewJWTValidator(config ValidatorConfig) (*JWTValidator, error) {
+	v := &JWTValidator{keys: config.KeySet}
+
+	var methods []string
+	for _, secret := range config.Secrets {
+		if secret != "" {
+			v.secrets = append(v.secrets, []byte(secret))
+		}
+	}
+	if len(v.secrets) > 0 {
+		methods = append(methods, hmacMethods...)
+	}
+	if v.keys != nil {
+		methods = append(methods, asymmetricMethods...)
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("a JWT secret or JWKS source is required")
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithLeeway(config.ClockSkew),
 	}
+	if config.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(config.Issuer))
+	}
+	if len(config.Audience) > 0 {
+		opts = append(opts, jwt.WithAudience(config.Audience...))
+	}
+	v.parser = jwt.NewParser(opts...)
+
+	return v, nil
+}
+
+// SetRevocations makes ValidateToken reject revoked tokens. Lookup errors
+// are treated as not revoked so a Redis outage does not lock everyone out.
+func (v *JWTValidator) SetRevocations(checker RevocationChecker) {
+	v.revocations = checker
+}
+
+func (v *JWTValidator) keyFunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return jwt.VerificationKeySet{Keys: v.secrets}, nil
+	}
+	if v.keys == nil {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	keys := v.keys.Keys(kid, token.Method)
+	if len(keys) == 0 {
+		return nil, ErrUnknownKey
+	}
+	return jwt.VerificationKeySet{Keys: keys}, nil
 }
 
 func (v *JWTValidator) ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return v.secret, nil
-	})
+	token, err := v.parser.ParseWithClaims(tokenString, &Claims{}, v.keyFunc)
 
 	if err != nil {
-		if errors.Is(err, jwt.ErrTokenExpired) {
+		switch {
+		case errors.Is(err, jwt.ErrTokenExpired):
 			return nil, ErrExpiredToken
+		case errors.Is(err, jwt.ErrTokenInvalidIssuer),
+			errors.Is(err, jwt.ErrTokenInvalidAudience),
+			errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+			return nil, ErrInvalidClaims
 		}
 		return nil, ErrInvalidToken
 	}
@@ -50,9 +138,24 @@ func (v *JWTValidator) ValidateToken(tokenString string) (*Claims, error) {
 		return nil, ErrInvalidClaims
 	}
 
+	if err := v.checkRevoked(claims); err != nil {
+		return nil, err
+	}
+
 	return claims, nil
 }
 
+func (v *JWTValidator) checkRevoked(claims *Claims) error {
+	if v.revocations == nil {
+		return nil
+	}
+	revoked, err := v.revocations.IsRevoked(context.Background(), claims.GetUserID(), claims.ID, claims.IssuedAtTime())
+	if err == nil && revoked {
+		return ErrRevokedToken
+	}
+	return nil
+}
+
 func (c *Claims) GetUserID() string {
 	return c.Sub
 }
@@ -64,3 +167,11 @@ func (c *Claims) GetEmail() string {
 func (c *Claims) GetRole() int {
 	return c.Role
 }
+
+// IssuedAtTime returns the iat claim, or the zero time when it is absent.
+func (c *Claims) IssuedAtTime() time.Time {
+	if c.IssuedAt == nil {
+		return time.Time{}
+	}
+	return c.IssuedAt.Time
+}
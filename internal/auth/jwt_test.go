@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestValidatorHMACRotation(t *testing.T) {
+	v, err := NewJWTValidator(ValidatorConfig{Secrets: []string{"new-secret", "old-secret", ""}})
+	if err != nil {
+		t.Fatalf("NewJWTValidator: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		valid  bool
+	}{
+		{"current secret", "new-secret", true},
+		{"previous secret", "old-secret", true},
+		{"unknown secret", "other-secret", false},
+		{"empty secret", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signToken(t, jwt.SigningMethodHS256, []byte(tt.secret), "", userClaims("user-1"))
+			_, err := v.ValidateToken(token)
+			if tt.valid && err != nil {
+				t.Errorf("ValidateToken: %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("err = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
+
+func TestValidatorRejectsAsymmetricWithoutKeySet(t *testing.T) {
+	v, err := NewJWTValidator(ValidatorConfig{Secrets: []string{"secret"}})
+	if err != nil {
+		t.Fatalf("NewJWTValidator: %v", err)
+	}
+
+	key := generateKey(t, jwt.SigningMethodRS256)
+	if _, err := v.ValidateToken(signToken(t, jwt.SigningMethodRS256, key, "k1", userClaims("user-1"))); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("err = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestValidatorClaims(t *testing.T) {
+	v, err := NewJWTValidator(ValidatorConfig{
+		Secrets:   []string{"secret"},
+		Issuer:    "https://auth.example.com",
+		Audience:  []string{"socket", "api"},
+		ClockSkew: 30 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewJWTValidator: %v", err)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name    string
+		modify  func(c *jwt.RegisteredClaims)
+		wantErr error
+	}{
+		{"valid", func(c *jwt.RegisteredClaims) {}, nil},
+		{"second audience", func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"api"} }, nil},
+		{"wrong issuer", func(c *jwt.RegisteredClaims) { c.Issuer = "https://evil.example.com" }, ErrInvalidClaims},
+		{"missing issuer", func(c *jwt.RegisteredClaims) { c.Issuer = "" }, ErrInvalidClaims},
+		{"wrong audience", func(c *jwt.RegisteredClaims) { c.Audience = jwt.ClaimStrings{"admin"} }, ErrInvalidClaims},
+		{"missing audience", func(c *jwt.RegisteredClaims) { c.Audience = nil }, ErrInvalidClaims},
+		{"expired within skew", func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-10 * time.Second)) }, nil},
+		{"expired beyond skew", func(c *jwt.RegisteredClaims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-time.Minute)) }, ErrExpiredToken},
+		{"not yet valid within skew", func(c *jwt.RegisteredClaims) { c.NotBefore = jwt.NewNumericDate(now.Add(10 * time.Second)) }, nil},
+		{"not yet valid beyond skew", func(c *jwt.RegisteredClaims) { c.NotBefore = jwt.NewNumericDate(now.Add(time.Minute)) }, ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := userClaims("user-1")
+			claims.Issuer = "https://auth.example.com"
+			claims.Audience = jwt.ClaimStrings{"socket"}
+			tt.modify(&claims.RegisteredClaims)
+
+			_, err := v.ValidateToken(signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", claims))
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("ValidateToken: %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
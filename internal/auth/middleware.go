@@ -4,23 +4,62 @@ import (
 	"context"
 	"net/http"
 	"strings"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
 )
 
 type contextKey string
 
 const UserContextKey contextKey = "user"
 
-func AuthMiddleware(validator *JWTValidator) func(http.Handler) http.Handler {
+const (
+	// Subprotocol is the application subprotocol the server selects during
+	// the upgrade. Browser clients that pass credentials in
+	// Sec-WebSocket-Protocol must offer it alongside them.
+	Subprotocol = "cdex.v1"
+
+	bearerProtocolPrefix = "bearer."
+	ticketProtocolPrefix = "ticket."
+)
+
+// MiddlewareConfig selects the credential sources AuthMiddleware accepts in
+// addition to the Authorization header and Sec-WebSocket-Protocol.
+type MiddlewareConfig struct {
+	// Tickets enables single-use connection tickets, passed as ?ticket= or
+	// as a "ticket.<ticket>" subprotocol.
+	Tickets *TicketStore
+	// AllowQueryToken accepts a JWT in ?token=. Tokens in URLs end up in
+	// access and proxy logs, so this is only for legacy clients.
+	AllowQueryToken bool
+}
+
+type credentials struct {
+	token  string
+	ticket string
+}
+
+func AuthMiddleware(validator *JWTValidator, config MiddlewareConfig, m *metrics.Metrics) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			token := extractToken(r)
-			if token == "" {
+			creds := extractCredentials(r, config)
+			if creds.token == "" && creds.ticket == "" {
+				m.IncAuthFailures()
 				http.Error(w, "Unauthorized: missing token", http.StatusUnauthorized)
 				return
 			}
 
-			claims, err := validator.ValidateToken(token)
+			var claims *Claims
+			var err error
+			if creds.ticket != "" {
+				claims, err = config.Tickets.Redeem(r.Context(), creds.ticket)
+				if err == nil {
+					err = validator.checkRevoked(claims)
+				}
+			} else {
+				claims, err = validator.ValidateToken(creds.token)
+			}
 			if err != nil {
+				m.IncAuthFailures()
 				http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
 				return
 			}
@@ -31,11 +70,36 @@ func AuthMiddleware(validator *JWTValidator) func(http.Handler) http.Handler {
 	}
 }
 
-func extractToken(r *http.Request) string {
-	if token := r.URL.Query().Get("token"); token != "" {
-		return token
+func extractCredentials(r *http.Request, config MiddlewareConfig) credentials {
+	if config.Tickets != nil {
+		if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+			return credentials{ticket: ticket}
+		}
+	}
+
+	for _, protocol := range websocketProtocols(r) {
+		if token, ok := strings.CutPrefix(protocol, bearerProtocolPrefix); ok && token != "" {
+			return credentials{token: token}
+		}
+		if ticket, ok := strings.CutPrefix(protocol, ticketProtocolPrefix); ok && ticket != "" && config.Tickets != nil {
+			return credentials{ticket: ticket}
+		}
+	}
+
+	if token := extractBearerToken(r); token != "" {
+		return credentials{token: token}
 	}
 
+	if config.AllowQueryToken {
+		if token := r.URL.Query().Get("token"); token != "" {
+			return credentials{token: token}
+		}
+	}
+
+	return credentials{}
+}
+
+func extractBearerToken(r *http.Request) string {
 	authHeader := r.Header.Get("Authorization")
 	if authHeader != "" {
 		parts := strings.SplitN(authHeader, " ", 2)
@@ -47,6 +111,43 @@ func extractToken(r *http.Request) string {
 	return ""
 }
 
+func websocketProtocols(r *http.Request) []string {
+	var protocols []string
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+	return protocols
+}
+
+// HeaderAuthMiddleware authenticates plain HTTP requests from the
+// Authorization header only.
+func HeaderAuthMiddleware(validator *JWTValidator, m *metrics.Metrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := extractBearerToken(r)
+			if token == "" {
+				m.IncAuthFailures()
+				http.Error(w, "Unauthorized: missing token", http.StatusUnauthorized)
+				return
+			}
+
+			claims, err := validator.ValidateToken(token)
+			if err != nil {
+				m.IncAuthFailures()
+				http.Error(w, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserContextKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
 func GetUserFromContext(ctx context.Context) *Claims {
 	claims, ok := ctx.Value(UserContextKey).(*Claims)
 	if !ok {
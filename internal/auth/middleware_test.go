@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+)
+
+func TestExtractCredentials(t *testing.T) {
+	tickets := &TicketStore{}
+
+	tests := []struct {
+		name   string
+		url    string
+		header http.Header
+		config MiddlewareConfig
+		want   credentials
+	}{
+		{"authorization header", "/ws", http.Header{"Authorization": {"Bearer tok"}}, MiddlewareConfig{}, credentials{token: "tok"}},
+		{"bearer subprotocol", "/ws", http.Header{"Sec-Websocket-Protocol": {"cdex.v1, bearer.tok"}}, MiddlewareConfig{}, credentials{token: "tok"}},
+		{"ticket subprotocol", "/ws", http.Header{"Sec-Websocket-Protocol": {"cdex.v1, ticket.tk"}}, MiddlewareConfig{Tickets: tickets}, credentials{ticket: "tk"}},
+		{"ticket subprotocol without tickets", "/ws", http.Header{"Sec-Websocket-Protocol": {"ticket.tk"}}, MiddlewareConfig{}, credentials{}},
+		{"ticket query", "/ws?ticket=tk", nil, MiddlewareConfig{Tickets: tickets}, credentials{ticket: "tk"}},
+		{"query token refused by default", "/ws?token=tok", nil, MiddlewareConfig{}, credentials{}},
+		{"query token allowed", "/ws?token=tok", nil, MiddlewareConfig{AllowQueryToken: true}, credentials{token: "tok"}},
+		{"empty bearer subprotocol", "/ws", http.Header{"Sec-Websocket-Protocol": {"bearer."}}, MiddlewareConfig{}, credentials{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			for name, values := range tt.header {
+				r.Header[name] = values
+			}
+			if got := extractCredentials(r, tt.config); got != tt.want {
+				t.Errorf("extractCredentials() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareQueryToken(t *testing.T) {
+	v, err := NewJWTValidator(ValidatorConfig{Secrets: []string{"secret"}})
+	if err != nil {
+		t.Fatalf("NewJWTValidator: %v", err)
+	}
+	token := signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims("user-1"))
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name   string
+		config MiddlewareConfig
+		want   int
+	}{
+		{"refused by default", MiddlewareConfig{}, http.StatusUnauthorized},
+		{"allowed for legacy clients", MiddlewareConfig{AllowQueryToken: true}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			AuthMiddleware(v, tt.config, nil)(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ws?token="+token, nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+// The server must select the application subprotocol, never a credential,
+// or browsers fail the handshake and tokens leak into responses.
+func TestAuthMiddlewareSubprotocol(t *testing.T) {
+	v, err := NewJWTValidator(ValidatorConfig{Secrets: []string{"secret"}})
+	if err != nil {
+		t.Fatalf("NewJWTValidator: %v", err)
+	}
+	store, _ := newTestTicketStore(t)
+
+	upgrader := websocket.Upgrader{Subprotocols: []string{Subprotocol}}
+	var user string
+	server := httptest.NewServer(AuthMiddleware(v, MiddlewareConfig{Tickets: store}, nil)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user = GetUserFromContext(r.Context()).GetUserID()
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err == nil {
+				conn.Close()
+			}
+		})))
+	defer server.Close()
+
+	ticket, _, err := store.Issue(context.Background(), userClaims("user-2"))
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		credential string
+		user       string
+	}{
+		{"bearer", bearerProtocolPrefix + signToken(t, jwt.SigningMethodHS256, []byte("secret"), "", userClaims("user-1")), "user-1"},
+		{"ticket", ticketProtocolPrefix + ticket, "user-2"},
+	}
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := websocket.Dialer{Subprotocols: []string{Subprotocol, tt.credential}}
+			conn, resp, err := dialer.Dial(url, nil)
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer conn.Close()
+
+			if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != Subprotocol {
+				t.Errorf("selected subprotocol = %q, want %q", got, Subprotocol)
+			}
+			if user != tt.user {
+				t.Errorf("authenticated user = %q, want %q", user, tt.user)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Role string
+
+const (
+	RoleContestant Role = "contestant"
+	RoleProctor    Role = "proctor"
+	RoleSetter     Role = "setter"
+	RoleAdmin      Role = "admin"
+)
+
+type Permission string
+
+const (
+	PermJoinStaffRooms    Permission = "rooms:join_staff"
+	PermViewFrozenResults Permission = "leaderboard:view_frozen"
+	PermServerPush        Permission = "messages:push"
+	PermAdminCommands     Permission = "admin:commands"
+)
+
+// Permissions is the resolved set of roles and permissions of a principal.
+// The zero value grants nothing.
+type Permissions struct {
+	roles   []Role
+	granted map[Permission]bool
+}
+
+func (p Permissions) Has(perm Permission) bool {
+	return p.granted[perm]
+}
+
+func (p Permissions) HasRole(role Role) bool {
+	for _, r := range p.roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Permissions) Roles() []Role {
+	return p.roles
+}
+
+// PermissionModel maps the numeric role claim and role names to the
+// permissions they grant.
+type PermissionModel struct {
+	roleIDs map[int]Role
+	grants  map[Role][]Permission
+}
+
+// DefaultPermissionModel maps role IDs 0-3 to contestant, proctor, setter
+// and admin.
+func DefaultPermissionModel() *PermissionModel {
+	return &PermissionModel{
+		roleIDs: map[int]Role{
+			0: RoleContestant,
+			1: RoleProctor,
+			2: RoleSetter,
+			3: RoleAdmin,
+		},
+		grants: map[Role][]Permission{
+			RoleContestant: nil,
+			RoleProctor:    {PermJoinStaffRooms, PermViewFrozenResults},
+			RoleSetter:     {PermJoinStaffRooms, PermViewFrozenResults, PermServerPush},
+			RoleAdmin:      {PermJoinStaffRooms, PermViewFrozenResults, PermServerPush, PermAdminCommands},
+		},
+	}
+}
+
+// NewPermissionModel builds a model from configuration. roleIDs maps role ID
+// strings to role names ("3" -> "admin") and grants maps role names to
+// "|"-separated permissions ("proctor" -> "rooms:join_staff|..."). Empty
+// maps keep the defaults.
+func NewPermissionModel(roleIDs, grants map[string]string) (*PermissionModel, error) {
+	model := DefaultPermissionModel()
+
+	if len(roleIDs) > 0 {
+		model.roleIDs = make(map[int]Role, len(roleIDs))
+		for id, name := range roleIDs {
+			roleID, err := strconv.Atoi(id)
+			if err != nil {
+				return nil, fmt.Errorf("invalid role ID %q: %w", id, err)
+			}
+			model.roleIDs[roleID] = Role(name)
+		}
+	}
+
+	if len(grants) > 0 {
+		model.grants = make(map[Role][]Permission, len(grants))
+		for name, perms := range grants {
+			var list []Permission
+			for _, perm := range strings.Split(perms, "|") {
+				if perm = strings.TrimSpace(perm); perm != "" {
+					list = append(list, Permission(perm))
+				}
+			}
+			model.grants[Role(name)] = list
+		}
+	}
+
+	return model, nil
+}
+
+// Resolve derives the permissions of claims from the numeric role, the
+// roles claim and any scope entries that name a role or a permission.
+func (m *PermissionModel) Resolve(claims *Claims) Permissions {
+	roles := make(map[Role]bool)
+	granted := make(map[Permission]bool)
+
+	if role, ok := m.roleIDs[claims.Role]; ok {
+		roles[role] = true
+	}
+	for _, name := range claims.Roles {
+		roles[Role(name)] = true
+	}
+	for _, entry := range strings.Fields(claims.Scope) {
+		if _, ok := m.grants[Role(entry)]; ok {
+			roles[Role(entry)] = true
+		} else {
+			granted[Permission(entry)] = true
+		}
+	}
+
+	list := make([]Role, 0, len(roles))
+	for role := range roles {
+		list = append(list, role)
+		for _, perm := range m.grants[role] {
+			granted[perm] = true
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+
+	return Permissions{roles: list, granted: granted}
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
+	redisclient "github.com/CDeX-Labs/CDeX-Socket-Service/internal/redis"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+const (
+	ticketKeyFmt     = "ws:ticket:%s"
+	ticketBytes      = 32
+	defaultTicketTTL = 30 * time.Second
+)
+
+var ErrInvalidTicket = errors.New("invalid or used connection ticket")
+
+// TicketStore issues short-lived, single-use connection tickets so browsers
+// can open /ws without putting a long-lived JWT in the URL.
+type TicketStore struct {
+	redis   *redisclient.Client
+	ttl     time.Duration
+	metrics *metrics.Metrics
+}
+
+func NewTicketStore(redis *redisclient.Client, ttl time.Duration, m *metrics.Metrics) *TicketStore {
+	if ttl <= 0 {
+		ttl = defaultTicketTTL
+	}
+	return &TicketStore{
+		redis:   redis,
+		ttl:     ttl,
+		metrics: m,
+	}
+}
+
+// Issue stores claims under a new random ticket and returns the ticket and
+// when it expires.
+func (s *TicketStore) Issue(ctx context.Context, claims *Claims) (string, time.Time, error) {
+	buf := make([]byte, ticketBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate ticket: %w", err)
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(buf)
+
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	err = s.redis.Set(ctx, fmt.Sprintf(ticketKeyFmt, ticket), data, s.ttl)
+	s.metrics.IncRedisOperation("ticket_issue", metrics.Status(err))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to store ticket: %w", err)
+	}
+
+	return ticket, time.Now().Add(s.ttl), nil
+}
+
+// Redeem consumes ticket and returns the claims it was issued for. A ticket
+// can be redeemed only once.
+func (s *TicketStore) Redeem(ctx context.Context, ticket string) (*Claims, error) {
+	data, err := s.redis.GetDel(ctx, fmt.Sprintf(ticketKeyFmt, ticket))
+	if errors.Is(err, goredis.Nil) {
+		s.metrics.IncRedisOperation("ticket_redeem", "ok")
+		return nil, ErrInvalidTicket
+	}
+	s.metrics.IncRedisOperation("ticket_redeem", metrics.Status(err))
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeem ticket: %w", err)
+	}
+
+	var claims Claims
+	if err := json.Unmarshal([]byte(data), &claims); err != nil {
+		return nil, ErrInvalidTicket
+	}
+	if claims.ExpiresAt != nil && claims.ExpiresAt.Before(time.Now()) {
+		return nil, ErrExpiredToken
+	}
+	return &claims, nil
+}
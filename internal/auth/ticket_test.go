@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	redisclient "github.com/CDeX-Labs/CDeX-Socket-Service/internal/redis"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog"
+)
+
+func newTestTicketStore(t *testing.T) (*TicketStore, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	port, err := strconv.Atoi(mr.Port())
+	if err != nil {
+		t.Fatalf("miniredis port: %v", err)
+	}
+	client, err := redisclient.NewClient(mr.Host(), port, "", 0, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return NewTicketStore(client, 0, nil), mr
+}
+
+func TestTicketRedeemOnce(t *testing.T) {
+	store, mr := newTestTicketStore(t)
+	ctx := context.Background()
+
+	ticket, expiresAt, err := store.Issue(ctx, userClaims("user-1"))
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if ttl := mr.TTL(fmt.Sprintf(ticketKeyFmt, ticket)); ttl != defaultTicketTTL {
+		t.Errorf("ticket TTL = %v, want %v", ttl, defaultTicketTTL)
+	}
+	if until := time.Until(expiresAt); until <= 0 || until > defaultTicketTTL {
+		t.Errorf("expiresAt is %v away, want within %v", until, defaultTicketTTL)
+	}
+
+	claims, err := store.Redeem(ctx, ticket)
+	if err != nil {
+		t.Fatalf("Redeem: %v", err)
+	}
+	if claims.GetUserID() != "user-1" {
+		t.Errorf("redeemed user = %q, want user-1", claims.GetUserID())
+	}
+	if _, err := store.Redeem(ctx, ticket); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("second Redeem: err = %v, want %v", err, ErrInvalidTicket)
+	}
+}
+
+func TestTicketRedeemInvalid(t *testing.T) {
+	store, mr := newTestTicketStore(t)
+	ctx := context.Background()
+
+	if _, err := store.Redeem(ctx, "unknown"); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("unknown ticket: err = %v, want %v", err, ErrInvalidTicket)
+	}
+
+	expired := userClaims("user-1")
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	ticket, _, err := store.Issue(ctx, expired)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if _, err := store.Redeem(ctx, ticket); !errors.Is(err, ErrExpiredToken) {
+		t.Errorf("ticket for an expired token: err = %v, want %v", err, ErrExpiredToken)
+	}
+
+	ticket, _, err = store.Issue(ctx, userClaims("user-1"))
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	mr.FastForward(defaultTicketTTL + time.Second)
+	if _, err := store.Redeem(ctx, ticket); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("timed out ticket: err = %v, want %v", err, ErrInvalidTicket)
+	}
+}
@@ -0,0 +1,397 @@
+package connlimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
+	redisclient "github.com/CDeX-Labs/CDeX-Socket-Service/internal/redis"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+const (
+	userKeyFmt = "ws:conns:user:%s"
+	ipKeyFmt   = "ws:conns:ip:%s"
+	// Channel carries evictions of connections owned by other instances.
+	Channel = "ws:evictions"
+
+	defaultLeaseTTL = 90 * time.Second
+	acquireTimeout  = 2 * time.Second
+)
+
+var (
+	ErrUserLimit     = errors.New("too many connections for user")
+	ErrIPLimit       = errors.New("too many connections from address")
+	ErrInstanceLimit = errors.New("instance connection limit reached")
+)
+
+// Policy decides what happens when a user is at their connection limit.
+type Policy string
+
+const (
+	// PolicyReject refuses the new connection.
+	PolicyReject Policy = "reject"
+	// PolicyEvictOldest admits the new connection and closes the user's
+	// oldest ones anywhere in the cluster.
+	PolicyEvictOldest Policy = "evict_oldest"
+)
+
+func ParsePolicy(s string) (Policy, error) {
+	switch Policy(s) {
+	case PolicyReject, PolicyEvictOldest:
+		return Policy(s), nil
+	}
+	return "", fmt.Errorf("invalid connection limit policy %q", s)
+}
+
+// Config sets the connection caps; 0 disables a cap. LeaseTTL bounds how
+// long the connections of a crashed instance keep counting.
+type Config struct {
+	MaxPerUser     int
+	MaxPerIP       int
+	MaxPerInstance int
+	Policy         Policy
+	LeaseTTL       time.Duration
+}
+
+// Request describes a connection about to be admitted. MaxPerUser and
+// Policy override the configured values when set, e.g. for contests that
+// allow a single device.
+type Request struct {
+	ClientID   string
+	UserID     string
+	IP         string
+	MaxPerUser int
+	Policy     Policy
+}
+
+// acquireScript atomically prunes expired leases, checks the caps and adds
+// the new lease. Members are "<connectedAtMs>|<instance>|<client>" with the
+// lease expiry as score, so sorting members orders them by age. It returns
+// {"ip"}, {"user"} or {"ok", member, evicted...}.
+var acquireScript = redisclient.NewScript(`
+local maxUser = tonumber(ARGV[2])
+local maxIP = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[5])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if maxIP > 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', now)
+	if redis.call('ZCARD', KEYS[2]) >= maxIP then
+		return {'ip'}
+	end
+end
+
+local result = {'ok', ''}
+if maxUser > 0 then
+	local members = redis.call('ZRANGE', KEYS[1], 0, -1)
+	local excess = #members - maxUser + 1
+	if excess > 0 then
+		if ARGV[4] ~= 'evict_oldest' then
+			return {'user'}
+		end
+		table.sort(members)
+		for i = 1, excess do
+			redis.call('ZREM', KEYS[1], members[i])
+			table.insert(result, members[i])
+		end
+	end
+end
+
+local member = string.format('%015d', now) .. '|' .. ARGV[1]
+result[2] = member
+redis.call('ZADD', KEYS[1], now + ttl, member)
+redis.call('PEXPIRE', KEYS[1], ttl)
+if maxIP > 0 then
+	redis.call('ZADD', KEYS[2], now + ttl, member)
+	redis.call('PEXPIRE', KEYS[2], ttl)
+end
+return result
+`)
+
+// refreshScript extends the lease ARGV[1] in both keys if it still exists.
+var refreshScript = redisclient.NewScript(`
+local ttl = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+for _, key in ipairs(KEYS) do
+	if redis.call('ZADD', key, 'XX', 'CH', now + ttl, ARGV[1]) == 1 then
+		redis.call('PEXPIRE', key, ttl)
+	end
+end
+return 1
+`)
+
+// releaseScript removes the lease ARGV[1] from both keys.
+var releaseScript = redisclient.NewScript(`
+for _, key in ipairs(KEYS) do
+	redis.call('ZREM', key, ARGV[1])
+end
+return 1
+`)
+
+type lease struct {
+	member string
+	keys   []string
+}
+
+type eviction struct {
+	InstanceID string `json:"instanceId"`
+	ClientID   string `json:"clientId"`
+}
+
+// Limiter counts connections per user and per IP across the cluster in
+// Redis and per instance locally. When Redis is unavailable only the
+// instance cap is enforced.
+type Limiter struct {
+	redis      *redisclient.Client
+	instanceID string
+	config     Config
+	metrics    *metrics.Metrics
+	logger     zerolog.Logger
+
+	leases map[string]lease
+	local  int
+	mu     sync.Mutex
+
+	listeners []func(clientID string)
+	pubsub    *goredis.PubSub
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+func NewLimiter(redis *redisclient.Client, instanceID string, config Config, m *metrics.Metrics, logger zerolog.Logger) *Limiter {
+	if config.LeaseTTL <= 0 {
+		config.LeaseTTL = defaultLeaseTTL
+	}
+	if config.Policy == "" {
+		config.Policy = PolicyReject
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Limiter{
+		redis:      redis,
+		instanceID: instanceID,
+		config:     config,
+		metrics:    m,
+		logger:     logger.With().Str("component", "connlimit").Logger(),
+		leases:     make(map[string]lease),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// OnEvict registers fn to close a local connection evicted to make room for
+// a newer one. It must be called before Start.
+func (l *Limiter) OnEvict(fn func(clientID string)) {
+	l.listeners = append(l.listeners, fn)
+}
+
+func (l *Limiter) Start() error {
+	l.pubsub = l.redis.Subscribe(l.ctx, Channel)
+	if _, err := l.pubsub.Receive(l.ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to evictions: %w", err)
+	}
+
+	go l.listen()
+	return nil
+}
+
+func (l *Limiter) Stop() error {
+	l.cancel()
+	if l.pubsub != nil {
+		return l.pubsub.Close()
+	}
+	return nil
+}
+
+func (l *Limiter) listen() {
+	ch := l.pubsub.Channel()
+	ticker := time.NewTicker(l.config.LeaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-ticker.C:
+			l.refresh()
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var ev eviction
+			if err := json.Unmarshal([]byte(msg.Payload), &ev); err != nil {
+				l.logger.Error().Err(err).Msg("Failed to unmarshal eviction")
+				continue
+			}
+			if ev.InstanceID == l.instanceID {
+				l.evictLocal(ev.ClientID)
+			}
+		}
+	}
+}
+
+// Acquire admits req or returns ErrInstanceLimit, ErrIPLimit or
+// ErrUserLimit. Every admitted connection must be released with Release.
+func (l *Limiter) Acquire(ctx context.Context, req Request) error {
+	l.mu.Lock()
+	if l.config.MaxPerInstance > 0 && l.local >= l.config.MaxPerInstance {
+		l.mu.Unlock()
+		l.metrics.IncConnectionsRejected("instance")
+		return ErrInstanceLimit
+	}
+	l.local++
+	l.mu.Unlock()
+
+	maxPerUser := l.config.MaxPerUser
+	if req.MaxPerUser > 0 {
+		maxPerUser = req.MaxPerUser
+	}
+	policy := l.config.Policy
+	if req.Policy != "" {
+		policy = req.Policy
+	}
+	if maxPerUser <= 0 && l.config.MaxPerIP <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, acquireTimeout)
+	defer cancel()
+
+	keys := []string{fmt.Sprintf(userKeyFmt, req.UserID), fmt.Sprintf(ipKeyFmt, req.IP)}
+	result, err := l.redis.RunScript(ctx, acquireScript, keys,
+		l.instanceID+"|"+req.ClientID, maxPerUser, l.config.MaxPerIP, string(policy), l.config.LeaseTTL.Milliseconds())
+	l.metrics.IncRedisOperation("connlimit_acquire", metrics.Status(err))
+	if err != nil {
+		// Failing open keeps users connected through a Redis outage; the
+		// instance cap still applies.
+		l.logger.Error().Err(err).Str("userId", req.UserID).Msg("Failed to check connection limits")
+		return nil
+	}
+
+	values, _ := result.([]interface{})
+	status := ""
+	if len(values) > 0 {
+		status, _ = values[0].(string)
+	}
+	switch status {
+	case "ok":
+	case "ip":
+		l.releaseSlot()
+		l.metrics.IncConnectionsRejected("ip")
+		return ErrIPLimit
+	case "user":
+		l.releaseSlot()
+		l.metrics.IncConnectionsRejected("user")
+		return ErrUserLimit
+	default:
+		l.logger.Error().Interface("result", result).Msg("Unexpected connection limit result")
+		return nil
+	}
+
+	member, _ := values[1].(string)
+	if l.config.MaxPerIP <= 0 {
+		keys = keys[:1]
+	}
+	l.mu.Lock()
+	l.leases[req.ClientID] = lease{member: member, keys: keys}
+	l.mu.Unlock()
+
+	for _, value := range values[2:] {
+		evicted, _ := value.(string)
+		l.evict(ctx, evicted)
+	}
+	return nil
+}
+
+// Release frees the slot of clientID.
+func (l *Limiter) Release(clientID string) {
+	l.mu.Lock()
+	ls, ok := l.leases[clientID]
+	delete(l.leases, clientID)
+	l.mu.Unlock()
+	l.releaseSlot()
+
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), acquireTimeout)
+	defer cancel()
+	_, err := l.redis.RunScript(ctx, releaseScript, ls.keys, ls.member)
+	l.metrics.IncRedisOperation("connlimit_release", metrics.Status(err))
+	if err != nil {
+		l.logger.Error().Err(err).Str("clientId", clientID).Msg("Failed to release connection lease")
+	}
+}
+
+func (l *Limiter) releaseSlot() {
+	l.mu.Lock()
+	if l.local > 0 {
+		l.local--
+	}
+	l.mu.Unlock()
+}
+
+// evict closes the connection behind a lease member, on this instance or
+// by asking its owner.
+func (l *Limiter) evict(ctx context.Context, member string) {
+	parts := strings.SplitN(member, "|", 3)
+	if len(parts) != 3 {
+		return
+	}
+	ev := eviction{InstanceID: parts[1], ClientID: parts[2]}
+	l.metrics.IncConnectionsEvicted()
+
+	if ev.InstanceID == l.instanceID {
+		l.evictLocal(ev.ClientID)
+		return
+	}
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	err = l.redis.Publish(ctx, Channel, data)
+	l.metrics.IncRedisOperation("connlimit_evict", metrics.Status(err))
+	if err != nil {
+		l.logger.Error().Err(err).Str("clientId", ev.ClientID).Msg("Failed to publish eviction")
+	}
+}
+
+func (l *Limiter) evictLocal(clientID string) {
+	for _, fn := range l.listeners {
+		fn(clientID)
+	}
+}
+
+// refresh extends the leases of this instance's connections so that they
+// outlive LeaseTTL only while the instance is alive.
+func (l *Limiter) refresh() {
+	l.mu.Lock()
+	leases := make([]lease, 0, len(l.leases))
+	for _, ls := range l.leases {
+		leases = append(leases, ls)
+	}
+	l.mu.Unlock()
+
+	ttl := l.config.LeaseTTL.Milliseconds()
+	for _, ls := range leases {
+		ctx, cancel := context.WithTimeout(l.ctx, acquireTimeout)
+		_, err := l.redis.RunScript(ctx, refreshScript, ls.keys, ls.member, ttl)
+		cancel()
+		if err != nil {
+			l.metrics.IncRedisOperation("connlimit_refresh", metrics.Status(err))
+			l.logger.Error().Err(err).Msg("Failed to refresh connection leases")
+			return
+		}
+	}
+	l.metrics.IncRedisOperation("connlimit_refresh", metrics.Status(nil))
+}
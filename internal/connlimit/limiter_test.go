@@ -0,0 +1,190 @@
+package connlimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	redisclient "github.com/CDeX-Labs/CDeX-Socket-Service/internal/redis"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/rs/zerolog"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Policy
+		wantErr bool
+	}{
+		{"reject", PolicyReject, false},
+		{"evict_oldest", PolicyEvictOldest, false},
+		{"", "", true},
+		{"Reject", "", true},
+		{"evict-oldest", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParsePolicy(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePolicy(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParsePolicy(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestLimiter(t *testing.T, config Config) (*Limiter, *miniredis.Miniredis, *[]string) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.UnixMilli(1700000000000))
+	client, err := redisclient.NewClient(mr.Host(), mustPort(t, mr), "", 0, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	limiter := NewLimiter(client, "i1", config, nil, zerolog.Nop())
+	evicted := &[]string{}
+	limiter.OnEvict(func(clientID string) {
+		*evicted = append(*evicted, clientID)
+	})
+	return limiter, mr, evicted
+}
+
+func mustPort(t *testing.T, mr *miniredis.Miniredis) int {
+	t.Helper()
+	port, err := strconv.Atoi(mr.Port())
+	if err != nil {
+		t.Fatalf("miniredis port: %v", err)
+	}
+	return port
+}
+
+func acquire(t *testing.T, limiter *Limiter, clientID, userID, ip string) error {
+	t.Helper()
+	return limiter.Acquire(context.Background(), Request{ClientID: clientID, UserID: userID, IP: ip})
+}
+
+func TestLimiterReject(t *testing.T) {
+	limiter, _, _ := newTestLimiter(t, Config{MaxPerUser: 2, MaxPerIP: 3})
+
+	for _, clientID := range []string{"c1", "c2"} {
+		if err := acquire(t, limiter, clientID, "u1", "10.0.0.1"); err != nil {
+			t.Fatalf("Acquire(%s): %v", clientID, err)
+		}
+	}
+	if err := acquire(t, limiter, "c3", "u1", "10.0.0.1"); !errors.Is(err, ErrUserLimit) {
+		t.Fatalf("third connection of u1: err = %v, want %v", err, ErrUserLimit)
+	}
+	if err := acquire(t, limiter, "c4", "u2", "10.0.0.1"); err != nil {
+		t.Fatalf("Acquire(c4): %v", err)
+	}
+	if err := acquire(t, limiter, "c5", "u3", "10.0.0.1"); !errors.Is(err, ErrIPLimit) {
+		t.Fatalf("fourth connection from the address: err = %v, want %v", err, ErrIPLimit)
+	}
+	if limiter.local != 3 {
+		t.Errorf("local slots = %d, want 3 after two rejections", limiter.local)
+	}
+}
+
+func TestLimiterEvictOldest(t *testing.T) {
+	limiter, mr, evicted := newTestLimiter(t, Config{MaxPerUser: 2, Policy: PolicyEvictOldest})
+
+	for i, clientID := range []string{"c1", "c2", "c3"} {
+		mr.SetTime(time.UnixMilli(1700000000000 + int64(i)*1000))
+		if err := acquire(t, limiter, clientID, "u1", "10.0.0.1"); err != nil {
+			t.Fatalf("Acquire(%s): %v", clientID, err)
+		}
+	}
+	if len(*evicted) != 1 || (*evicted)[0] != "c1" {
+		t.Fatalf("evicted %v, want [c1]", *evicted)
+	}
+
+	members, err := mr.ZMembers(fmt.Sprintf(userKeyFmt, "u1"))
+	if err != nil {
+		t.Fatalf("ZMembers: %v", err)
+	}
+	if len(members) != 2 {
+		t.Errorf("leases of u1 = %v, want 2", members)
+	}
+
+	// A per-request limit overrides the configured policy.
+	err = limiter.Acquire(context.Background(), Request{ClientID: "c4", UserID: "u1", IP: "10.0.0.1", MaxPerUser: 2, Policy: PolicyReject})
+	if !errors.Is(err, ErrUserLimit) {
+		t.Errorf("Acquire with a reject override: err = %v, want %v", err, ErrUserLimit)
+	}
+}
+
+func TestLimiterLeaseExpiry(t *testing.T) {
+	limiter, mr, _ := newTestLimiter(t, Config{MaxPerUser: 1, LeaseTTL: time.Minute})
+
+	if err := acquire(t, limiter, "c1", "u1", "10.0.0.1"); err != nil {
+		t.Fatalf("Acquire(c1): %v", err)
+	}
+	if err := acquire(t, limiter, "c2", "u1", "10.0.0.1"); !errors.Is(err, ErrUserLimit) {
+		t.Fatalf("second connection: err = %v, want %v", err, ErrUserLimit)
+	}
+
+	// The lease of a crashed instance stops counting once it is not
+	// refreshed for LeaseTTL.
+	mr.SetTime(time.UnixMilli(1700000000000).Add(time.Minute + time.Second))
+	if err := acquire(t, limiter, "c3", "u1", "10.0.0.1"); err != nil {
+		t.Errorf("connection after the lease expired: %v", err)
+	}
+}
+
+func TestLimiterRelease(t *testing.T) {
+	limiter, mr, _ := newTestLimiter(t, Config{MaxPerUser: 1, MaxPerIP: 1})
+
+	if err := acquire(t, limiter, "c1", "u1", "10.0.0.1"); err != nil {
+		t.Fatalf("Acquire(c1): %v", err)
+	}
+	limiter.Release("c1")
+
+	for _, key := range []string{fmt.Sprintf(userKeyFmt, "u1"), fmt.Sprintf(ipKeyFmt, "10.0.0.1")} {
+		if members, _ := mr.ZMembers(key); len(members) != 0 {
+			t.Errorf("%s still holds %v after Release", key, members)
+		}
+	}
+	if err := acquire(t, limiter, "c2", "u1", "10.0.0.1"); err != nil {
+		t.Errorf("Acquire after Release: %v", err)
+	}
+}
+
+func TestLimiterFailsOpen(t *testing.T) {
+	limiter, mr, _ := newTestLimiter(t, Config{MaxPerUser: 1, MaxPerInstance: 2})
+	mr.Close()
+
+	for _, clientID := range []string{"c1", "c2"} {
+		if err := acquire(t, limiter, clientID, "u1", "10.0.0.1"); err != nil {
+			t.Fatalf("Acquire(%s) without Redis: %v", clientID, err)
+		}
+	}
+	if err := acquire(t, limiter, "c3", "u1", "10.0.0.1"); !errors.Is(err, ErrInstanceLimit) {
+		t.Errorf("instance cap without Redis: err = %v, want %v", err, ErrInstanceLimit)
+	}
+}
+
+func TestLimiterInstanceCap(t *testing.T) {
+	limiter, _, _ := newTestLimiter(t, Config{MaxPerInstance: 2})
+
+	for _, clientID := range []string{"c1", "c2"} {
+		if err := acquire(t, limiter, clientID, clientID, "10.0.0."+clientID[1:]); err != nil {
+			t.Fatalf("Acquire(%s): %v", clientID, err)
+		}
+	}
+	if err := acquire(t, limiter, "c3", "u3", "10.0.0.3"); !errors.Is(err, ErrInstanceLimit) {
+		t.Fatalf("third connection: err = %v, want %v", err, ErrInstanceLimit)
+	}
+
+	limiter.Release("c1")
+	if err := acquire(t, limiter, "c3", "u3", "10.0.0.3"); err != nil {
+		t.Errorf("Acquire after Release: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package contest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
+	redisclient "github.com/CDeX-Labs/CDeX-Socket-Service/internal/redis"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+const (
+	announcementsKeyFmt  = "contest:announcements:%s"
+	publicAnswersKeyFmt  = "contest:answers:%s"
+	privateAnswersKeyFmt = "contest:answers:%s:%s"
+	readKeyFmt           = "contest:read:%s"
+
+	defaultAnnouncementHistory = 50
+	// maxAnswers bounds the answers kept for unread counts per key.
+	maxAnswers = 1000
+)
+
+// Board keeps a contest's announcements, so that late joiners receive the
+// recent ones, and counts the announcements and clarification answers each
+// user has not read yet.
+type Board struct {
+	redis   *redisclient.Client
+	history int
+	metrics *metrics.Metrics
+	logger  zerolog.Logger
+}
+
+// NewBoard keeps the last history announcements of each contest.
+func NewBoard(redis *redisclient.Client, history int, m *metrics.Metrics, logger zerolog.Logger) *Board {
+	if history <= 0 {
+		history = defaultAnnouncementHistory
+	}
+	return &Board{
+		redis:   redis,
+		history: history,
+		metrics: m,
+		logger:  logger.With().Str("component", "contest-board").Logger(),
+	}
+}
+
+// Announce stores an announcement, stamping its CreatedAt with the current
+// time unless it is already set. It is ordered and counted as unread by
+// when it was stored, the clock MarkRead uses, so that an announcement
+// arriving late is not hidden behind an earlier read marker.
+func (b *Board) Announce(ctx context.Context, announcement *protocol.AnnouncementPayload) error {
+	storedAt := time.Now().UnixMilli()
+	if announcement.CreatedAt == 0 {
+		announcement.CreatedAt = storedAt
+	}
+	data, err := json.Marshal(announcement)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf(announcementsKeyFmt, announcement.ContestID)
+	err = b.append(ctx, key, string(data), storedAt, b.history)
+	b.metrics.IncRedisOperation("contest_announce", metrics.Status(err))
+	if err != nil {
+		return fmt.Errorf("failed to store announcement: %w", err)
+	}
+	return nil
+}
+
+// Answered records a clarification answer for the unread counts of
+// everyone in the contest, or of userID alone when it is private.
+func (b *Board) Answered(ctx context.Context, contestID, userID, clarificationID string, public bool) error {
+	key := fmt.Sprintf(privateAnswersKeyFmt, contestID, userID)
+	if public {
+		key = fmt.Sprintf(publicAnswersKeyFmt, contestID)
+	}
+
+	err := b.append(ctx, key, clarificationID, time.Now().UnixMilli(), maxAnswers)
+	b.metrics.IncRedisOperation("contest_answer", metrics.Status(err))
+	if err != nil {
+		return fmt.Errorf("failed to record clarification answer: %w", err)
+	}
+	return nil
+}
+
+// append adds member to the sorted set key and trims it to its newest
+// limit members.
+func (b *Board) append(ctx context.Context, key, member string, at int64, limit int) error {
+	err := b.redis.ZAdd(ctx, key, float64(at), member)
+	if err == nil {
+		err = b.redis.ZRemRangeByRank(ctx, key, 0, int64(-limit-1))
+	}
+	if err == nil {
+		err = b.redis.Expire(ctx, key, stateRetention)
+	}
+	return err
+}
+
+// Announcements returns the stored announcements of contestID, oldest
+// first.
+func (b *Board) Announcements(ctx context.Context, contestID string) ([]protocol.AnnouncementPayload, error) {
+	members, err := b.redis.ZRange(ctx, fmt.Sprintf(announcementsKeyFmt, contestID), 0, -1)
+	b.metrics.IncRedisOperation("contest_announcements_get", metrics.Status(err))
+	if err != nil {
+		return nil, err
+	}
+
+	announcements := make([]protocol.AnnouncementPayload, 0, len(members))
+	for _, member := range members {
+		var announcement protocol.AnnouncementPayload
+		if err := json.Unmarshal([]byte(member), &announcement); err != nil {
+			b.logger.Error().Err(err).Str("contestId", contestID).Msg("Failed to unmarshal announcement")
+			continue
+		}
+		announcements = append(announcements, announcement)
+	}
+	return announcements, nil
+}
+
+// Unread counts the announcements and answers for userID in contestID
+// since they last marked it read.
+func (b *Board) Unread(ctx context.Context, contestID, userID string) (int, error) {
+	since, err := b.redis.HGet(ctx, fmt.Sprintf(readKeyFmt, contestID), userID)
+	if errors.Is(err, goredis.Nil) {
+		since, err = "0", nil
+	}
+	if err != nil {
+		b.metrics.IncRedisOperation("contest_unread", metrics.Status(err))
+		return 0, err
+	}
+
+	total := 0
+	for _, key := range []string{
+		fmt.Sprintf(announcementsKeyFmt, contestID),
+		fmt.Sprintf(publicAnswersKeyFmt, contestID),
+		fmt.Sprintf(privateAnswersKeyFmt, contestID, userID),
+	} {
+		n, err := b.redis.ZCount(ctx, key, "("+since, "+inf")
+		if err != nil {
+			b.metrics.IncRedisOperation("contest_unread", metrics.Status(err))
+			return 0, err
+		}
+		total += int(n)
+	}
+	b.metrics.IncRedisOperation("contest_unread", metrics.Status(nil))
+	return total, nil
+}
+
+// MarkRead marks everything userID received in contestID so far as read.
+func (b *Board) MarkRead(ctx context.Context, contestID, userID string) error {
+	key := fmt.Sprintf(readKeyFmt, contestID)
+	err := b.redis.HSet(ctx, key, userID, strconv.FormatInt(time.Now().UnixMilli(), 10))
+	if err == nil {
+		err = b.redis.Expire(ctx, key, stateRetention)
+	}
+	b.metrics.IncRedisOperation("contest_mark_read", metrics.Status(err))
+	return err
+}
+
+// History returns what a user joining the room of contestID is sent.
+func (b *Board) History(ctx context.Context, contestID, userID string) (*protocol.AnnouncementsPayload, error) {
+	announcements, err := b.Announcements(ctx, contestID)
+	if err != nil {
+		return nil, err
+	}
+	unread, err := b.Unread(ctx, contestID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &protocol.AnnouncementsPayload{
+		ContestID:     contestID,
+		Announcements: announcements,
+		Unread:        unread,
+	}, nil
+}
@@ -0,0 +1,509 @@
+package contest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
+	redisclient "github.com/CDeX-Labs/CDeX-Socket-Service/internal/redis"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/events"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+const (
+	stateKeyFmt        = "contest:state:%s"
+	problemsKeyFmt     = "contest:problems:%s"
+	participantsKeyFmt = "contest:participants:%s"
+	invitedKeyFmt      = "contest:invited:%s"
+	// statesKey indexes the known contests, scored by when their state
+	// expires so that expired ones can be dropped from it.
+	statesKey = "contest:states"
+	// StateChannel announces contest state changes to every instance.
+	StateChannel = "ws:contest-state"
+
+	// stateRetention is how long a contest is remembered after its last
+	// event.
+	stateRetention = 30 * 24 * time.Hour
+	// endedRetention is how long an ended contest stays in memory.
+	endedRetention  = 24 * time.Hour
+	registryTimeout = 2 * time.Second
+)
+
+type Phase string
+
+const (
+	PhaseCreated Phase = "created"
+	PhaseRunning Phase = "running"
+	PhaseFrozen  Phase = "frozen"
+	PhaseEnded   Phase = "ended"
+)
+
+// Info is the contest metadata carried by contest.created.
+type Info struct {
+	ContestID  string
+	Title      string
+	Slug       string
+	Visibility string
+	// OrganizationID is the organization a non-public contest belongs to.
+	OrganizationID string
+	// InvitedUserIDs may see a non-public contest without registering.
+	InvitedUserIDs []string
+	ScoringMode    string
+	StartTime      time.Time
+	EndTime        time.Time
+	FreezeTime     time.Time
+	Proctored      bool
+}
+
+// Registry tracks the lifecycle, metadata, problems and participants of
+// contests from their Kafka events. The state lives in Redis so that every
+// instance sees events consumed by any of them; each instance keeps an
+// in-memory copy refreshed through StateChannel.
+type Registry struct {
+	redis   *redisclient.Client
+	metrics *metrics.Metrics
+	logger  zerolog.Logger
+
+	states    map[string]protocol.ContestStatePayload
+	mu        sync.RWMutex
+	listeners []func(contestID string)
+
+	pubsub *goredis.PubSub
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewRegistry(redis *redisclient.Client, m *metrics.Metrics, logger zerolog.Logger) *Registry {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Registry{
+		redis:   redis,
+		metrics: m,
+		logger:  logger.With().Str("component", "contest-registry").Logger(),
+		states:  make(map[string]protocol.ContestStatePayload),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// OnChange registers fn to run after the state of a contest was reloaded,
+// whichever instance changed it. It must be called before Start.
+func (r *Registry) OnChange(fn func(contestID string)) {
+	r.listeners = append(r.listeners, fn)
+}
+
+// Start loads the known contests and follows changes made by other
+// instances.
+func (r *Registry) Start() error {
+	r.pubsub = r.redis.Subscribe(r.ctx, StateChannel)
+	if _, err := r.pubsub.Receive(r.ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to contest state: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, registryTimeout)
+	err := r.pruneIndex(ctx)
+	var ids []string
+	if err == nil {
+		ids, err = r.redis.ZRange(ctx, statesKey, 0, -1)
+	}
+	cancel()
+	r.metrics.IncRedisOperation("contest_state_list", metrics.Status(err))
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to load contest states")
+	}
+	for _, id := range ids {
+		r.reload(id)
+	}
+
+	go r.listen()
+	return nil
+}
+
+func (r *Registry) Stop() error {
+	r.cancel()
+	if r.pubsub != nil {
+		return r.pubsub.Close()
+	}
+	return nil
+}
+
+func (r *Registry) listen() {
+	ch := r.pubsub.Channel()
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			r.prune()
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			r.reload(msg.Payload)
+		}
+	}
+}
+
+// Get returns the cached state of contestID. Registered is always false.
+func (r *Registry) Get(contestID string) (protocol.ContestStatePayload, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	state, ok := r.states[contestID]
+	return state, ok
+}
+
+// ContestState returns the state of contestID as seen by userID.
+func (r *Registry) ContestState(ctx context.Context, contestID, userID string) (*protocol.ContestStatePayload, bool) {
+	state, ok := r.Get(contestID)
+	if !ok {
+		return nil, false
+	}
+	if registered, err := r.IsParticipant(ctx, contestID, userID); err == nil {
+		state.Registered = registered
+	}
+	return &state, true
+}
+
+func (r *Registry) Phase(contestID string) Phase {
+	state, _ := r.Get(contestID)
+	return Phase(state.Phase)
+}
+
+// IsFrozen reports whether contestID's scoreboard is currently frozen,
+// which it remains after the contest ends until it is unfrozen.
+func (r *Registry) IsFrozen(contestID string) bool {
+	state, _ := r.Get(contestID)
+	return state.Frozen
+}
+
+func (r *Registry) IsParticipant(ctx context.Context, contestID, userID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, registryTimeout)
+	defer cancel()
+
+	registered, err := r.redis.SIsMember(ctx, fmt.Sprintf(participantsKeyFmt, contestID), userID)
+	r.metrics.IncRedisOperation("contest_participant_check", metrics.Status(err))
+	return registered, err
+}
+
+// CanView reports whether userID may see contestID. Public contests and
+// contests the registry has not seen are open to everyone; others only to
+// their participants, invited users and, as told by inOrg, the members of
+// their organization.
+func (r *Registry) CanView(ctx context.Context, contestID, userID string, inOrg func(orgID string) bool) bool {
+	state, ok := r.Get(contestID)
+	if !ok || IsPublic(state.Visibility) {
+		return true
+	}
+	if state.OrganizationID != "" && inOrg(state.OrganizationID) {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, registryTimeout)
+	defer cancel()
+
+	for _, key := range []string{fmt.Sprintf(participantsKeyFmt, contestID), fmt.Sprintf(invitedKeyFmt, contestID)} {
+		member, err := r.redis.SIsMember(ctx, key, userID)
+		r.metrics.IncRedisOperation("contest_access_check", metrics.Status(err))
+		if err != nil {
+			r.logger.Error().Err(err).Str("contestId", contestID).Msg("Failed to check contest access")
+			return false
+		}
+		if member {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPublic reports whether a contest may be announced to everyone. An
+// empty visibility, as recorded for contests first seen through
+// contest.started, keeps the historical behaviour of treating them as
+// public.
+func IsPublic(visibility string) bool {
+	return visibility == "" || strings.EqualFold(visibility, events.VisibilityPublic)
+}
+
+func (r *Registry) Participants(ctx context.Context, contestID string) ([]string, error) {
+	users, err := r.redis.SMembers(ctx, fmt.Sprintf(participantsKeyFmt, contestID))
+	r.metrics.IncRedisOperation("contest_participant_list", metrics.Status(err))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// Invited returns the users invited to contestID.
+func (r *Registry) Invited(ctx context.Context, contestID string) ([]string, error) {
+	users, err := r.redis.SMembers(ctx, fmt.Sprintf(invitedKeyFmt, contestID))
+	r.metrics.IncRedisOperation("contest_invited_list", metrics.Status(err))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// Created records the metadata of a new contest.
+func (r *Registry) Created(ctx context.Context, info Info) error {
+	fields := map[string]interface{}{
+		"title":       info.Title,
+		"slug":        info.Slug,
+		"visibility":  info.Visibility,
+		"orgId":       info.OrganizationID,
+		"scoringMode": info.ScoringMode,
+		"proctored":   strconv.FormatBool(info.Proctored),
+	}
+	setTime(fields, "startTime", info.StartTime)
+	setTime(fields, "endTime", info.EndTime)
+	setTime(fields, "freezeTime", info.FreezeTime)
+	if _, ok := r.Get(info.ContestID); !ok {
+		fields["phase"] = string(PhaseCreated)
+	}
+	if len(info.InvitedUserIDs) > 0 {
+		members := make([]interface{}, len(info.InvitedUserIDs))
+		for i, userID := range info.InvitedUserIDs {
+			members[i] = userID
+		}
+		err := r.redis.SAdd(ctx, fmt.Sprintf(invitedKeyFmt, info.ContestID), members...)
+		r.metrics.IncRedisOperation("contest_invited_add", metrics.Status(err))
+		if err != nil {
+			return fmt.Errorf("failed to record invited users: %w", err)
+		}
+	}
+	return r.update(ctx, info.ContestID, fields)
+}
+
+func (r *Registry) Started(ctx context.Context, contestID string, startTime, endTime time.Time) error {
+	fields := map[string]interface{}{}
+	setTime(fields, "startTime", startTime)
+	setTime(fields, "endTime", endTime)
+	r.setPhase(contestID, fields, PhaseRunning)
+	return r.update(ctx, contestID, fields)
+}
+
+func (r *Registry) Ended(ctx context.Context, contestID string, endTime time.Time) error {
+	fields := map[string]interface{}{"phase": string(PhaseEnded)}
+	setTime(fields, "endTime", endTime)
+	return r.update(ctx, contestID, fields)
+}
+
+func (r *Registry) Frozen(ctx context.Context, contestID string, freezeTime time.Time) error {
+	fields := map[string]interface{}{"frozen": "true"}
+	setTime(fields, "freezeTime", freezeTime)
+	r.setPhase(contestID, fields, PhaseFrozen)
+	return r.update(ctx, contestID, fields)
+}
+
+func (r *Registry) Unfrozen(ctx context.Context, contestID string) error {
+	// Scoreboards are often unfrozen after the contest is over.
+	phase := PhaseRunning
+	if state, ok := r.Get(contestID); ok && state.EndTime > 0 && time.Now().UnixMilli() >= state.EndTime {
+		phase = PhaseEnded
+	}
+	fields := map[string]interface{}{"frozen": "false"}
+	r.setPhase(contestID, fields, phase)
+	return r.update(ctx, contestID, fields)
+}
+
+func (r *Registry) ProblemAdded(ctx context.Context, contestID, problemID, label string) error {
+	err := r.redis.HSet(ctx, fmt.Sprintf(problemsKeyFmt, contestID), problemID, label)
+	r.metrics.IncRedisOperation("contest_problem_set", metrics.Status(err))
+	if err != nil {
+		return fmt.Errorf("failed to record contest problem: %w", err)
+	}
+	return r.update(ctx, contestID, nil)
+}
+
+func (r *Registry) ProblemRemoved(ctx context.Context, contestID, problemID string) error {
+	err := r.redis.HDel(ctx, fmt.Sprintf(problemsKeyFmt, contestID), problemID)
+	r.metrics.IncRedisOperation("contest_problem_delete", metrics.Status(err))
+	if err != nil {
+		return fmt.Errorf("failed to remove contest problem: %w", err)
+	}
+	return r.update(ctx, contestID, nil)
+}
+
+func (r *Registry) ParticipantRegistered(ctx context.Context, contestID, userID string) error {
+	err := r.redis.SAdd(ctx, fmt.Sprintf(participantsKeyFmt, contestID), userID)
+	r.metrics.IncRedisOperation("contest_participant_add", metrics.Status(err))
+	if err != nil {
+		return fmt.Errorf("failed to record contest participant: %w", err)
+	}
+	return r.update(ctx, contestID, nil)
+}
+
+func (r *Registry) ParticipantUnregistered(ctx context.Context, contestID, userID string) error {
+	err := r.redis.SRem(ctx, fmt.Sprintf(participantsKeyFmt, contestID), userID)
+	r.metrics.IncRedisOperation("contest_participant_remove", metrics.Status(err))
+	if err != nil {
+		return fmt.Errorf("failed to remove contest participant: %w", err)
+	}
+	return r.update(ctx, contestID, nil)
+}
+
+// setPhase moves the contest to phase unless it has already ended; late or
+// replayed events must not reopen a finished contest.
+func (r *Registry) setPhase(contestID string, fields map[string]interface{}, phase Phase) {
+	if r.Phase(contestID) != PhaseEnded {
+		fields["phase"] = string(phase)
+	}
+}
+
+func setTime(fields map[string]interface{}, name string, t time.Time) {
+	if !t.IsZero() {
+		fields[name] = t.UnixMilli()
+	}
+}
+
+// update writes fields, refreshes the retention of every key of the
+// contest and tells all instances to reload it.
+func (r *Registry) update(ctx context.Context, contestID string, fields map[string]interface{}) error {
+	if contestID == "" {
+		return fmt.Errorf("contestId is required")
+	}
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+	fields["updatedAt"] = time.Now().UnixMilli()
+
+	stateKey := fmt.Sprintf(stateKeyFmt, contestID)
+	err := r.redis.HSetAll(ctx, stateKey, fields)
+	if err == nil {
+		err = r.redis.ZAdd(ctx, statesKey, float64(time.Now().Add(stateRetention).UnixMilli()), contestID)
+	}
+	if err == nil {
+		err = r.pruneIndex(ctx)
+	}
+	for _, key := range []string{
+		stateKey,
+		fmt.Sprintf(problemsKeyFmt, contestID),
+		fmt.Sprintf(participantsKeyFmt, contestID),
+		fmt.Sprintf(invitedKeyFmt, contestID),
+	} {
+		if err == nil {
+			err = r.redis.Expire(ctx, key, stateRetention)
+		}
+	}
+	r.metrics.IncRedisOperation("contest_state_set", metrics.Status(err))
+	if err != nil {
+		return fmt.Errorf("failed to update contest state: %w", err)
+	}
+
+	r.reload(contestID)
+
+	err = r.redis.Publish(ctx, StateChannel, contestID)
+	r.metrics.IncRedisOperation("contest_state_publish", metrics.Status(err))
+	return err
+}
+
+func (r *Registry) reload(contestID string) {
+	ctx, cancel := context.WithTimeout(r.ctx, registryTimeout)
+	defer cancel()
+
+	fields, err := r.redis.HGetAll(ctx, fmt.Sprintf(stateKeyFmt, contestID))
+	var problems map[string]string
+	var participants int64
+	if err == nil {
+		problems, err = r.redis.HGetAll(ctx, fmt.Sprintf(problemsKeyFmt, contestID))
+	}
+	if err == nil {
+		participants, err = r.redis.SCard(ctx, fmt.Sprintf(participantsKeyFmt, contestID))
+	}
+	r.metrics.IncRedisOperation("contest_state_get", metrics.Status(err))
+	if err != nil {
+		r.logger.Error().Err(err).Str("contestId", contestID).Msg("Failed to load contest state")
+		return
+	}
+
+	r.mu.Lock()
+	if len(fields) == 0 {
+		delete(r.states, contestID)
+	} else {
+		r.states[contestID] = buildState(contestID, fields, problems, int(participants))
+	}
+	r.mu.Unlock()
+
+	for _, fn := range r.listeners {
+		fn(contestID)
+	}
+}
+
+func buildState(contestID string, fields, problems map[string]string, participants int) protocol.ContestStatePayload {
+	state := protocol.ContestStatePayload{
+		ContestID:        contestID,
+		Title:            fields["title"],
+		Slug:             fields["slug"],
+		Visibility:       fields["visibility"],
+		OrganizationID:   fields["orgId"],
+		ScoringMode:      fields["scoringMode"],
+		Phase:            fields["phase"],
+		Problems:         make([]protocol.ContestProblem, 0, len(problems)),
+		ParticipantCount: participants,
+	}
+	if state.Phase == "" {
+		state.Phase = string(PhaseCreated)
+	}
+	state.Frozen, _ = strconv.ParseBool(fields["frozen"])
+	state.Proctored, _ = strconv.ParseBool(fields["proctored"])
+	state.StartTime, _ = strconv.ParseInt(fields["startTime"], 10, 64)
+	state.EndTime, _ = strconv.ParseInt(fields["endTime"], 10, 64)
+	state.FreezeTime, _ = strconv.ParseInt(fields["freezeTime"], 10, 64)
+	state.UpdatedAt, _ = strconv.ParseInt(fields["updatedAt"], 10, 64)
+
+	for problemID, label := range problems {
+		state.Problems = append(state.Problems, protocol.ContestProblem{ProblemID: problemID, Label: label})
+	}
+	sort.Slice(state.Problems, func(i, j int) bool {
+		return state.Problems[i].Label < state.Problems[j].Label
+	})
+	return state
+}
+
+// pruneIndex drops contests whose state has expired from statesKey.
+func (r *Registry) pruneIndex(ctx context.Context) error {
+	return r.redis.ZRemRangeByScore(ctx, statesKey, "-inf", strconv.FormatInt(time.Now().UnixMilli(), 10))
+}
+
+// prune forgets contests that ended long ago; Redis expires them on its
+// own.
+func (r *Registry) prune() {
+	cutoff := time.Now().Add(-endedRetention).UnixMilli()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, state := range r.states {
+		if Phase(state.Phase) == PhaseEnded && state.UpdatedAt < cutoff {
+			delete(r.states, id)
+		}
+	}
+}
+
+// Schedule returns the timer schedule known for contestID.
+func (r *Registry) Schedule(contestID string) (Schedule, bool) {
+	state, ok := r.Get(contestID)
+	if !ok {
+		return Schedule{}, false
+	}
+	return Schedule{
+		ContestID:  contestID,
+		StartTime:  fromMilli(state.StartTime),
+		EndTime:    fromMilli(state.EndTime),
+		FreezeTime: fromMilli(state.FreezeTime),
+	}, true
+}
+
+func fromMilli(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
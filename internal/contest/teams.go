@@ -0,0 +1,412 @@
+package contest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
+	redisclient "github.com/CDeX-Labs/CDeX-Socket-Service/internal/redis"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+const (
+	teamMembersKeyFmt = "contest:team:%s:%s"
+	userTeamKeyFmt    = "contest:user-team:%s"
+	userTeamsKeyFmt   = "contest:user-teams:%s"
+	// TeamPresenceChannel names teams, as "<contestId>:<teamId>", whose
+	// presence every instance should push to its team room members.
+	TeamPresenceChannel = "ws:team-presence"
+
+	teamTimeout = 2 * time.Second
+	// membershipTTL is how long a membership check is reused. Team changes
+	// clear it earlier through TeamPresenceChannel.
+	membershipTTL = 30 * time.Second
+	// maxCachedTeams bounds the membership cache; it is emptied when more
+	// teams than this are cached.
+	maxCachedTeams = 10000
+)
+
+// ErrNoTeam is returned by a TeamLookup for users without a team.
+var ErrNoTeam = errors.New("user has no team")
+
+// OnlineChecker reports which users are connected anywhere in the cluster;
+// *presence.Manager satisfies it.
+type OnlineChecker interface {
+	GetOnlineUsers(ctx context.Context, userIDs []string) ([]string, error)
+}
+
+// TeamSender delivers messages to the local members of a room;
+// *hub.Hub satisfies it.
+type TeamSender interface {
+	Sender
+	HasRoomMembers(roomID string) bool
+}
+
+// TeamLookup asks the backend for a user's team when no
+// contest.team.registered event has been seen for it.
+type TeamLookup interface {
+	LookupTeam(ctx context.Context, contestID, userID string) (teamID string, members []string, err error)
+}
+
+// Teams keeps the membership of contest teams in Redis, routes members'
+// events to their team room and pushes team presence.
+type Teams struct {
+	redis    *redisclient.Client
+	presence OnlineChecker
+	sender   TeamSender
+	lookup   TeamLookup
+	roomID   func(contestID, teamID string) string
+	metrics  *metrics.Metrics
+	logger   zerolog.Logger
+
+	// memberships caches IsMember by "<contestId>:<teamId>" and user, as
+	// it runs when a client joins a team room.
+	memberships map[string]map[string]membership
+	cacheMu     sync.Mutex
+
+	pubsub *goredis.PubSub
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type membership struct {
+	member  bool
+	expires time.Time
+}
+
+// NewTeams builds team room IDs with roomID, normally hub.TeamRoomID.
+func NewTeams(redis *redisclient.Client, presence OnlineChecker, sender TeamSender, roomID func(contestID, teamID string) string, m *metrics.Metrics, logger zerolog.Logger) *Teams {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Teams{
+		redis:    redis,
+		presence: presence,
+		sender:   sender,
+		roomID:   roomID,
+		metrics:  m,
+		logger:   logger.With().Str("component", "teams").Logger(),
+		ctx:      ctx,
+		cancel:   cancel,
+
+		memberships: make(map[string]map[string]membership),
+	}
+}
+
+// SetLookup enables backend lookups for users not covered by team events.
+// It must be called before Start.
+func (t *Teams) SetLookup(lookup TeamLookup) {
+	t.lookup = lookup
+}
+
+func (t *Teams) Start() error {
+	t.pubsub = t.redis.Subscribe(t.ctx, TeamPresenceChannel)
+	if _, err := t.pubsub.Receive(t.ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to team presence: %w", err)
+	}
+
+	go t.listen()
+	return nil
+}
+
+func (t *Teams) Stop() error {
+	t.cancel()
+	if t.pubsub != nil {
+		return t.pubsub.Close()
+	}
+	return nil
+}
+
+func (t *Teams) listen() {
+	ch := t.pubsub.Channel()
+	for {
+		select {
+		case <-t.ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			contestID, teamID, ok := strings.Cut(msg.Payload, ":")
+			if !ok {
+				continue
+			}
+			t.forget(msg.Payload)
+			t.pushPresence(contestID, teamID)
+		}
+	}
+}
+
+// Register replaces the members of teamID and returns the users that are
+// no longer in it.
+func (t *Teams) Register(ctx context.Context, contestID, teamID string, members []string) ([]string, error) {
+	membersKey := fmt.Sprintf(teamMembersKeyFmt, contestID, teamID)
+	previous, err := t.redis.SMembers(ctx, membersKey)
+	t.metrics.IncRedisOperation("team_members_get", metrics.Status(err))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load team members: %w", err)
+	}
+
+	current := make(map[string]bool, len(members))
+	for _, userID := range members {
+		current[userID] = true
+	}
+	var removed []string
+	for _, userID := range previous {
+		if !current[userID] {
+			removed = append(removed, userID)
+		}
+	}
+
+	team := contestID + ":" + teamID
+	userTeamKey := fmt.Sprintf(userTeamKeyFmt, contestID)
+	err = t.redis.Del(ctx, membersKey)
+	for _, userID := range removed {
+		if err == nil {
+			err = t.redis.HDel(ctx, userTeamKey, userID)
+		}
+		if err == nil {
+			err = t.redis.SRem(ctx, fmt.Sprintf(userTeamsKeyFmt, userID), team)
+		}
+	}
+	for _, userID := range members {
+		if err == nil {
+			err = t.redis.SAdd(ctx, membersKey, userID)
+		}
+		if err == nil {
+			err = t.redis.HSet(ctx, userTeamKey, userID, teamID)
+		}
+		if err == nil {
+			err = t.redis.SAdd(ctx, fmt.Sprintf(userTeamsKeyFmt, userID), team)
+		}
+		if err == nil {
+			err = t.redis.Expire(ctx, fmt.Sprintf(userTeamsKeyFmt, userID), stateRetention)
+		}
+	}
+	for _, key := range []string{membersKey, userTeamKey} {
+		if err == nil {
+			err = t.redis.Expire(ctx, key, stateRetention)
+		}
+	}
+	t.metrics.IncRedisOperation("team_register", metrics.Status(err))
+	if err != nil {
+		return nil, fmt.Errorf("failed to register team: %w", err)
+	}
+
+	t.forget(team)
+	t.publish(ctx, team)
+	return removed, nil
+}
+
+// TeamOf returns the team of userID in contestID, or "" if none is known.
+func (t *Teams) TeamOf(ctx context.Context, contestID, userID string) (string, error) {
+	teamID, err := t.redis.HGet(ctx, fmt.Sprintf(userTeamKeyFmt, contestID), userID)
+	if errors.Is(err, goredis.Nil) {
+		err = nil
+	}
+	t.metrics.IncRedisOperation("team_of", metrics.Status(err))
+	return teamID, err
+}
+
+// IsMember reports whether userID belongs to teamID. Users unknown to the
+// team events are looked up in the backend, when configured. Answers are
+// cached for membershipTTL or until the team changes.
+func (t *Teams) IsMember(ctx context.Context, contestID, teamID, userID string) bool {
+	team := contestID + ":" + teamID
+	now := time.Now()
+
+	t.cacheMu.Lock()
+	cached, ok := t.memberships[team][userID]
+	t.cacheMu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.member
+	}
+
+	member, err := t.isMember(ctx, contestID, teamID, userID)
+	if err != nil {
+		return false
+	}
+
+	t.cacheMu.Lock()
+	defer t.cacheMu.Unlock()
+	if len(t.memberships) >= maxCachedTeams {
+		t.memberships = make(map[string]map[string]membership)
+	}
+	if t.memberships[team] == nil {
+		t.memberships[team] = make(map[string]membership)
+	}
+	t.memberships[team][userID] = membership{member: member, expires: now.Add(membershipTTL)}
+	return member
+}
+
+// forget drops the cached memberships of team, "<contestId>:<teamId>".
+func (t *Teams) forget(team string) {
+	t.cacheMu.Lock()
+	delete(t.memberships, team)
+	t.cacheMu.Unlock()
+}
+
+// isMember checks membership without the cache. Errors are logged and not
+// cached.
+func (t *Teams) isMember(ctx context.Context, contestID, teamID, userID string) (bool, error) {
+	member, err := t.redis.SIsMember(ctx, fmt.Sprintf(teamMembersKeyFmt, contestID, teamID), userID)
+	t.metrics.IncRedisOperation("team_member_check", metrics.Status(err))
+	if err != nil {
+		t.logger.Error().Err(err).Str("contestId", contestID).Str("teamId", teamID).Msg("Failed to check team membership")
+		return false, err
+	}
+	if member || t.lookup == nil {
+		return member, nil
+	}
+
+	// A user with a known team needs no lookup; it is in another team.
+	known, err := t.TeamOf(ctx, contestID, userID)
+	if err != nil || known != "" {
+		return false, err
+	}
+
+	lookedUp, members, err := t.lookup.LookupTeam(ctx, contestID, userID)
+	if errors.Is(err, ErrNoTeam) {
+		return false, nil
+	}
+	if err != nil {
+		t.logger.Error().Err(err).Str("contestId", contestID).Str("userId", userID).Msg("Failed to look up team")
+		return false, err
+	}
+	if _, err := t.Register(ctx, contestID, lookedUp, members); err != nil {
+		t.logger.Error().Err(err).Str("contestId", contestID).Str("teamId", lookedUp).Msg("Failed to cache looked up team")
+	}
+	return lookedUp == teamID, nil
+}
+
+func (t *Teams) Members(ctx context.Context, contestID, teamID string) ([]string, error) {
+	members, err := t.redis.SMembers(ctx, fmt.Sprintf(teamMembersKeyFmt, contestID, teamID))
+	t.metrics.IncRedisOperation("team_members_get", metrics.Status(err))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// TeamPresence returns which members of teamID are connected.
+func (t *Teams) TeamPresence(ctx context.Context, contestID, teamID string) (*protocol.TeamPresencePayload, error) {
+	members, err := t.Members(ctx, contestID, teamID)
+	if err != nil {
+		return nil, err
+	}
+	online, err := t.presence.GetOnlineUsers(ctx, members)
+	if err != nil {
+		return nil, err
+	}
+	return &protocol.TeamPresencePayload{
+		ContestID:   contestID,
+		TeamID:      teamID,
+		Online:      online,
+		OnlineCount: len(online),
+		MemberCount: len(members),
+	}, nil
+}
+
+// PresenceChanged tells every instance to refresh the presence of the
+// teams of userID, after the user connected or disconnected.
+func (t *Teams) PresenceChanged(ctx context.Context, userID string) {
+	teams, err := t.redis.SMembers(ctx, fmt.Sprintf(userTeamsKeyFmt, userID))
+	t.metrics.IncRedisOperation("team_user_teams", metrics.Status(err))
+	if err != nil {
+		t.logger.Error().Err(err).Str("userId", userID).Msg("Failed to load user teams")
+		return
+	}
+	for _, team := range teams {
+		t.publish(ctx, team)
+	}
+}
+
+func (t *Teams) publish(ctx context.Context, team string) {
+	err := t.redis.Publish(ctx, TeamPresenceChannel, team)
+	t.metrics.IncRedisOperation("team_presence_publish", metrics.Status(err))
+	if err != nil {
+		t.logger.Error().Err(err).Str("team", team).Msg("Failed to publish team presence change")
+	}
+}
+
+func (t *Teams) pushPresence(contestID, teamID string) {
+	roomID := t.roomID(contestID, teamID)
+	if !t.sender.HasRoomMembers(roomID) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(t.ctx, teamTimeout)
+	defer cancel()
+
+	payload, err := t.TeamPresence(ctx, contestID, teamID)
+	if err != nil {
+		t.logger.Error().Err(err).Str("roomId", roomID).Msg("Failed to load team presence")
+		return
+	}
+	msg, err := protocol.NewMessage(protocol.MsgTeamPresence, payload)
+	if err != nil {
+		return
+	}
+	t.sender.SendToRoom(roomID, msg)
+}
+
+// HTTPTeamLookup asks a backend endpoint for a user's team. The URL
+// template's {contestId} and {userId} are replaced with the escaped IDs;
+// the endpoint answers 404 for users without a team and otherwise
+// {"teamId": "...", "memberIds": [...]}.
+type HTTPTeamLookup struct {
+	urlTemplate string
+	client      *http.Client
+}
+
+func NewHTTPTeamLookup(urlTemplate string, timeout time.Duration) *HTTPTeamLookup {
+	return &HTTPTeamLookup{
+		urlTemplate: urlTemplate,
+		client:      &http.Client{Timeout: timeout},
+	}
+}
+
+func (l *HTTPTeamLookup) LookupTeam(ctx context.Context, contestID, userID string) (string, []string, error) {
+	target := strings.NewReplacer(
+		"{contestId}", url.PathEscape(contestID),
+		"{userId}", url.PathEscape(userID),
+	).Replace(l.urlTemplate)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", nil, ErrNoTeam
+	case resp.StatusCode != http.StatusOK:
+		return "", nil, fmt.Errorf("team lookup returned %s", resp.Status)
+	}
+
+	var body struct {
+		TeamID    string   `json:"teamId"`
+		MemberIDs []string `json:"memberIds"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", nil, fmt.Errorf("failed to decode team lookup: %w", err)
+	}
+	if body.TeamID == "" {
+		return "", nil, ErrNoTeam
+	}
+	return body.TeamID, body.MemberIDs, nil
+}
@@ -0,0 +1,293 @@
+package contest
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/hub"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// scheduleRetention keeps a contest's timer after it ends.
+	scheduleRetention = time.Hour
+	// milestoneGrace is how late a milestone may fire, e.g. after a
+	// restart, before it is considered missed.
+	milestoneGrace  = 5 * time.Second
+	timerResolution = time.Second
+)
+
+// Milestones sent in CONTEST_MILESTONE messages.
+const (
+	MilestoneStarted  = "STARTED"
+	MilestoneTimeLeft = "TIME_LEFT"
+	MilestoneFrozen   = "FROZEN"
+	MilestoneEnded    = "ENDED"
+)
+
+// Schedule holds the times a contest's timers run from. Zero times are
+// unknown.
+type Schedule struct {
+	ContestID  string
+	StartTime  time.Time
+	EndTime    time.Time
+	FreezeTime time.Time
+}
+
+func (s Schedule) equal(other Schedule) bool {
+	return s.StartTime.Equal(other.StartTime) &&
+		s.EndTime.Equal(other.EndTime) &&
+		s.FreezeTime.Equal(other.FreezeTime)
+}
+
+// TimerConfig tunes the pushed messages. TimeLeft lists the remaining
+// durations announced as TIME_LEFT milestones.
+type TimerConfig struct {
+	TickInterval time.Duration
+	TimeLeft     []time.Duration
+}
+
+// Sender delivers messages to the local members of a room; *hub.Hub
+// satisfies it.
+type Sender interface {
+	SendToRoom(roomID string, msg *protocol.Message)
+}
+
+type timer struct {
+	schedule Schedule
+	fired    map[string]bool
+	lastTick time.Time
+}
+
+// Timers pushes countdown ticks and milestones to contest rooms from the
+// server clock, so clients do not depend on their own clocks or on the
+// timing of backend events. Schedules come from the contest registry, which
+// shares them between instances; every instance serves its own
+// connections.
+type Timers struct {
+	registry *Registry
+	sender   Sender
+	config   TimerConfig
+	logger   zerolog.Logger
+
+	timers map[string]*timer
+	mu     sync.Mutex
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewTimers follows the schedules of registry. It must be called before
+// the registry is started.
+func NewTimers(registry *Registry, sender Sender, config TimerConfig, logger zerolog.Logger) *Timers {
+	timeLeft := append([]time.Duration(nil), config.TimeLeft...)
+	sort.Slice(timeLeft, func(i, j int) bool { return timeLeft[i] > timeLeft[j] })
+	config.TimeLeft = timeLeft
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &Timers{
+		registry: registry,
+		sender:   sender,
+		config:   config,
+		logger:   logger.With().Str("component", "contest-timers").Logger(),
+		timers:   make(map[string]*timer),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	registry.OnChange(t.reload)
+	return t
+}
+
+// Start begins running the timers.
+func (t *Timers) Start() error {
+	go t.run()
+	return nil
+}
+
+func (t *Timers) Stop() error {
+	t.cancel()
+	return nil
+}
+
+func (t *Timers) apply(schedule Schedule) {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tm, ok := t.timers[schedule.ContestID]
+	if !ok {
+		tm = &timer{}
+		t.timers[schedule.ContestID] = tm
+	}
+	if !ok || !tm.schedule.equal(schedule) {
+		// Moved times may bring milestones back into the future.
+		tm.fired = make(map[string]bool)
+	}
+	tm.schedule = schedule
+	// Milestones already in the past are not announced late.
+	for _, m := range t.milestones(schedule) {
+		if now.Sub(m.at) > milestoneGrace {
+			tm.fired[m.key] = true
+		}
+	}
+}
+
+func (t *Timers) remove(contestID string) {
+	t.mu.Lock()
+	delete(t.timers, contestID)
+	t.mu.Unlock()
+}
+
+func (t *Timers) run() {
+	ticker := time.NewTicker(timerResolution)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.tick(now)
+		}
+	}
+}
+
+// reload picks up the registry's schedule of contestID. Ended contests
+// stop their timers, and so do contests whose end time has long passed.
+func (t *Timers) reload(contestID string) {
+	schedule, ok := t.registry.Schedule(contestID)
+	if !ok || t.registry.Phase(contestID) == PhaseEnded {
+		t.remove(contestID)
+		return
+	}
+	if !schedule.EndTime.IsZero() && time.Since(schedule.EndTime) > scheduleRetention {
+		t.remove(contestID)
+		return
+	}
+	if schedule.StartTime.IsZero() && schedule.EndTime.IsZero() && schedule.FreezeTime.IsZero() {
+		return
+	}
+	t.apply(schedule)
+}
+
+type milestone struct {
+	// key is unique within the contest, e.g. "TIME_LEFT:5m0s".
+	key string
+	at  time.Time
+}
+
+// milestones returns the milestones of s in the order they are due.
+func (t *Timers) milestones(s Schedule) []milestone {
+	var due []milestone
+	if !s.StartTime.IsZero() {
+		due = append(due, milestone{MilestoneStarted, s.StartTime})
+	}
+	if !s.FreezeTime.IsZero() {
+		due = append(due, milestone{MilestoneFrozen, s.FreezeTime})
+	}
+	if !s.EndTime.IsZero() {
+		for _, left := range t.config.TimeLeft {
+			at := s.EndTime.Add(-left)
+			if at.After(s.StartTime) {
+				due = append(due, milestone{MilestoneTimeLeft + ":" + left.String(), at})
+			}
+		}
+		due = append(due, milestone{MilestoneEnded, s.EndTime})
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+	return due
+}
+
+func (t *Timers) tick(now time.Time) {
+	type pending struct {
+		roomID string
+		msg    *protocol.Message
+	}
+	var out []pending
+
+	t.mu.Lock()
+	for id, tm := range t.timers {
+		s := tm.schedule
+		roomID := hub.BuildRoomID(hub.RoomTypeContest, id)
+
+		for _, m := range t.milestones(s) {
+			if tm.fired[m.key] || now.Before(m.at) {
+				continue
+			}
+			tm.fired[m.key] = true
+			if now.Sub(m.at) > milestoneGrace {
+				continue
+			}
+			name, _, _ := strings.Cut(m.key, ":")
+			msg, err := protocol.NewMessage(protocol.MsgContestMilestone, protocol.ContestMilestonePayload{
+				ContestID:   id,
+				Milestone:   name,
+				RemainingMs: remaining(s.EndTime, now),
+				ServerTime:  now.UnixMilli(),
+			})
+			if err == nil {
+				out = append(out, pending{roomID, msg})
+			}
+		}
+
+		ended := !s.EndTime.IsZero() && !now.Before(s.EndTime)
+		if ended && now.Sub(s.EndTime) > scheduleRetention {
+			delete(t.timers, id)
+			continue
+		}
+		if ended || t.config.TickInterval <= 0 || now.Sub(tm.lastTick) < t.config.TickInterval {
+			continue
+		}
+		tm.lastTick = now
+		msg, err := protocol.NewMessage(protocol.MsgContestTick, t.tickPayload(s, now))
+		if err == nil {
+			out = append(out, pending{roomID, msg})
+		}
+	}
+	t.mu.Unlock()
+
+	for _, p := range out {
+		t.sender.SendToRoom(p.roomID, p.msg)
+	}
+}
+
+func (t *Timers) tickPayload(s Schedule, now time.Time) protocol.ContestTickPayload {
+	payload := protocol.ContestTickPayload{
+		ContestID:  s.ContestID,
+		StartTime:  unixMilli(s.StartTime),
+		EndTime:    unixMilli(s.EndTime),
+		FreezeTime: unixMilli(s.FreezeTime),
+		ServerTime: now.UnixMilli(),
+	}
+	switch {
+	case !s.StartTime.IsZero() && now.Before(s.StartTime):
+		payload.Phase = "before_start"
+		payload.RemainingMs = s.StartTime.Sub(now).Milliseconds()
+	case !s.FreezeTime.IsZero() && !now.Before(s.FreezeTime):
+		payload.Phase = "frozen"
+		payload.RemainingMs = remaining(s.EndTime, now)
+	default:
+		payload.Phase = "running"
+		payload.RemainingMs = remaining(s.EndTime, now)
+	}
+	return payload
+}
+
+func remaining(end, now time.Time) int64 {
+	if end.IsZero() || now.After(end) {
+		return 0
+	}
+	return end.Sub(now).Milliseconds()
+}
+
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/auth"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/contest"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/presence"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/revocation"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+	"github.com/rs/zerolog"
+)
+
+// AdminHandler serves the operator API. Every request must carry either the
+// configured static token or a user JWT granting auth.PermAdminCommands as
+// "Authorization: Bearer <token>".
+type AdminHandler struct {
+	token       string
+	validator   *auth.JWTValidator
+	permissions *auth.PermissionModel
+	revocations *revocation.Store
+	exclusive   *presence.Exclusivity
+	contests    *contest.Registry
+	logger      zerolog.Logger
+}
+
+func NewAdminHandler(token string, validator *auth.JWTValidator, permissions *auth.PermissionModel, revocations *revocation.Store, logger zerolog.Logger) *AdminHandler {
+	return &AdminHandler{
+		token:       token,
+		validator:   validator,
+		permissions: permissions,
+		revocations: revocations,
+		logger:      logger.With().Str("component", "admin").Logger(),
+	}
+}
+
+// SetExclusivity enables POST /admin/rooms/exclusive. It must be called
+// before Register.
+func (h *AdminHandler) SetExclusivity(exclusive *presence.Exclusivity) {
+	h.exclusive = exclusive
+}
+
+// SetRegistry enables GET /admin/contests/<id>. It must be called before
+// Register.
+func (h *AdminHandler) SetRegistry(registry *contest.Registry) {
+	h.contests = registry
+}
+
+// Register mounts the admin routes under /admin/ on mux.
+func (h *AdminHandler) Register(mux *http.ServeMux) {
+	mux.Handle("/admin/sessions/revoke", h.authorized(http.HandlerFunc(h.revokeSession)))
+	if h.exclusive != nil {
+		mux.Handle("/admin/rooms/exclusive", h.authorized(http.HandlerFunc(h.setRoomExclusive)))
+	}
+	if h.contests != nil {
+		mux.Handle("/admin/contests/", h.authorized(http.HandlerFunc(h.getContest)))
+	}
+}
+
+func (h *AdminHandler) authorized(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if h.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		claims, err := h.validator.ValidateToken(token)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !h.permissions.Resolve(claims).Has(auth.PermAdminCommands) {
+			h.logger.Warn().Str("userId", claims.GetUserID()).Str("path", r.URL.Path).Msg("Admin request without permission")
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type revokeSessionRequest struct {
+	UserID    string `json:"userId"`
+	SessionID string `json:"sessionId"`
+	Reason    string `json:"reason"`
+}
+
+func (h *AdminHandler) revokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req revokeSessionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil || req.UserID == "" {
+		http.Error(w, "Bad request: userId is required", http.StatusBadRequest)
+		return
+	}
+
+	err := h.revocations.Revoke(r.Context(), revocation.Revocation{
+		UserID:  req.UserID,
+		TokenID: req.SessionID,
+		Reason:  req.Reason,
+	})
+	if err != nil {
+		h.logger.Error().Err(err).Str("userId", req.UserID).Msg("Failed to revoke session")
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Info().
+		Str("userId", req.UserID).
+		Str("sessionId", req.SessionID).
+		Str("reason", req.Reason).
+		Str("remoteAddr", r.RemoteAddr).
+		Msg("Session revoked via admin API")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"revoked"}`))
+}
+
+type roomExclusiveRequest struct {
+	RoomID    string `json:"roomId"`
+	Exclusive bool   `json:"exclusive"`
+	// TTLSeconds overrides how long the mark lasts.
+	TTLSeconds int `json:"ttlSeconds"`
+}
+
+func (h *AdminHandler) setRoomExclusive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req roomExclusiveRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil || req.RoomID == "" {
+		http.Error(w, "Bad request: roomId is required", http.StatusBadRequest)
+		return
+	}
+
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	if err := h.exclusive.Mark(r.Context(), req.RoomID, req.Exclusive, ttl); err != nil {
+		h.logger.Error().Err(err).Str("roomId", req.RoomID).Msg("Failed to change room exclusivity")
+		http.Error(w, "Failed to change room exclusivity", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Info().
+		Str("roomId", req.RoomID).
+		Bool("exclusive", req.Exclusive).
+		Str("remoteAddr", r.RemoteAddr).
+		Msg("Room exclusivity changed via admin API")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+type contestResponse struct {
+	protocol.ContestStatePayload
+	Participants []string `json:"participants"`
+}
+
+func (h *AdminHandler) getContest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	contestID := strings.TrimPrefix(r.URL.Path, "/admin/contests/")
+	if contestID == "" || strings.Contains(contestID, "/") {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	state, ok := h.contests.Get(contestID)
+	if !ok {
+		http.Error(w, "Contest not found", http.StatusNotFound)
+		return
+	}
+
+	participants, err := h.contests.Participants(r.Context(), contestID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("contestId", contestID).Msg("Failed to list contest participants")
+		http.Error(w, "Failed to list contest participants", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(contestResponse{ContestStatePayload: state, Participants: participants})
+}
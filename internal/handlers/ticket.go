@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/auth"
+	"github.com/rs/zerolog"
+)
+
+type ticketResponse struct {
+	Ticket    string `json:"ticket"`
+	ExpiresAt int64  `json:"expiresAt"`
+}
+
+// TicketHandler issues a single-use connection ticket to the authenticated
+// caller, to be passed to /ws as ?ticket= or a "ticket.<ticket>" subprotocol.
+func TicketHandler(tickets *auth.TicketStore, logger zerolog.Logger) http.HandlerFunc {
+	logger = logger.With().Str("component", "ticket-handler").Logger()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		claims := auth.GetUserFromContext(r.Context())
+		if claims == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ticket, expiresAt, err := tickets.Issue(r.Context(), claims)
+		if err != nil {
+			logger.Error().Err(err).Str("userId", claims.GetUserID()).Msg("Failed to issue connection ticket")
+			http.Error(w, "Failed to issue ticket", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(ticketResponse{
+			Ticket:    ticket,
+			ExpiresAt: expiresAt.UnixMilli(),
+		})
+	}
+}
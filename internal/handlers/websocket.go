@@ -1,10 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/auth"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/connlimit"
 	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/hub"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/middleware"
 	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/presence"
 	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
 	"github.com/google/uuid"
@@ -12,25 +15,35 @@ import (
 	"github.com/rs/zerolog"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
-
 type WebSocketHandler struct {
-	hub       *hub.Hub
-	presence  *presence.Manager
-	logger    zerolog.Logger
+	hub      *hub.Hub
+	presence *presence.Manager
+	limiter  *connlimit.Limiter
+	upgrader websocket.Upgrader
+	logger   zerolog.Logger
 }
 
-func NewWebSocketHandler(h *hub.Hub, p *presence.Manager, logger zerolog.Logger) *WebSocketHandler {
+// NewWebSocketHandler accepts upgrades only from origins allowed by origins.
+// Requests without an Origin header come from non-browser clients and are
+// not subject to the check. Every connection takes a slot from limiter,
+// which the hub returns when the client unregisters.
+func NewWebSocketHandler(h *hub.Hub, p *presence.Manager, origins *middleware.OriginPolicy, limiter *connlimit.Limiter, logger zerolog.Logger) *WebSocketHandler {
 	return &WebSocketHandler{
-		hub:       h,
-		presence:  p,
-		logger:    logger.With().Str("component", "ws-handler").Logger(),
+		hub:      h,
+		presence: p,
+		limiter:  limiter,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			// Selecting our subprotocol lets browsers pass credentials in
+			// Sec-WebSocket-Protocol without the handshake failing.
+			Subprotocols: []string{auth.Subprotocol},
+			CheckOrigin: func(r *http.Request) bool {
+				origin := r.Header.Get("Origin")
+				return origin == "" || origins.Check(origin, "upgrade")
+			},
+		},
+		logger: logger.With().Str("component", "ws-handler").Logger(),
 	}
 }
 
@@ -41,16 +54,32 @@ func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	clientID := uuid.New().String()
+	userID := claims.GetUserID()
+	ip := middleware.ClientIP(r)
+
+	err := h.limiter.Acquire(r.Context(), connlimit.Request{ClientID: clientID, UserID: userID, IP: ip})
+	switch {
+	case errors.Is(err, connlimit.ErrInstanceLimit):
+		h.logger.Warn().Str("userId", userID).Msg("Rejected connection: instance is full")
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Service Unavailable: connection limit reached", http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		h.logger.Warn().Err(err).Str("userId", userID).Str("ip", ip).Msg("Rejected connection")
+		http.Error(w, "Too Many Connections: "+err.Error(), http.StatusTooManyRequests)
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		h.limiter.Release(clientID)
 		h.logger.Error().Err(err).Msg("Failed to upgrade connection")
 		return
 	}
 
-	clientID := uuid.New().String()
-	userID := claims.GetUserID()
-
 	client := hub.NewClient(clientID, userID, conn, h.hub, h.logger)
+	client.SetToken(claims)
 
 	h.hub.Register <- client
 
@@ -67,7 +96,7 @@ func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.logger.Info().
 		Str("clientId", clientID).
 		Str("userId", userID).
-		Str("remoteAddr", r.RemoteAddr).
+		Str("ip", ip).
 		Msg("WebSocket connection established")
 
 	go client.WritePump()
@@ -0,0 +1,145 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/hub"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
+	redisclient "github.com/CDeX-Labs/CDeX-Socket-Service/internal/redis"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+	"github.com/rs/zerolog"
+)
+
+const (
+	historyKeyFmt = "ws:history:%s:%s"
+
+	// retention expires the history of types kept without a MaxAge once
+	// nothing has been added to it for this long.
+	retention    = 24 * time.Hour
+	queueSize    = 1024
+	writeTimeout = 2 * time.Second
+)
+
+type record struct {
+	At   int64           `json:"at"` // Unix ms
+	Data json.RawMessage `json:"data"`
+}
+
+type write struct {
+	roomID string
+	entry  hub.HistoryEntry
+	policy hub.HistoryPolicy
+}
+
+// RedisStore keeps room history in one sorted set per room and message
+// type, scored by send time, so every instance replays the same messages.
+// Writes are queued and applied in order by a single goroutine.
+type RedisStore struct {
+	redis   *redisclient.Client
+	metrics *metrics.Metrics
+	logger  zerolog.Logger
+
+	queue  chan write
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewRedisStore(redis *redisclient.Client, m *metrics.Metrics, logger zerolog.Logger) *RedisStore {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RedisStore{
+		redis:   redis,
+		metrics: m,
+		logger:  logger.With().Str("component", "room-history").Logger(),
+		queue:   make(chan write, queueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+func (s *RedisStore) Start() {
+	go s.run()
+}
+
+func (s *RedisStore) Stop() {
+	s.cancel()
+}
+
+// Save queues entry for writing, dropping it when the queue is full.
+func (s *RedisStore) Save(roomID string, entry hub.HistoryEntry, policy hub.HistoryPolicy) {
+	select {
+	case s.queue <- write{roomID: roomID, entry: entry, policy: policy}:
+	default:
+		s.logger.Warn().Str("roomId", roomID).Str("type", string(entry.Type)).Msg("History queue full, dropping message")
+	}
+}
+
+func (s *RedisStore) run() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case w := <-s.queue:
+			s.write(w)
+		}
+	}
+}
+
+func (s *RedisStore) write(w write) {
+	ctx, cancel := context.WithTimeout(s.ctx, writeTimeout)
+	defer cancel()
+
+	at := w.entry.At.UnixMilli()
+	data, err := json.Marshal(record{At: at, Data: w.entry.Data})
+	if err != nil {
+		s.logger.Error().Err(err).Str("roomId", w.roomID).Msg("Failed to marshal history entry")
+		return
+	}
+
+	key := fmt.Sprintf(historyKeyFmt, w.roomID, w.entry.Type)
+	ttl := retention
+	err = s.redis.ZAdd(ctx, key, float64(at), string(data))
+	if err == nil {
+		err = s.redis.ZRemRangeByRank(ctx, key, 0, int64(-w.policy.Limit()-1))
+	}
+	if err == nil && w.policy.MaxAge > 0 {
+		ttl = w.policy.MaxAge
+		cutoff := w.entry.At.Add(-w.policy.MaxAge).UnixMilli()
+		err = s.redis.ZRemRangeByScore(ctx, key, "-inf", "("+strconv.FormatInt(cutoff, 10))
+	}
+	if err == nil {
+		err = s.redis.Expire(ctx, key, ttl)
+	}
+	s.metrics.IncRedisOperation("history_save", metrics.Status(err))
+	if err != nil {
+		s.logger.Error().Err(err).Str("roomId", w.roomID).Str("type", string(w.entry.Type)).Msg("Failed to save room history")
+	}
+}
+
+// Load returns the entries of msgType kept for roomID, oldest first.
+func (s *RedisStore) Load(ctx context.Context, roomID string, msgType protocol.MessageType, policy hub.HistoryPolicy) ([]hub.HistoryEntry, error) {
+	members, err := s.redis.ZRange(ctx, fmt.Sprintf(historyKeyFmt, roomID, msgType), int64(-policy.Limit()), -1)
+	s.metrics.IncRedisOperation("history_load", metrics.Status(err))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	entries := make([]hub.HistoryEntry, 0, len(members))
+	for _, member := range members {
+		var r record
+		if err := json.Unmarshal([]byte(member), &r); err != nil {
+			s.logger.Error().Err(err).Str("roomId", roomID).Msg("Failed to unmarshal history entry")
+			continue
+		}
+		at := time.UnixMilli(r.At)
+		if policy.Expired(at, now) {
+			continue
+		}
+		entries = append(entries, hub.HistoryEntry{Type: msgType, At: at, Data: r.Data})
+	}
+	return entries, nil
+}
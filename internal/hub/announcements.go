@@ -0,0 +1,104 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+)
+
+// ContestBoard keeps contest announcements and unread counts;
+// *contest.Board satisfies it.
+type ContestBoard interface {
+	History(ctx context.Context, contestID, userID string) (*protocol.AnnouncementsPayload, error)
+	Unread(ctx context.Context, contestID, userID string) (int, error)
+	MarkRead(ctx context.Context, contestID, userID string) error
+}
+
+// SetContestBoard sends recent announcements to clients joining a contest
+// room and enables MARK_READ and GET_UNREAD. It must be called before
+// clients are registered.
+func (h *Hub) SetContestBoard(board ContestBoard) {
+	h.board = board
+}
+
+// sendAnnouncements sends the recent announcements of a contest room that
+// client just joined.
+func (h *Hub) sendAnnouncements(client *Client, roomID string) {
+	if h.board == nil || ParseRoomType(roomID) != RoomTypeContest || ParentRoomID(roomID) != "" || IsWildcardRoom(roomID) {
+		return
+	}
+	contestID := ExtractRoomEntityID(roomID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), contestStateTimeout)
+	defer cancel()
+
+	payload, err := h.board.History(ctx, contestID, client.UserID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("roomId", roomID).Msg("Failed to load contest announcements")
+		return
+	}
+	msg, _ := protocol.NewMessage(protocol.MsgContestAnnouncements, payload)
+	h.SendToClient(client, msg)
+}
+
+func (h *Hub) handleMarkRead(client *Client, msg *protocol.Message) {
+	var payload protocol.MarkReadPayload
+	if !h.boardRequest(client, msg, &payload, &payload.ContestID) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), contestStateTimeout)
+	defer cancel()
+
+	if err := h.board.MarkRead(ctx, payload.ContestID, client.UserID); err != nil {
+		h.logger.Error().Err(err).Str("contestId", payload.ContestID).Msg("Failed to mark contest read")
+		h.sendError(client, "INTERNAL_ERROR", "Failed to mark contest read", msg.RequestID)
+		return
+	}
+	h.sendUnread(client, msg, payload.ContestID, 0)
+}
+
+func (h *Hub) handleGetUnread(client *Client, msg *protocol.Message) {
+	var payload protocol.GetUnreadPayload
+	if !h.boardRequest(client, msg, &payload, &payload.ContestID) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), contestStateTimeout)
+	defer cancel()
+
+	unread, err := h.board.Unread(ctx, payload.ContestID, client.UserID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("contestId", payload.ContestID).Msg("Failed to count unread")
+		h.sendError(client, "INTERNAL_ERROR", "Failed to count unread", msg.RequestID)
+		return
+	}
+	h.sendUnread(client, msg, payload.ContestID, unread)
+}
+
+// boardRequest decodes msg into payload and checks that the board is
+// enabled and a contest ID was given, replying with an error otherwise.
+func (h *Hub) boardRequest(client *Client, msg *protocol.Message, payload interface{}, contestID *string) bool {
+	if h.board == nil {
+		h.sendError(client, "UNSUPPORTED", "Announcements are not available", msg.RequestID)
+		return false
+	}
+	if err := json.Unmarshal(msg.Payload, payload); err != nil {
+		h.sendError(client, "INVALID_PAYLOAD", "Invalid "+string(msg.Type)+" payload", msg.RequestID)
+		return false
+	}
+	if *contestID == "" {
+		h.sendError(client, "INVALID_PAYLOAD", "Contest ID is required", msg.RequestID)
+		return false
+	}
+	return true
+}
+
+func (h *Hub) sendUnread(client *Client, msg *protocol.Message, contestID string, unread int) {
+	response, _ := protocol.NewMessageWithRequestID(protocol.MsgUnreadCount, protocol.UnreadCountPayload{
+		ContestID: contestID,
+		Unread:    unread,
+	}, msg.RequestID)
+	h.SendToClient(client, response)
+}
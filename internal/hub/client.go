@@ -1,11 +1,18 @@
 package hub
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/auth"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/tracing"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -15,17 +22,38 @@ const (
 	maxMessageSize = 512 * 1024 // 512 KB
 )
 
+// Outbound is a serialized message queued for a client, with the metadata
+// needed to account for it once it is written.
+type Outbound struct {
+	Data        []byte
+	Type        protocol.MessageType
+	EventTime   time.Time
+	SpanContext trace.SpanContext
+}
+
 type Client struct {
 	ID     string
 	UserID string
 	Hub    *Hub
 
 	Conn *websocket.Conn
-	Send chan []byte
+	Send chan Outbound
+	// sendClosed is set under the hub lock once Send is closed.
+	sendClosed bool
 
 	Rooms map[string]bool
 	mu    sync.RWMutex
 
+	tokenExpiry   time.Time
+	tokenID       string
+	tokenIssuedAt time.Time
+	permissions   auth.Permissions
+	orgs          map[string]bool
+	expiryChanged chan struct{}
+	closeRequests chan closeRequest
+	closing       atomic.Bool
+	limiter       *inboundLimiter
+
 	logger zerolog.Logger
 }
 
@@ -35,8 +63,13 @@ func NewClient(id, userID string, conn *websocket.Conn, hub *Hub, logger zerolog
 		UserID: userID,
 		Hub:    hub,
 		Conn:   conn,
-		Send:   make(chan []byte, 256),
+		Send:   make(chan Outbound, 256),
 		Rooms:  make(map[string]bool),
+
+		expiryChanged: make(chan struct{}, 1),
+		closeRequests: make(chan closeRequest, 1),
+		limiter:       newInboundLimiter(hub.limits),
+
 		logger: logger.With().Str("clientId", id).Str("userId", userID).Logger(),
 	}
 }
@@ -63,14 +96,18 @@ func (c *Client) ReadPump() {
 			break
 		}
 
+		c.Hub.metrics.IncMessagesReceived()
 		c.Hub.ProcessMessage(c, message)
 	}
 }
 
 func (c *Client) WritePump() {
 	ticker := time.NewTicker(pingPeriod)
+	var session sessionTimers
+	session.schedule(c.TokenExpiry(), c.Hub.session)
 	defer func() {
 		ticker.Stop()
+		session.stop()
 		c.Conn.Close()
 	}()
 
@@ -83,27 +120,78 @@ func (c *Client) WritePump() {
 				return
 			}
 
+			started := time.Now()
 			w, err := c.Conn.NextWriter(websocket.TextMessage)
 			if err != nil {
 				return
 			}
-			w.Write(message)
+			w.Write(message.Data)
 
+			batch := []Outbound{message}
 			n := len(c.Send)
 			for i := 0; i < n; i++ {
+				queued := <-c.Send
 				w.Write([]byte{'\n'})
-				w.Write(<-c.Send)
+				w.Write(queued.Data)
+				batch = append(batch, queued)
 			}
 
 			if err := w.Close(); err != nil {
 				return
 			}
 
+			c.recordSent(batch, started)
+
 		case <-ticker.C:
 			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+
+		case <-c.expiryChanged:
+			session.schedule(c.TokenExpiry(), c.Hub.session)
+
+		case <-session.warnC():
+			session.warn = nil
+			c.sendTokenExpiring(session.expiresAt)
+
+		case <-session.expireC():
+			c.closeExpired()
+			return
+
+		case req := <-c.closeRequests:
+			if req.final != nil {
+				c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+				c.Conn.WriteMessage(websocket.TextMessage, req.final)
+			}
+			c.writeClose(req.code, req.reason)
+			return
+		}
+	}
+}
+
+// recordSent accounts for a batch written to the socket. Messages that carry
+// a span context get a ws.write span covering the write, parented on the
+// event that produced them.
+func (c *Client) recordSent(batch []Outbound, started time.Time) {
+	now := time.Now()
+	for _, out := range batch {
+		c.Hub.metrics.IncMessagesSent()
+		if !out.EventTime.IsZero() {
+			c.Hub.metrics.ObserveDeliveryLatency(string(out.Type), now.Sub(out.EventTime).Seconds())
+		}
+		if out.SpanContext.IsValid() {
+			ctx := tracing.WithSpanContext(context.Background(), out.SpanContext)
+			_, span := tracing.Tracer().Start(ctx, "ws.write",
+				trace.WithSpanKind(trace.SpanKindProducer),
+				trace.WithTimestamp(started),
+				trace.WithAttributes(
+					attribute.String("ws.message_type", string(out.Type)),
+					attribute.String("ws.client_id", c.ID),
+					attribute.Int("ws.batch_size", len(batch)),
+				),
+			)
+			span.End(trace.WithTimestamp(now))
 		}
 	}
 }
@@ -0,0 +1,72 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/auth"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+)
+
+const contestStateTimeout = 2 * time.Second
+
+// ContestRegistry answers GET_CONTEST_STATE and decides who may join the
+// rooms of non-public contests; *contest.Registry satisfies it.
+type ContestRegistry interface {
+	// ContestState returns the state of contestID as seen by userID.
+	ContestState(ctx context.Context, contestID, userID string) (*protocol.ContestStatePayload, bool)
+	// CanView reports whether userID, a member of the organizations inOrg
+	// accepts, may see contestID.
+	CanView(ctx context.Context, contestID, userID string, inOrg func(orgID string) bool) bool
+}
+
+// SetContestRegistry enables GET_CONTEST_STATE and restricts the rooms of
+// non-public contests to their participants, invited users, organization
+// and staff. It must be called before clients are registered.
+func (h *Hub) SetContestRegistry(registry ContestRegistry) {
+	h.contests = registry
+}
+
+// canViewContest reports whether client may see contestID; everyone may
+// when no registry is set.
+func (h *Hub) canViewContest(client *Client, contestID string) bool {
+	if h.contests == nil || client.Can(auth.PermJoinStaffRooms) {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), contestStateTimeout)
+	defer cancel()
+	return h.contests.CanView(ctx, contestID, client.UserID, client.InOrg)
+}
+
+func (h *Hub) handleGetContestState(client *Client, msg *protocol.Message) {
+	if h.contests == nil {
+		h.sendError(client, "UNSUPPORTED", "Contest state is not available", msg.RequestID)
+		return
+	}
+
+	var payload protocol.GetContestStatePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		h.sendError(client, "INVALID_PAYLOAD", "Invalid contest state payload", msg.RequestID)
+		return
+	}
+	if payload.ContestID == "" {
+		h.sendError(client, "INVALID_PAYLOAD", "Contest ID is required", msg.RequestID)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), contestStateTimeout)
+	defer cancel()
+
+	// Contests the client may not see are reported as unknown, so that
+	// their metadata does not leak.
+	state, ok := h.contests.ContestState(ctx, payload.ContestID, client.UserID)
+	if !ok || !h.canViewContest(client, payload.ContestID) {
+		h.sendError(client, "CONTEST_NOT_FOUND", "Contest not found", msg.RequestID)
+		return
+	}
+
+	response, _ := protocol.NewMessageWithRequestID(protocol.MsgContestState, state, msg.RequestID)
+	h.SendToClient(client, response)
+}
@@ -0,0 +1,101 @@
+package hub
+
+import (
+	"context"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+)
+
+// ReasonSessionReplaced is the ROOM_LEFT reason given to connections removed
+// from an exclusive room because the user joined it from elsewhere.
+const ReasonSessionReplaced = "session_replaced"
+
+// ExclusiveRooms marks rooms that allow one connection per user;
+// *presence.Exclusivity satisfies it.
+type ExclusiveRooms interface {
+	IsExclusive(ctx context.Context, roomID string) bool
+	// Claim makes clientID the only connection of userID in roomID across
+	// the cluster.
+	Claim(ctx context.Context, roomID, userID, clientID string)
+}
+
+// SetExclusiveRooms enables single-connection rooms. It must be called
+// before clients are registered.
+func (h *Hub) SetExclusiveRooms(rooms ExclusiveRooms) {
+	h.exclusive = rooms
+}
+
+// claimExclusive claims roomID for client if the room is exclusive. The
+// lookup and claim may reach Redis, so they run in the background rather
+// than on the client's read path; a client that left the room meanwhile
+// claims nothing.
+func (h *Hub) claimExclusive(client *Client, roomID string) {
+	if h.exclusive == nil {
+		return
+	}
+	go func() {
+		ctx := context.Background()
+		if h.exclusive.IsExclusive(ctx, roomID) && client.IsInRoom(roomID) {
+			h.exclusive.Claim(ctx, roomID, client.UserID, client.ID)
+		}
+	}()
+}
+
+// RemoveOtherSessions takes every local connection of userID except
+// keepClientID out of roomID with a ROOM_LEFT message giving
+// ReasonSessionReplaced.
+func (h *Hub) RemoveOtherSessions(roomID, userID, keepClientID string) int {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.userClients[userID]))
+	for client := range h.userClients[userID] {
+		if client.ID != keepClientID {
+			clients = append(clients, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	removed := 0
+	for _, client := range clients {
+		if !client.IsInRoom(roomID) {
+			continue
+		}
+
+		h.rooms.LeaveRoom(roomID, client)
+		removed++
+
+		msg, _ := protocol.NewMessage(protocol.MsgRoomLeft, protocol.RoomLeftPayload{
+			RoomID: roomID,
+			Reason: ReasonSessionReplaced,
+		})
+		h.SendToClient(client, msg)
+	}
+
+	if removed > 0 {
+		h.logger.Info().
+			Str("userId", userID).
+			Str("roomId", roomID).
+			Str("keptClientId", keepClientID).
+			Int("connections", removed).
+			Msg("Removed other sessions from exclusive room")
+	}
+	return removed
+}
+
+// ConnectedUsers returns the users with at least one local connection.
+func (h *Hub) ConnectedUsers() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	users := make([]string, 0, len(h.userClients))
+	for userID := range h.userClients {
+		users = append(users, userID)
+	}
+	return users
+}
+
+// IsUserConnected reports whether userID has a local connection.
+func (h *Hub) IsUserConnected(userID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.userClients[userID]) > 0
+}
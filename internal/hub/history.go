@@ -0,0 +1,355 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+)
+
+const (
+	// maxHistoryMessages bounds the history of one message type in a room,
+	// including types kept by age alone.
+	maxHistoryMessages = 100
+	// maxHistoryReplay bounds the messages replayed on a single join, well
+	// below the capacity of a client's send buffer.
+	maxHistoryReplay = 128
+	// historyIdleTimeout drops the history of rooms without local members
+	// that have not been sent anything for this long.
+	historyIdleTimeout = time.Hour
+	historyPruneEvery  = 10 * time.Minute
+	historyLoadTimeout = 2 * time.Second
+)
+
+// HistoryPolicy is how much of one message type a room keeps for clients
+// that join it later: the last MaxMessages messages, the messages younger
+// than MaxAge, or the messages satisfying both when both are set.
+type HistoryPolicy struct {
+	MaxMessages int
+	MaxAge      time.Duration
+}
+
+// ParseHistoryPolicy parses "<maxMessages>[:<maxAge>]", e.g. "1", "20:10m"
+// or "0:30s" for every message of the last 30 seconds.
+func ParseHistoryPolicy(value string) (HistoryPolicy, error) {
+	countPart, agePart, hasAge := strings.Cut(value, ":")
+	count, err := strconv.Atoi(strings.TrimSpace(countPart))
+	if err != nil || count < 0 || count > maxHistoryMessages {
+		return HistoryPolicy{}, fmt.Errorf("invalid history size %q, want 0 to %d", countPart, maxHistoryMessages)
+	}
+
+	var age time.Duration
+	if hasAge {
+		age, err = time.ParseDuration(strings.TrimSpace(agePart))
+		if err != nil || age < 0 {
+			return HistoryPolicy{}, fmt.Errorf("invalid history age %q", agePart)
+		}
+	}
+	if count == 0 && age == 0 {
+		return HistoryPolicy{}, fmt.Errorf("history policy %q keeps nothing", value)
+	}
+	return HistoryPolicy{MaxMessages: count, MaxAge: age}, nil
+}
+
+// Limit returns the number of messages kept at most.
+func (p HistoryPolicy) Limit() int {
+	if p.MaxMessages > 0 {
+		return p.MaxMessages
+	}
+	return maxHistoryMessages
+}
+
+// Expired reports whether a message sent at at is too old to keep.
+func (p HistoryPolicy) Expired(at, now time.Time) bool {
+	return p.MaxAge > 0 && now.Sub(at) >= p.MaxAge
+}
+
+// HistoryEntry is a message kept for replay. Data is the serialized
+// message with its history flag already set.
+type HistoryEntry struct {
+	Type protocol.MessageType
+	At   time.Time
+	Data []byte
+}
+
+// HistoryStore shares room history between instances, so that a client
+// joining on one instance sees messages sent on another;
+// *history.RedisStore satisfies it.
+type HistoryStore interface {
+	// Save persists entry for roomID without blocking the caller.
+	Save(roomID string, entry HistoryEntry, policy HistoryPolicy)
+	// Load returns the entries of msgType kept for roomID, oldest first.
+	Load(ctx context.Context, roomID string, msgType protocol.MessageType, policy HistoryPolicy) ([]HistoryEntry, error)
+}
+
+type roomHistories struct {
+	store HistoryStore
+	rooms map[string]*roomHistory
+	mu    sync.Mutex
+}
+
+// roomHistory keeps one ring buffer per message type. Its lock is held
+// while a recorded message is delivered and while a client joins, so a
+// joining client gets each message either as history or live, never both.
+type roomHistory struct {
+	buffers  map[protocol.MessageType]*historyRing
+	lastSent time.Time
+	dropped  bool
+	mu       sync.Mutex
+}
+
+type historyRing struct {
+	entries []HistoryEntry
+	next    int
+}
+
+func (r *historyRing) push(entry HistoryEntry, limit int) {
+	if len(r.entries) < limit {
+		r.entries = append(r.entries, entry)
+		return
+	}
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % len(r.entries)
+}
+
+// ordered returns the entries oldest first.
+func (r *historyRing) ordered() []HistoryEntry {
+	entries := make([]HistoryEntry, 0, len(r.entries))
+	entries = append(entries, r.entries[r.next:]...)
+	return append(entries, r.entries[:r.next]...)
+}
+
+// StartHistory keeps the history configured on room types and replays it
+// to clients joining their rooms. With a store, history is shared with the
+// other instances. It must be called before clients are registered.
+func (h *Hub) StartHistory(store HistoryStore) {
+	h.history = &roomHistories{
+		store: store,
+		rooms: make(map[string]*roomHistory),
+	}
+
+	go func() {
+		ticker := time.NewTicker(historyPruneEvery)
+		defer ticker.Stop()
+		for range ticker.C {
+			h.pruneHistory()
+		}
+	}()
+}
+
+// historyPolicies returns the history kept for roomID, or nil if none is.
+func (h *Hub) historyPolicies(roomID string) map[protocol.MessageType]HistoryPolicy {
+	if h.history == nil || roomID == "global" || IsWildcardRoom(roomID) {
+		return nil
+	}
+	return h.roomTypes[ParseRoomType(roomID)].History
+}
+
+// lockHistory returns the locked history of roomID, creating it if needed.
+func (h *Hub) lockHistory(roomID string) *roomHistory {
+	for {
+		h.history.mu.Lock()
+		rh, ok := h.history.rooms[roomID]
+		if !ok {
+			rh = &roomHistory{buffers: make(map[protocol.MessageType]*historyRing)}
+			h.history.rooms[roomID] = rh
+		}
+		h.history.mu.Unlock()
+
+		rh.mu.Lock()
+		if !rh.dropped {
+			return rh
+		}
+		rh.mu.Unlock()
+	}
+}
+
+// recordHistory keeps msg for clients joining any of rooms later. The
+// returned function must be called once msg has been delivered.
+func (h *Hub) recordHistory(rooms []string, msg *protocol.Message) func() {
+	var recorded []string
+	for _, roomID := range rooms {
+		if _, ok := h.historyPolicies(roomID)[msg.Type]; ok {
+			recorded = append(recorded, roomID)
+		}
+	}
+	if len(recorded) == 0 {
+		return func() {}
+	}
+
+	flagged := *msg
+	flagged.History = true
+	data, err := flagged.ToBytes()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to serialize history message")
+		return func() {}
+	}
+	entry := HistoryEntry{Type: msg.Type, At: time.Now(), Data: data}
+
+	// Rooms are locked in order so concurrent sends cannot deadlock.
+	sort.Strings(recorded)
+	locked := make([]*roomHistory, 0, len(recorded))
+	for i, roomID := range recorded {
+		if i > 0 && roomID == recorded[i-1] {
+			continue
+		}
+		policy := h.historyPolicies(roomID)[msg.Type]
+		rh := h.lockHistory(roomID)
+		ring, ok := rh.buffers[msg.Type]
+		if !ok {
+			ring = &historyRing{}
+			rh.buffers[msg.Type] = ring
+		}
+		ring.push(entry, policy.Limit())
+		rh.lastSent = entry.At
+		locked = append(locked, rh)
+
+		if h.history.store != nil {
+			h.history.store.Save(roomID, entry, policy)
+		}
+	}
+
+	return func() {
+		for _, rh := range locked {
+			rh.mu.Unlock()
+		}
+	}
+}
+
+// joinWithHistory adds client to roomID, sends it ROOM_JOINED and then the
+// room's history, before any live message sent to the room reaches it.
+// A client without room for all of it is disconnected once the room's
+// history lock is released, so that the hub loop never runs while it is
+// held.
+func (h *Hub) joinWithHistory(client *Client, roomID, requestID string) {
+	policies := h.historyPolicies(roomID)
+	var rh *roomHistory
+	var stored []HistoryEntry
+	if len(policies) > 0 {
+		stored = h.loadStoredHistory(roomID, policies)
+		rh = h.lockHistory(roomID)
+	}
+
+	room := h.rooms.JoinRoom(roomID, client)
+
+	h.logger.Info().
+		Str("clientId", client.ID).
+		Str("roomId", roomID).
+		Int("memberCount", room.ClientCount()).
+		Msg("Client joined room")
+
+	response, _ := protocol.NewMessageWithRequestID(protocol.MsgRoomJoined, protocol.RoomJoinedPayload{
+		RoomID:      roomID,
+		MemberCount: room.ClientCount(),
+	}, requestID)
+
+	if rh == nil {
+		h.SendToClient(client, response)
+		return
+	}
+
+	delivered := true
+	if out, err := newOutbound(response); err == nil {
+		delivered = h.trySend(client, out) && h.replayHistory(client, policies, append(stored, rh.entries()...))
+	}
+	rh.mu.Unlock()
+
+	if !delivered {
+		h.disconnectSlow(client, "history")
+	}
+}
+
+func (rh *roomHistory) entries() []HistoryEntry {
+	var entries []HistoryEntry
+	for _, ring := range rh.buffers {
+		entries = append(entries, ring.ordered()...)
+	}
+	return entries
+}
+
+func (h *Hub) loadStoredHistory(roomID string, policies map[protocol.MessageType]HistoryPolicy) []HistoryEntry {
+	if h.history.store == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), historyLoadTimeout)
+	defer cancel()
+
+	var stored []HistoryEntry
+	for msgType, policy := range policies {
+		entries, err := h.history.store.Load(ctx, roomID, msgType, policy)
+		if err != nil {
+			h.logger.Error().Err(err).Str("roomId", roomID).Str("type", string(msgType)).Msg("Failed to load room history")
+			continue
+		}
+		stored = append(stored, entries...)
+	}
+	return stored
+}
+
+// replayHistory sends client the candidates each policy keeps, oldest
+// first, and reports whether its send buffer had room for them. Messages
+// both stored and kept locally are sent once.
+func (h *Hub) replayHistory(client *Client, policies map[protocol.MessageType]HistoryPolicy, candidates []HistoryEntry) bool {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].At.Before(candidates[j].At)
+	})
+
+	now := time.Now()
+	seen := make(map[string]bool, len(candidates))
+	byType := make(map[protocol.MessageType][]HistoryEntry)
+	for _, entry := range candidates {
+		policy, ok := policies[entry.Type]
+		if !ok || policy.Expired(entry.At, now) || seen[string(entry.Data)] {
+			continue
+		}
+		seen[string(entry.Data)] = true
+		byType[entry.Type] = append(byType[entry.Type], entry)
+	}
+
+	var replay []HistoryEntry
+	for msgType, entries := range byType {
+		if limit := policies[msgType].Limit(); len(entries) > limit {
+			entries = entries[len(entries)-limit:]
+		}
+		replay = append(replay, entries...)
+	}
+	sort.SliceStable(replay, func(i, j int) bool {
+		return replay[i].At.Before(replay[j].At)
+	})
+	if len(replay) > maxHistoryReplay {
+		replay = replay[len(replay)-maxHistoryReplay:]
+	}
+
+	for _, entry := range replay {
+		if !h.trySend(client, Outbound{Data: entry.Data, Type: entry.Type}) {
+			return false
+		}
+	}
+	return true
+}
+
+// pruneHistory drops the history of idle rooms without local members.
+// Rooms whose lock is held are in use and skipped, which also keeps a
+// sender holding one while it looks up another from deadlocking.
+func (h *Hub) pruneHistory() {
+	h.history.mu.Lock()
+	defer h.history.mu.Unlock()
+
+	now := time.Now()
+	for roomID, rh := range h.history.rooms {
+		if !rh.mu.TryLock() {
+			continue
+		}
+		if now.Sub(rh.lastSent) >= historyIdleTimeout && !h.HasRoomMembers(roomID) {
+			rh.dropped = true
+			delete(h.history.rooms, roomID)
+		}
+		rh.mu.Unlock()
+	}
+}
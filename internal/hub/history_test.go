@@ -0,0 +1,117 @@
+package hub
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+)
+
+func TestParseHistoryPolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    HistoryPolicy
+		wantErr bool
+	}{
+		{"1", HistoryPolicy{MaxMessages: 1}, false},
+		{"20:10m", HistoryPolicy{MaxMessages: 20, MaxAge: 10 * time.Minute}, false},
+		{"0:30s", HistoryPolicy{MaxAge: 30 * time.Second}, false},
+		{" 5 : 1h ", HistoryPolicy{MaxMessages: 5, MaxAge: time.Hour}, false},
+		{"100", HistoryPolicy{MaxMessages: 100}, false},
+		{"101", HistoryPolicy{}, true},
+		{"-1", HistoryPolicy{}, true},
+		{"0", HistoryPolicy{}, true},
+		{"0:0s", HistoryPolicy{}, true},
+		{"5:-1m", HistoryPolicy{}, true},
+		{"5:soon", HistoryPolicy{}, true},
+		{"", HistoryPolicy{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseHistoryPolicy(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHistoryPolicy(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseHistoryPolicy(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryPolicyLimits(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		policy  HistoryPolicy
+		limit   int
+		age     time.Duration
+		expired bool
+	}{
+		{"count only", HistoryPolicy{MaxMessages: 5}, 5, 24 * time.Hour, false},
+		{"age only", HistoryPolicy{MaxAge: time.Minute}, maxHistoryMessages, 30 * time.Second, false},
+		{"age reached", HistoryPolicy{MaxAge: time.Minute}, maxHistoryMessages, time.Minute, true},
+		{"both", HistoryPolicy{MaxMessages: 3, MaxAge: time.Minute}, 3, 2 * time.Minute, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Limit(); got != tt.limit {
+				t.Errorf("Limit() = %d, want %d", got, tt.limit)
+			}
+			if got := tt.policy.Expired(now.Add(-tt.age), now); got != tt.expired {
+				t.Errorf("Expired() = %v, want %v", got, tt.expired)
+			}
+		})
+	}
+}
+
+func TestHistoryRing(t *testing.T) {
+	tests := []struct {
+		name   string
+		pushed int
+		limit  int
+		want   []int
+	}{
+		{"empty", 0, 3, nil},
+		{"below limit", 2, 3, []int{0, 1}},
+		{"at limit", 3, 3, []int{0, 1, 2}},
+		{"wrapped once", 4, 3, []int{1, 2, 3}},
+		{"wrapped twice", 7, 3, []int{4, 5, 6}},
+		{"limit of one", 5, 1, []int{4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ring := &historyRing{}
+			for i := 0; i < tt.pushed; i++ {
+				ring.push(HistoryEntry{Data: []byte(strconv.Itoa(i))}, tt.limit)
+			}
+
+			got := ring.ordered()
+			if len(got) != len(tt.want) {
+				t.Fatalf("ordered() has %d entries, want %d", len(got), len(tt.want))
+			}
+			for i, entry := range got {
+				if string(entry.Data) != strconv.Itoa(tt.want[i]) {
+					t.Errorf("entry %d = %s, want %d", i, entry.Data, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRegisterRoomTypeRefusesReplayedHistory(t *testing.T) {
+	h := newTestHub(t)
+	err := h.RegisterRoomType(RoomTypeSpec{Type: "chat", History: map[protocol.MessageType]HistoryPolicy{
+		protocol.MsgContestAnnouncement: {MaxMessages: 10},
+	}})
+	if err == nil {
+		t.Fatal("announcements were accepted as room history")
+	}
+	if _, ok := h.RoomType("chat"); ok {
+		t.Error("refused room type was registered")
+	}
+}
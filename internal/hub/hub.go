@@ -3,7 +3,10 @@ package hub
 import (
 	"encoding/json"
 	"sync"
+	"time"
 
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/auth"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
 	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
 	"github.com/rs/zerolog"
 )
@@ -16,16 +19,35 @@ type Hub struct {
 	mu          sync.RWMutex
 	logger      zerolog.Logger
 	rooms       *RoomManager
+	metrics     *metrics.Metrics
+
+	authenticator   Authenticator
+	session         SessionConfig
+	permissionModel *auth.PermissionModel
+	limits          InboundLimitConfig
+	userLimits      *userLimiters
+	unregisterHooks []func(*Client)
+	exclusive       ExclusiveRooms
+	contests        ContestRegistry
+	roomTypes       map[RoomType]RoomTypeSpec
+	teams           Teams
+	board           ContestBoard
+	history         *roomHistories
 }
 
-func NewHub(logger zerolog.Logger) *Hub {
+func NewHub(m *metrics.Metrics, logger zerolog.Logger) *Hub {
 	return &Hub{
 		clients:     make(map[*Client]bool),
 		userClients: make(map[string]map[*Client]bool),
 		Register:    make(chan *Client),
 		Unregister:  make(chan *Client),
-		rooms:       NewRoomManager(),
+		rooms:       NewRoomManager(m),
+		metrics:     m,
 		logger:      logger.With().Str("component", "hub").Logger(),
+
+		permissionModel: auth.DefaultPermissionModel(),
+		userLimits:      &userLimiters{buckets: make(map[string]*tokenBucket)},
+		roomTypes:       defaultRoomTypes(),
 	}
 }
 
@@ -36,11 +58,22 @@ func (h *Hub) Run() {
 			h.registerClient(client)
 
 		case client := <-h.Unregister:
-			h.unregisterClient(client)
+			if h.unregisterClient(client) {
+				for _, fn := range h.unregisterHooks {
+					fn(client)
+				}
+			}
 		}
 	}
 }
 
+// OnUnregister registers fn to run once a client has been removed. Hooks
+// run on the hub goroutine and must not block. It must be called before
+// clients are registered.
+func (h *Hub) OnUnregister(fn func(*Client)) {
+	h.unregisterHooks = append(h.unregisterHooks, fn)
+}
+
 func (h *Hub) registerClient(client *Client) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -51,6 +84,7 @@ func (h *Hub) registerClient(client *Client) {
 		h.userClients[client.UserID] = make(map[*Client]bool)
 	}
 	h.userClients[client.UserID][client] = true
+	h.metrics.IncConnections()
 
 	h.logger.Info().
 		Str("clientId", client.ID).
@@ -59,20 +93,24 @@ func (h *Hub) registerClient(client *Client) {
 		Msg("Client registered")
 }
 
-func (h *Hub) unregisterClient(client *Client) {
+func (h *Hub) unregisterClient(client *Client) bool {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	if _, ok := h.clients[client]; ok {
+	_, ok := h.clients[client]
+	if ok {
 		h.rooms.LeaveAllRooms(client)
 
 		delete(h.clients, client)
+		client.sendClosed = true
 		close(client.Send)
+		h.metrics.DecConnections()
 
 		if userClients, ok := h.userClients[client.UserID]; ok {
 			delete(userClients, client)
 			if len(userClients) == 0 {
 				delete(h.userClients, client.UserID)
+				h.userLimits.forget(client.UserID)
 			}
 		}
 
@@ -82,13 +120,27 @@ func (h *Hub) unregisterClient(client *Client) {
 			Int("totalClients", len(h.clients)).
 			Msg("Client unregistered")
 	}
+	return ok
 }
 
 func (h *Hub) ProcessMessage(client *Client, data []byte) {
+	start := time.Now()
+	defer func() {
+		h.metrics.ObserveLatency(time.Since(start).Seconds())
+	}()
+
+	if !h.admitFrame(client) {
+		return
+	}
+
 	msg, err := protocol.ParseMessage(data)
 	if err != nil {
-		h.logger.Error().Err(err).Str("clientId", client.ID).Msg("Failed to parse message")
-		h.sendError(client, "PARSE_ERROR", "Invalid message format", "")
+		h.logger.Debug().Err(err).Str("clientId", client.ID).Msg("Failed to parse message")
+		h.violation(client, "PARSE_ERROR", "Invalid message format", "")
+		return
+	}
+
+	if !h.admitMessage(client, msg) {
 		return
 	}
 
@@ -104,6 +156,16 @@ func (h *Hub) ProcessMessage(client *Client, data []byte) {
 		h.handleLeaveRoom(client, msg)
 	case protocol.MsgPing:
 		h.handlePing(client, msg)
+	case protocol.MsgAuthenticate:
+		h.handleAuthenticate(client, msg)
+	case protocol.MsgTimeSync:
+		h.handleTimeSync(client, msg, start)
+	case protocol.MsgGetContestState:
+		h.handleGetContestState(client, msg)
+	case protocol.MsgMarkRead:
+		h.handleMarkRead(client, msg)
+	case protocol.MsgGetUnread:
+		h.handleGetUnread(client, msg)
 	default:
 		h.sendError(client, "UNKNOWN_TYPE", "Unknown message type", msg.RequestID)
 	}
@@ -121,20 +183,30 @@ func (h *Hub) handleJoinRoom(client *Client, msg *protocol.Message) {
 		return
 	}
 
-	room := h.rooms.JoinRoom(payload.RoomID, client)
+	if limit := h.limits.MaxRoomsPerClient; limit > 0 && !client.IsInRoom(payload.RoomID) && len(client.GetRooms()) >= limit {
+		h.sendError(client, "ROOM_LIMIT", "Too many rooms joined", msg.RequestID)
+		return
+	}
 
-	h.logger.Info().
-		Str("clientId", client.ID).
-		Str("roomId", payload.RoomID).
-		Int("memberCount", room.ClientCount()).
-		Msg("Client joined room")
+	if err := h.validateRoomID(payload.RoomID); err != nil {
+		h.sendError(client, "INVALID_ROOM", err.Error(), msg.RequestID)
+		return
+	}
 
-	response, _ := protocol.NewMessageWithRequestID(protocol.MsgRoomJoined, protocol.RoomJoinedPayload{
-		RoomID:      payload.RoomID,
-		MemberCount: room.ClientCount(),
-	}, msg.RequestID)
+	if !h.canJoinRoom(client, payload.RoomID) {
+		h.logger.Warn().
+			Str("clientId", client.ID).
+			Str("roomId", payload.RoomID).
+			Msg("Client not permitted to join room")
+		h.sendError(client, "FORBIDDEN", "Not permitted to join this room", msg.RequestID)
+		return
+	}
 
-	h.SendToClient(client, response)
+	h.joinWithHistory(client, payload.RoomID, msg.RequestID)
+
+	h.claimExclusive(client, payload.RoomID)
+	h.sendTeamPresence(client, payload.RoomID)
+	h.sendAnnouncements(client, payload.RoomID)
 }
 
 func (h *Hub) handleLeaveRoom(client *Client, msg *protocol.Message) {
@@ -158,58 +230,189 @@ func (h *Hub) handleLeaveRoom(client *Client, msg *protocol.Message) {
 	h.SendToClient(client, response)
 }
 
+// RemoveUserFromRoom takes every local connection of userID out of roomID and
+// tells each of them why with a ROOM_LEFT message.
+func (h *Hub) RemoveUserFromRoom(userID, roomID, reason string) int {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.userClients[userID]))
+	for client := range h.userClients[userID] {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	removed := 0
+	for _, client := range clients {
+		if !client.IsInRoom(roomID) {
+			continue
+		}
+
+		h.rooms.LeaveRoom(roomID, client)
+		removed++
+
+		msg, _ := protocol.NewMessage(protocol.MsgRoomLeft, protocol.RoomLeftPayload{
+			RoomID: roomID,
+			Reason: reason,
+		})
+		h.SendToClient(client, msg)
+	}
+
+	if removed > 0 {
+		h.logger.Info().
+			Str("userId", userID).
+			Str("roomId", roomID).
+			Str("reason", reason).
+			Int("connections", removed).
+			Msg("User removed from room")
+	}
+	return removed
+}
+
 func (h *Hub) handlePing(client *Client, msg *protocol.Message) {
 	response, _ := protocol.NewMessageWithRequestID(protocol.MsgPong, nil, msg.RequestID)
 	h.SendToClient(client, response)
 }
 
+// handleTimeSync answers with the time the frame was read and the time the
+// response was built, both from the server clock.
+func (h *Hub) handleTimeSync(client *Client, msg *protocol.Message, received time.Time) {
+	var payload protocol.TimeSyncPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		h.sendError(client, "INVALID_PAYLOAD", "Invalid time sync payload", msg.RequestID)
+		return
+	}
+
+	response, _ := protocol.NewMessageWithRequestID(protocol.MsgTimeSyncResponse, protocol.TimeSyncResponsePayload{
+		ClientSendTime:    payload.ClientSendTime,
+		ServerReceiveTime: received.UnixMilli(),
+		ServerSendTime:    time.Now().UnixMilli(),
+	}, msg.RequestID)
+	h.SendToClient(client, response)
+}
+
 func (h *Hub) SendToClient(client *Client, msg *protocol.Message) {
-	data, err := msg.ToBytes()
+	out, err := newOutbound(msg)
 	if err != nil {
 		h.logger.Error().Err(err).Msg("Failed to serialize message")
 		return
 	}
 
+	if !h.trySend(client, out) {
+		h.disconnectSlow(client, "client")
+	}
+}
+
+// trySend queues out for client without blocking and reports whether its
+// send buffer had room. Every send goes through it or sendLocked so that
+// none races unregisterClient closing Send.
+func (h *Hub) trySend(client *Client, out Outbound) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.sendLocked(client, out)
+}
+
+// sendLocked is trySend for callers holding h.mu. A client whose Send is
+// already closed is gone and counts as sent.
+func (h *Hub) sendLocked(client *Client, out Outbound) bool {
+	if client.sendClosed {
+		return true
+	}
 	select {
-	case client.Send <- data:
+	case client.Send <- out:
+		return true
 	default:
-		h.logger.Warn().Str("clientId", client.ID).Msg("Client send buffer full, disconnecting")
-		h.Unregister <- client
+		return false
 	}
 }
 
+// deliver queues out for client, a fan-out recipient, and counts a full
+// send buffer as a drop for scope. h.mu must be held.
+func (h *Hub) deliver(client *Client, out Outbound, scope string) {
+	if !h.sendLocked(client, out) {
+		h.metrics.IncSendBufferDrops(scope)
+	}
+}
+
+// disconnectSlow unregisters a client whose send buffer is full. It blocks
+// until the hub loop takes the client, so no lock the loop may need must
+// be held.
+func (h *Hub) disconnectSlow(client *Client, scope string) {
+	h.logger.Warn().Str("clientId", client.ID).Msg("Client send buffer full, disconnecting")
+	h.metrics.IncSendBufferDrops(scope)
+	h.metrics.IncSlowConsumerDisconnects()
+	h.Unregister <- client
+}
+
 func (h *Hub) SendToUser(userID string, msg *protocol.Message) {
 	h.mu.RLock()
-	clients := h.userClients[userID]
+	clients := make([]*Client, 0, len(h.userClients[userID]))
+	for client := range h.userClients[userID] {
+		clients = append(clients, client)
+	}
 	h.mu.RUnlock()
 
-	for client := range clients {
+	h.metrics.ObserveFanout("user", len(clients))
+	for _, client := range clients {
 		h.SendToClient(client, msg)
 	}
 }
 
+// SendToRoom delivers msg to the members of roomID, of the rooms nested
+// under it when its type propagates, and of matching wildcard
+// subscriptions. It is kept in the room's history when its type is.
 func (h *Hub) SendToRoom(roomID string, msg *protocol.Message) {
-	room := h.rooms.GetRoom(roomID)
-	if room == nil {
+	defer h.recordHistory([]string{roomID}, msg)()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	clients := h.rooms.Audience(roomID, h.propagates)
+	if len(clients) == 0 {
 		return
 	}
 
-	data, err := msg.ToBytes()
+	out, err := newOutbound(msg)
 	if err != nil {
 		h.logger.Error().Err(err).Msg("Failed to serialize message")
 		return
 	}
 
-	for _, client := range room.GetClients() {
-		select {
-		case client.Send <- data:
-		default:
+	h.metrics.ObserveFanout("room", len(clients))
+	for _, client := range clients {
+		h.deliver(client, out, "room")
+	}
+}
+
+// SendToRoomWithPermission delivers msg only to the clients SendToRoom
+// would reach whose token grants perm, e.g. frozen leaderboard results for
+// staff.
+func (h *Hub) SendToRoomWithPermission(roomID string, perm auth.Permission, msg *protocol.Message) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	clients := h.rooms.Audience(roomID, h.propagates)
+	if len(clients) == 0 {
+		return
+	}
+
+	out, err := newOutbound(msg)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to serialize message")
+		return
+	}
+
+	sent := 0
+	for _, client := range clients {
+		if !client.Can(perm) {
+			continue
 		}
+		sent++
+		h.deliver(client, out, "room")
 	}
+	h.metrics.ObserveFanout("room", sent)
 }
 
 func (h *Hub) Broadcast(msg *protocol.Message) {
-	data, err := msg.ToBytes()
+	out, err := newOutbound(msg)
 	if err != nil {
 		h.logger.Error().Err(err).Msg("Failed to serialize message")
 		return
@@ -218,12 +421,50 @@ func (h *Hub) Broadcast(msg *protocol.Message) {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
+	h.metrics.ObserveFanout("broadcast", len(h.clients))
 	for client := range h.clients {
-		select {
-		case client.Send <- data:
-		default:
+		h.deliver(client, out, "broadcast")
+	}
+}
+
+// SendToAudience delivers msg once to every local client that is in any of
+// rooms or belongs to any of users, however many of them it matches.
+func (h *Hub) SendToAudience(rooms, users []string, msg *protocol.Message) {
+	out, err := newOutbound(msg)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("Failed to serialize message")
+		return
+	}
+
+	defer h.recordHistory(rooms, msg)()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	audience := make(map[*Client]bool)
+	for _, roomID := range rooms {
+		for _, client := range h.rooms.Audience(roomID, h.propagates) {
+			audience[client] = true
 		}
 	}
+	for _, userID := range users {
+		for client := range h.userClients[userID] {
+			audience[client] = true
+		}
+	}
+
+	h.metrics.ObserveFanout("audience", len(audience))
+	for client := range audience {
+		h.deliver(client, out, "audience")
+	}
+}
+
+func newOutbound(msg *protocol.Message) (Outbound, error) {
+	data, err := msg.ToBytes()
+	if err != nil {
+		return Outbound{}, err
+	}
+	return Outbound{Data: data, Type: msg.Type, EventTime: msg.EventTime, SpanContext: msg.SpanContext}, nil
 }
 
 func (h *Hub) sendError(client *Client, code, message, requestID string) {
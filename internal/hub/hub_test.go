@@ -0,0 +1,61 @@
+package hub
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/auth"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+	"github.com/rs/zerolog"
+)
+
+func TestSendToUnregisteredClient(t *testing.T) {
+	h := newTestHub(t)
+	client := NewClient("c1", "u1", nil, h, zerolog.Nop())
+	h.registerClient(client)
+	if !h.unregisterClient(client) {
+		t.Fatal("client was not registered")
+	}
+
+	msg, _ := protocol.NewMessage(protocol.MsgPong, nil)
+	out, err := newOutbound(msg)
+	if err != nil {
+		t.Fatalf("newOutbound: %v", err)
+	}
+	if !h.trySend(client, out) {
+		t.Error("trySend to a closed client reported a full buffer")
+	}
+	h.SendToClient(client, msg)
+}
+
+// Every send path must hold the hub lock against unregisterClient closing
+// Send; a send on the closed channel panics.
+func TestSendRacesUnregister(t *testing.T) {
+	h := newTestHub(t)
+	msg, _ := protocol.NewMessage(protocol.MsgPong, nil)
+	sends := []func(){
+		func() { h.SendToUser("u1", msg) },
+		func() { h.SendToRoom("contest:1", msg) },
+		func() { h.SendToRoomWithPermission("contest:1", auth.PermViewFrozenResults, msg) },
+		func() { h.SendToAudience([]string{"contest:1"}, []string{"u1"}, msg) },
+		func() { h.Broadcast(msg) },
+	}
+
+	for i := 0; i < 50; i++ {
+		client := NewClient("c1", "u1", nil, h, zerolog.Nop())
+		client.permissions = h.permissionModel.Resolve(&auth.Claims{Scope: string(auth.PermViewFrozenResults)})
+		h.registerClient(client)
+		h.rooms.JoinRoom("contest:1", client)
+
+		var wg sync.WaitGroup
+		for _, send := range sends {
+			wg.Add(1)
+			go func(send func()) {
+				defer wg.Done()
+				send()
+			}(send)
+		}
+		h.unregisterClient(client)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,226 @@
+package hub
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+	"github.com/gorilla/websocket"
+)
+
+// Rate is a token bucket refilled at PerSecond up to Burst. A zero Rate is
+// unlimited.
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// ParseRate parses "<perSecond>:<burst>", e.g. "0.5:3".
+func ParseRate(s string) (Rate, error) {
+	perSecond, burst, ok := strings.Cut(s, ":")
+	if !ok {
+		return Rate{}, fmt.Errorf("invalid rate %q, want <perSecond>:<burst>", s)
+	}
+	rate, err := strconv.ParseFloat(perSecond, 64)
+	if err != nil {
+		return Rate{}, fmt.Errorf("invalid rate %q: %w", s, err)
+	}
+	n, err := strconv.Atoi(burst)
+	if err != nil {
+		return Rate{}, fmt.Errorf("invalid burst %q: %w", s, err)
+	}
+	return Rate{PerSecond: rate, Burst: n}, nil
+}
+
+func (r Rate) unlimited() bool {
+	return r.PerSecond <= 0 || r.Burst <= 0
+}
+
+// InboundLimitConfig bounds what a client may send once upgraded.
+type InboundLimitConfig struct {
+	// Connection limits every frame of a connection, before it is parsed.
+	Connection Rate
+	// PerType adds a limit per message type on each connection.
+	PerType map[protocol.MessageType]Rate
+	// User limits the frames of all of a user's connections on this
+	// instance together.
+	User Rate
+
+	MaxRoomsPerClient int
+
+	// Each throttled or malformed message is a violation. Violations older
+	// than ViolationWindow are forgotten. MuteAfter violations mute the
+	// client for MuteDuration; DisconnectAfter closes it with 1008.
+	ViolationWindow time.Duration
+	MuteAfter       int
+	MuteDuration    time.Duration
+	DisconnectAfter int
+}
+
+type tokenBucket struct {
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate Rate) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: float64(rate.Burst), last: time.Now()}
+}
+
+// allow takes a token if one is left. Per-user buckets are shared by the
+// connections' goroutines, so now may be slightly older than the last call;
+// such calls refill nothing rather than a negative amount.
+func (b *tokenBucket) allow(now time.Time) bool {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate.PerSecond
+		if burst := float64(b.rate.Burst); b.tokens > burst {
+			b.tokens = burst
+		}
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// inboundLimiter is the per-connection limiter state. It is only used from
+// the client's ReadPump goroutine.
+type inboundLimiter struct {
+	connection *tokenBucket
+	perType    map[protocol.MessageType]*tokenBucket
+
+	violations    int
+	lastViolation time.Time
+	mutedUntil    time.Time
+	disconnecting bool
+}
+
+func newInboundLimiter(config InboundLimitConfig) *inboundLimiter {
+	l := &inboundLimiter{perType: make(map[protocol.MessageType]*tokenBucket)}
+	if !config.Connection.unlimited() {
+		l.connection = newTokenBucket(config.Connection)
+	}
+	for msgType, rate := range config.PerType {
+		if !rate.unlimited() {
+			l.perType[msgType] = newTokenBucket(rate)
+		}
+	}
+	return l
+}
+
+// userLimiters holds the shared per-user buckets.
+type userLimiters struct {
+	rate    Rate
+	buckets map[string]*tokenBucket
+	mu      sync.Mutex
+}
+
+func (u *userLimiters) allow(userID string, now time.Time) bool {
+	if u.rate.unlimited() {
+		return true
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	bucket, ok := u.buckets[userID]
+	if !ok {
+		bucket = newTokenBucket(u.rate)
+		u.buckets[userID] = bucket
+	}
+	return bucket.allow(now)
+}
+
+func (u *userLimiters) forget(userID string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.buckets, userID)
+}
+
+// SetInboundLimits configures inbound message limits. It must be called
+// before clients are registered.
+func (h *Hub) SetInboundLimits(config InboundLimitConfig) {
+	h.limits = config
+	h.userLimits = &userLimiters{rate: config.User, buckets: make(map[string]*tokenBucket)}
+}
+
+// admitFrame applies the connection and user limits to a raw frame. It
+// returns false when the frame must be dropped.
+func (h *Hub) admitFrame(client *Client) bool {
+	now := time.Now()
+	l := client.limiter
+
+	if l.disconnecting {
+		return false
+	}
+	if now.Before(l.mutedUntil) {
+		// Flooding while muted still counts towards a disconnect, but is
+		// not answered.
+		h.metrics.IncThrottledMessages("muted")
+		l.violations++
+		l.lastViolation = now
+		if h.limits.DisconnectAfter > 0 && l.violations >= h.limits.DisconnectAfter {
+			h.disconnectAbusive(client)
+		}
+		return false
+	}
+
+	if (l.connection != nil && !l.connection.allow(now)) || !h.userLimits.allow(client.UserID, now) {
+		h.metrics.IncThrottledMessages("frame")
+		h.violation(client, "RATE_LIMITED", "Too many messages", "")
+		return false
+	}
+	return true
+}
+
+// admitMessage applies the per-type limit to a parsed message.
+func (h *Hub) admitMessage(client *Client, msg *protocol.Message) bool {
+	bucket, ok := client.limiter.perType[msg.Type]
+	if !ok || bucket.allow(time.Now()) {
+		return true
+	}
+	h.metrics.IncThrottledMessages(string(msg.Type))
+	h.violation(client, "RATE_LIMITED", "Too many "+string(msg.Type)+" messages", msg.RequestID)
+	return false
+}
+
+// violation records abuse by client and escalates from an error reply to a
+// temporary mute to a policy-violation disconnect.
+func (h *Hub) violation(client *Client, code, message, requestID string) {
+	now := time.Now()
+	l := client.limiter
+
+	if now.Sub(l.lastViolation) > h.limits.ViolationWindow {
+		l.violations = 0
+	}
+	l.violations++
+	l.lastViolation = now
+
+	switch {
+	case h.limits.DisconnectAfter > 0 && l.violations >= h.limits.DisconnectAfter:
+		h.disconnectAbusive(client)
+
+	case h.limits.MuteAfter > 0 && l.violations == h.limits.MuteAfter:
+		l.mutedUntil = now.Add(h.limits.MuteDuration)
+		client.logger.Warn().Int("violations", l.violations).Dur("duration", h.limits.MuteDuration).Msg("Muting client")
+		h.metrics.IncThrottledClients("mute")
+		h.sendError(client, "MUTED", "Too many violations, messages are ignored for a while", requestID)
+
+	default:
+		h.metrics.IncThrottledClients("error")
+		h.sendError(client, code, message, requestID)
+	}
+}
+
+func (h *Hub) disconnectAbusive(client *Client) {
+	client.limiter.disconnecting = true
+	client.logger.Warn().Int("violations", client.limiter.violations).Msg("Disconnecting abusive client")
+	h.metrics.IncThrottledClients("disconnect")
+	client.Close(websocket.ClosePolicyViolation, "rate limit exceeded")
+}
@@ -0,0 +1,162 @@
+package hub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Rate
+		wantErr bool
+	}{
+		{"0.5:3", Rate{PerSecond: 0.5, Burst: 3}, false},
+		{"10:20", Rate{PerSecond: 10, Burst: 20}, false},
+		{"0:0", Rate{}, false},
+		{"10", Rate{}, true},
+		{"fast:3", Rate{}, true},
+		{"1:many", Rate{}, true},
+		{"1:2.5", Rate{}, true},
+		{"", Rate{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseRate(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRate(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRate(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateUnlimited(t *testing.T) {
+	tests := []struct {
+		rate Rate
+		want bool
+	}{
+		{Rate{}, true},
+		{Rate{PerSecond: 1}, true},
+		{Rate{Burst: 1}, true},
+		{Rate{PerSecond: -1, Burst: 5}, true},
+		{Rate{PerSecond: 1, Burst: 1}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.rate.unlimited(); got != tt.want {
+			t.Errorf("%+v.unlimited() = %v, want %v", tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	tests := []struct {
+		name string
+		rate Rate
+		// offsets are the times of successive requests after the bucket
+		// was created.
+		offsets []time.Duration
+		want    []bool
+	}{
+		{
+			name:    "burst then refused",
+			rate:    Rate{PerSecond: 1, Burst: 3},
+			offsets: []time.Duration{0, 0, 0, 0},
+			want:    []bool{true, true, true, false},
+		},
+		{
+			name:    "refills over time",
+			rate:    Rate{PerSecond: 2, Burst: 1},
+			offsets: []time.Duration{0, 0, 250 * time.Millisecond, 500 * time.Millisecond},
+			want:    []bool{true, false, false, true},
+		},
+		{
+			name:    "refill capped at burst",
+			rate:    Rate{PerSecond: 10, Burst: 2},
+			offsets: []time.Duration{0, 0, time.Hour, time.Hour, time.Hour},
+			want:    []bool{true, true, true, true, false},
+		},
+		{
+			name:    "out of order times do not drain",
+			rate:    Rate{PerSecond: 1, Burst: 2},
+			offsets: []time.Duration{time.Second, -time.Second, 0},
+			want:    []bool{true, true, false},
+		},
+		{
+			name:    "fractional rate",
+			rate:    Rate{PerSecond: 0.5, Burst: 1},
+			offsets: []time.Duration{0, time.Second, 2 * time.Second},
+			want:    []bool{true, false, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := newTokenBucket(tt.rate)
+			start := bucket.last
+			for i, offset := range tt.offsets {
+				if got := bucket.allow(start.Add(offset)); got != tt.want[i] {
+					t.Fatalf("request %d at %v: allow() = %v, want %v", i, offset, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestUserLimiters(t *testing.T) {
+	limits := &userLimiters{rate: Rate{PerSecond: 1, Burst: 2}, buckets: make(map[string]*tokenBucket)}
+	now := time.Now()
+
+	for i, want := range []bool{true, true, false} {
+		if got := limits.allow("u1", now); got != want {
+			t.Fatalf("u1 request %d: allow() = %v, want %v", i, got, want)
+		}
+	}
+	if !limits.allow("u2", now) {
+		t.Error("u2 was limited by u1's bucket")
+	}
+
+	limits.forget("u1")
+	if !limits.allow("u1", now) {
+		t.Error("u1 still limited after forget")
+	}
+
+	unlimited := &userLimiters{buckets: make(map[string]*tokenBucket)}
+	for i := 0; i < 100; i++ {
+		if !unlimited.allow("u1", now) {
+			t.Fatal("zero rate limited a user")
+		}
+	}
+	if len(unlimited.buckets) != 0 {
+		t.Errorf("zero rate kept %d buckets", len(unlimited.buckets))
+	}
+}
+
+func TestNewInboundLimiter(t *testing.T) {
+	l := newInboundLimiter(InboundLimitConfig{
+		Connection: Rate{PerSecond: 5, Burst: 10},
+		PerType: map[protocol.MessageType]Rate{
+			protocol.MsgJoinRoom: {PerSecond: 1, Burst: 2},
+			protocol.MsgPing:     {},
+		},
+	})
+	if l.connection == nil {
+		t.Error("connection bucket missing")
+	}
+	if _, ok := l.perType[protocol.MsgJoinRoom]; !ok {
+		t.Error("JOIN_ROOM bucket missing")
+	}
+	if _, ok := l.perType[protocol.MsgPing]; ok {
+		t.Error("unlimited PING got a bucket")
+	}
+
+	if l := newInboundLimiter(InboundLimitConfig{}); l.connection != nil || len(l.perType) != 0 {
+		t.Error("empty config created buckets")
+	}
+}
@@ -0,0 +1,140 @@
+package hub
+
+import (
+	"context"
+	"time"
+	"unicode/utf8"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/auth"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+)
+
+// DisconnectUser closes every local connection of userID with code and
+// reason. When tokenID is set only connections authenticated with that
+// token are closed.
+func (h *Hub) DisconnectUser(userID, tokenID string, code int, reason string) int {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.userClients[userID]))
+	for client := range h.userClients[userID] {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	closed := 0
+	for _, client := range clients {
+		if client.isClosing() {
+			continue
+		}
+		if id, _ := client.tokenInfo(); tokenID != "" && id != tokenID {
+			continue
+		}
+		client.Close(code, reason)
+		closed++
+	}
+
+	if closed > 0 {
+		h.logger.Info().
+			Str("userId", userID).
+			Str("tokenId", tokenID).
+			Str("reason", reason).
+			Int("connections", closed).
+			Msg("Disconnected user")
+	}
+	return closed
+}
+
+// ReplaceClient closes the local connection clientID with a
+// SESSION_REPLACED message because a newer connection took its place. It
+// reports whether the connection was found.
+func (h *Hub) ReplaceClient(clientID, reason string) bool {
+	h.mu.RLock()
+	var target *Client
+	for client := range h.clients {
+		if client.ID == clientID {
+			target = client
+			break
+		}
+	}
+	h.mu.RUnlock()
+	if target == nil {
+		return false
+	}
+
+	msg, err := protocol.NewMessage(protocol.MsgSessionReplaced, protocol.SessionReplacedPayload{Reason: reason})
+	if err != nil {
+		return false
+	}
+	target.logger.Info().Str("reason", reason).Msg("Closing replaced connection")
+	target.CloseWithMessage(msg, protocol.CloseSessionReplaced, "session replaced")
+	return true
+}
+
+// DisconnectRevoked closes the connections affected by a revocation with
+// CloseSessionRevoked.
+func (h *Hub) DisconnectRevoked(userID, tokenID, reason string) int {
+	text := "session revoked"
+	if reason != "" {
+		text += ": " + reason
+	}
+	text = truncateReason(text)
+
+	closed := h.DisconnectUser(userID, tokenID, protocol.CloseSessionRevoked, text)
+	for i := 0; i < closed; i++ {
+		h.metrics.IncRevokedDisconnects()
+	}
+	return closed
+}
+
+// StartRevocationChecks re-checks every live connection against checker
+// each interval, catching revocations whose announcement was missed.
+func (h *Hub) StartRevocationChecks(checker auth.RevocationChecker, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			h.checkRevocations(checker)
+		}
+	}()
+}
+
+func (h *Hub) checkRevocations(checker auth.RevocationChecker) {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	ctx := context.Background()
+	for _, client := range clients {
+		// Connections already being closed are still registered until
+		// their pumps exit; they are not closed and counted again.
+		if client.isClosing() {
+			continue
+		}
+		tokenID, issuedAt := client.tokenInfo()
+		revoked, err := checker.IsRevoked(ctx, client.UserID, tokenID, issuedAt)
+		if err != nil || !revoked {
+			continue
+		}
+		client.logger.Info().Msg("Closing connection with revoked session")
+		h.metrics.IncRevokedDisconnects()
+		client.Close(protocol.CloseSessionRevoked, "session revoked")
+	}
+}
+
+// maxCloseReason is the longest reason a close frame can carry.
+const maxCloseReason = 123
+
+// truncateReason shortens reason to fit a close frame without splitting a
+// UTF-8 sequence.
+func truncateReason(reason string) string {
+	if len(reason) <= maxCloseReason {
+		return reason
+	}
+	n := maxCloseReason
+	for n > 0 && !utf8.RuneStart(reason[n]) {
+		n--
+	}
+	return reason[:n]
+}
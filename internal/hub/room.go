@@ -4,6 +4,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
 )
 
 type RoomType string
@@ -13,6 +15,25 @@ const (
 	RoomTypeContest RoomType = "contest"
 	RoomTypeProblem RoomType = "problem"
 	RoomTypeUser    RoomType = "user"
+	// RoomTypeStaff rooms ("staff:<contestId>") carry proctor and setter
+	// traffic and require auth.PermJoinStaffRooms.
+	RoomTypeStaff RoomType = "staff"
+	// RoomTypeOrg rooms ("org:<orgId>") reach the members of an
+	// organization or group, as listed in their token's orgs claim.
+	RoomTypeOrg RoomType = "org"
+	// RoomTypeTeam rooms ("team:<contestId>:<teamId>") reach the members of
+	// a contest team; see Hub.SetTeams.
+	RoomTypeTeam RoomType = "team"
+)
+
+const (
+	// roomSeparator joins the segments of nested rooms, as in
+	// "contest:42/problem:A".
+	roomSeparator = "/"
+	// wildcardID as a segment's entity ID matches every room of that type,
+	// as in "contest:*".
+	wildcardID   = "*"
+	maxRoomDepth = 4
 )
 
 type Room struct {
@@ -33,36 +54,80 @@ func NewRoom(id string) *Room {
 	}
 }
 
+// ParseRoomType returns the type of the last segment of roomID, so
+// "contest:42/problem:A" is a problem room. Whether the type is registered
+// is checked when the room is joined.
 func ParseRoomType(roomID string) RoomType {
 	if roomID == "global" {
 		return RoomTypeGlobal
 	}
 
-	parts := strings.SplitN(roomID, ":", 2)
-	if len(parts) != 2 {
-		return RoomTypeGlobal
-	}
-
-	switch parts[0] {
-	case "contest":
-		return RoomTypeContest
-	case "problem":
-		return RoomTypeProblem
-	case "user":
-		return RoomTypeUser
-	default:
+	t, _, ok := strings.Cut(lastRoomSegment(roomID), ":")
+	if !ok {
 		return RoomTypeGlobal
 	}
+	return RoomType(t)
 }
 
+// ExtractRoomEntityID returns the entity ID of the last segment of roomID.
 func ExtractRoomEntityID(roomID string) string {
-	parts := strings.SplitN(roomID, ":", 2)
-	if len(parts) == 2 {
-		return parts[1]
+	if _, id, ok := strings.Cut(lastRoomSegment(roomID), ":"); ok {
+		return id
 	}
 	return roomID
 }
 
+// ParentRoomID returns the room roomID is nested under, or "" for a
+// top-level room.
+func ParentRoomID(roomID string) string {
+	i := strings.LastIndex(roomID, roomSeparator)
+	if i < 0 {
+		return ""
+	}
+	return roomID[:i]
+}
+
+// ChildRoomID nests a room of roomType under parentID.
+func ChildRoomID(parentID string, roomType RoomType, entityID string) string {
+	return parentID + roomSeparator + BuildRoomID(roomType, entityID)
+}
+
+func lastRoomSegment(roomID string) string {
+	return roomID[strings.LastIndex(roomID, roomSeparator)+1:]
+}
+
+// IsWildcardRoom reports whether roomID is a subscription pattern such as
+// "contest:*" rather than a room messages are sent to.
+func IsWildcardRoom(roomID string) bool {
+	for _, segment := range strings.Split(roomID, roomSeparator) {
+		if _, id, _ := strings.Cut(segment, ":"); id == wildcardID {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRoomPattern reports whether roomID matches pattern segment by
+// segment, a "<type>:*" segment matching any ID of that type.
+func matchRoomPattern(pattern, roomID string) bool {
+	patternSegments := strings.Split(pattern, roomSeparator)
+	roomSegments := strings.Split(roomID, roomSeparator)
+	if len(patternSegments) != len(roomSegments) {
+		return false
+	}
+	for i, segment := range patternSegments {
+		if segment == roomSegments[i] {
+			continue
+		}
+		t, id, _ := strings.Cut(segment, ":")
+		roomType, _, _ := strings.Cut(roomSegments[i], ":")
+		if id != wildcardID || t != roomType {
+			return false
+		}
+	}
+	return true
+}
+
 func BuildRoomID(roomType RoomType, entityID string) string {
 	if roomType == RoomTypeGlobal {
 		return "global"
@@ -113,12 +178,22 @@ func (r *Room) IsEmpty() bool {
 
 type RoomManager struct {
 	rooms map[string]*Room
-	mu    sync.RWMutex
+	// children indexes existing rooms by the room they are nested under,
+	// and patterns holds the wildcard subscriptions.
+	children map[string]map[string]bool
+	patterns map[string]*Room
+	mu       sync.RWMutex
+	metrics  *metrics.Metrics
+	tracker  *roomMetricsTracker
 }
 
-func NewRoomManager() *RoomManager {
+func NewRoomManager(m *metrics.Metrics) *RoomManager {
 	return &RoomManager{
-		rooms: make(map[string]*Room),
+		rooms:    make(map[string]*Room),
+		children: make(map[string]map[string]bool),
+		patterns: make(map[string]*Room),
+		metrics:  m,
+		tracker:  newRoomMetricsTracker(m),
 	}
 }
 
@@ -132,6 +207,15 @@ func (rm *RoomManager) GetOrCreateRoom(roomID string) *Room {
 
 	room := NewRoom(roomID)
 	rm.rooms[roomID] = room
+	if IsWildcardRoom(roomID) {
+		rm.patterns[roomID] = room
+	}
+	if parent := ParentRoomID(roomID); parent != "" {
+		if rm.children[parent] == nil {
+			rm.children[parent] = make(map[string]bool)
+		}
+		rm.children[parent][roomID] = true
+	}
 	return room
 }
 
@@ -152,6 +236,14 @@ func (rm *RoomManager) RemoveRoom(roomID string) bool {
 
 	if room.IsEmpty() {
 		delete(rm.rooms, roomID)
+		delete(rm.patterns, roomID)
+		if parent := ParentRoomID(roomID); parent != "" {
+			delete(rm.children[parent], roomID)
+			if len(rm.children[parent]) == 0 {
+				delete(rm.children, parent)
+			}
+		}
+		rm.tracker.Forget(room)
 		return true
 	}
 	return false
@@ -159,6 +251,9 @@ func (rm *RoomManager) RemoveRoom(roomID string) bool {
 
 func (rm *RoomManager) JoinRoom(roomID string, client *Client) *Room {
 	room := rm.GetOrCreateRoom(roomID)
+	if !room.HasClient(client) {
+		rm.metrics.IncRoomConnections(string(room.Type))
+	}
 	room.AddClient(client)
 	client.JoinRoom(roomID) // Also track in client
 	return room
@@ -170,6 +265,9 @@ func (rm *RoomManager) LeaveRoom(roomID string, client *Client) {
 	rm.mu.RUnlock()
 
 	if room != nil {
+		if room.HasClient(client) {
+			rm.metrics.DecRoomConnections(string(room.Type))
+		}
 		room.RemoveClient(client)
 		client.LeaveRoom(roomID)
 
@@ -186,6 +284,50 @@ func (rm *RoomManager) LeaveAllRooms(client *Client) {
 	}
 }
 
+// Audience returns every client that should receive a message sent to
+// roomID: its members, the members of rooms nested under it when
+// propagate allows, and the subscribers of matching wildcard patterns.
+// Each client appears once.
+func (rm *RoomManager) Audience(roomID string, propagate func(roomID string) bool) []*Client {
+	rm.mu.RLock()
+	targets := []string{roomID}
+	for i := 0; i < len(targets); i++ {
+		if propagate(targets[i]) {
+			for child := range rm.children[targets[i]] {
+				targets = append(targets, child)
+			}
+		}
+	}
+
+	rooms := make([]*Room, 0, len(targets))
+	for _, target := range targets {
+		if room := rm.rooms[target]; room != nil {
+			rooms = append(rooms, room)
+		}
+		for pattern, room := range rm.patterns {
+			if matchRoomPattern(pattern, target) {
+				rooms = append(rooms, room)
+			}
+		}
+	}
+	rm.mu.RUnlock()
+
+	if len(rooms) == 1 {
+		return rooms[0].GetClients()
+	}
+	seen := make(map[*Client]bool)
+	var clients []*Client
+	for _, room := range rooms {
+		for _, client := range room.GetClients() {
+			if !seen[client] {
+				seen[client] = true
+				clients = append(clients, client)
+			}
+		}
+	}
+	return clients
+}
+
 func (rm *RoomManager) GetRoomsByType(roomType RoomType) []*Room {
 	rm.mu.RLock()
 	defer rm.mu.RUnlock()
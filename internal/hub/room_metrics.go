@@ -0,0 +1,173 @@
+package hub
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
+)
+
+// RoomMetricsConfig decides which rooms get their own ws_connections_by_room
+// series. Everything else is only visible through the per-type aggregate.
+type RoomMetricsConfig struct {
+	// TrackedRooms are always exposed. Entries are exact room IDs or
+	// prefixes ending in "*", e.g. "contest:*".
+	TrackedRooms []string
+	// TopK is the number of largest rooms exposed in addition to the
+	// tracked ones; only rooms with at least MinMembers qualify.
+	TopK       int
+	MinMembers int
+	Interval   time.Duration
+}
+
+// roomMetricsTracker keeps the set of per-room series bounded by refreshing
+// it periodically from a snapshot of the room manager.
+type roomMetricsTracker struct {
+	metrics *metrics.Metrics
+	config  RoomMetricsConfig
+	tracked map[string]bool
+	exposed map[string]RoomType
+	mu      sync.Mutex
+}
+
+func newRoomMetricsTracker(m *metrics.Metrics) *roomMetricsTracker {
+	return &roomMetricsTracker{
+		metrics: m,
+		tracked: make(map[string]bool),
+		exposed: make(map[string]RoomType),
+	}
+}
+
+func (t *roomMetricsTracker) Configure(config RoomMetricsConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.config = config
+}
+
+func (t *roomMetricsTracker) Track(roomID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tracked[roomID] = true
+}
+
+func (t *roomMetricsTracker) Untrack(roomID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.tracked, roomID)
+}
+
+// Forget drops the series of a room that no longer exists.
+func (t *roomMetricsTracker) Forget(room *Room) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.exposed, room.ID)
+	t.metrics.DeleteRoomConnections(string(room.Type), room.ID)
+}
+
+func (t *roomMetricsTracker) isTracked(roomID string) bool {
+	if t.tracked[roomID] {
+		return true
+	}
+	for _, pattern := range t.config.TrackedRooms {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(roomID, prefix) {
+				return true
+			}
+		} else if pattern == roomID {
+			return true
+		}
+	}
+	return false
+}
+
+type roomSize struct {
+	room  *Room
+	count int
+}
+
+// Refresh recomputes which rooms are exposed and updates their gauges.
+func (t *roomMetricsTracker) Refresh(rooms []*Room) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	selected := make(map[string]roomSize)
+	var candidates []roomSize
+	for _, room := range rooms {
+		size := roomSize{room: room, count: room.ClientCount()}
+		if t.isTracked(room.ID) {
+			selected[room.ID] = size
+		} else if t.config.TopK > 0 && size.count >= t.config.MinMembers {
+			candidates = append(candidates, size)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].count > candidates[j].count
+	})
+	if len(candidates) > t.config.TopK {
+		candidates = candidates[:t.config.TopK]
+	}
+	for _, size := range candidates {
+		selected[size.room.ID] = size
+	}
+
+	for roomID, roomType := range t.exposed {
+		if _, ok := selected[roomID]; !ok {
+			t.metrics.DeleteRoomConnections(string(roomType), roomID)
+			delete(t.exposed, roomID)
+		}
+	}
+	for roomID, size := range selected {
+		t.metrics.SetRoomConnections(string(size.room.Type), roomID, size.count)
+		t.exposed[roomID] = size.room.Type
+	}
+}
+
+// TrackRoomMetrics always exposes a per-room series for roomID, e.g. for an
+// active contest, until UntrackRoomMetrics is called.
+func (rm *RoomManager) TrackRoomMetrics(roomID string) {
+	rm.tracker.Track(roomID)
+}
+
+func (rm *RoomManager) UntrackRoomMetrics(roomID string) {
+	rm.tracker.Untrack(roomID)
+}
+
+func (rm *RoomManager) allRooms() []*Room {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	rooms := make([]*Room, 0, len(rm.rooms))
+	for _, room := range rm.rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// StartRoomMetrics refreshes the per-room series every config.Interval.
+func (h *Hub) StartRoomMetrics(config RoomMetricsConfig) {
+	h.rooms.tracker.Configure(config)
+	if config.Interval <= 0 {
+		config.Interval = 15 * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			h.rooms.tracker.Refresh(h.rooms.allRooms())
+		}
+	}()
+}
+
+// TrackRoomMetrics is a shortcut for the room manager method of the same name.
+func (h *Hub) TrackRoomMetrics(roomID string) {
+	h.rooms.TrackRoomMetrics(roomID)
+}
+
+func (h *Hub) UntrackRoomMetrics(roomID string) {
+	h.rooms.UntrackRoomMetrics(roomID)
+}
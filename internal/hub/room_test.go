@@ -0,0 +1,80 @@
+package hub
+
+import "testing"
+
+func TestParseRoomID(t *testing.T) {
+	tests := []struct {
+		roomID   string
+		roomType RoomType
+		entityID string
+		parent   string
+		wildcard bool
+	}{
+		{"global", RoomTypeGlobal, "global", "", false},
+		{"contest:42", RoomTypeContest, "42", "", false},
+		{"contest:42/problem:A", RoomTypeProblem, "A", "contest:42", false},
+		{"user:u1", RoomTypeUser, "u1", "", false},
+		{"contest:*", RoomTypeContest, "*", "", true},
+		{"contest:*/problem:A", RoomTypeProblem, "A", "contest:*", true},
+		{"contest:42/problem:*", RoomTypeProblem, "*", "contest:42", true},
+		{"lobby", RoomTypeGlobal, "lobby", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.roomID, func(t *testing.T) {
+			if got := ParseRoomType(tt.roomID); got != tt.roomType {
+				t.Errorf("ParseRoomType() = %q, want %q", got, tt.roomType)
+			}
+			if got := ExtractRoomEntityID(tt.roomID); got != tt.entityID {
+				t.Errorf("ExtractRoomEntityID() = %q, want %q", got, tt.entityID)
+			}
+			if got := ParentRoomID(tt.roomID); got != tt.parent {
+				t.Errorf("ParentRoomID() = %q, want %q", got, tt.parent)
+			}
+			if got := IsWildcardRoom(tt.roomID); got != tt.wildcard {
+				t.Errorf("IsWildcardRoom() = %v, want %v", got, tt.wildcard)
+			}
+		})
+	}
+}
+
+func TestBuildRoomID(t *testing.T) {
+	if got := BuildRoomID(RoomTypeGlobal, "ignored"); got != "global" {
+		t.Errorf("BuildRoomID(global) = %q, want global", got)
+	}
+	if got := BuildRoomID(RoomTypeContest, "42"); got != "contest:42" {
+		t.Errorf("BuildRoomID(contest) = %q, want contest:42", got)
+	}
+	if got := ChildRoomID("contest:42", RoomTypeProblem, "A"); got != "contest:42/problem:A" {
+		t.Errorf("ChildRoomID() = %q, want contest:42/problem:A", got)
+	}
+}
+
+func TestMatchRoomPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		roomID  string
+		want    bool
+	}{
+		{"contest:*", "contest:42", true},
+		{"contest:*", "problem:42", false},
+		{"contest:*", "contest:42/problem:A", false},
+		{"contest:*/problem:A", "contest:42/problem:A", true},
+		{"contest:*/problem:A", "contest:42/problem:B", false},
+		{"contest:42/problem:*", "contest:42/problem:A", true},
+		{"contest:42/problem:*", "contest:43/problem:A", false},
+		{"contest:*/problem:*", "contest:1/problem:Z", true},
+		{"contest:42", "contest:42", true},
+		{"contest:42", "contest:43", false},
+		{"contest:4*", "contest:42", false},
+		{"contest", "contest:42", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+"~"+tt.roomID, func(t *testing.T) {
+			if got := matchRoomPattern(tt.pattern, tt.roomID); got != tt.want {
+				t.Errorf("matchRoomPattern(%q, %q) = %v, want %v", tt.pattern, tt.roomID, got, tt.want)
+			}
+		})
+	}
+}
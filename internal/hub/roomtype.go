@@ -0,0 +1,206 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/auth"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+)
+
+const maxEntityIDLength = 128
+
+// joinReplayedTypes maps the message types that clients receive on joining
+// through their own mechanism to the message replaying them. Keeping them
+// as room history as well would deliver them twice.
+var joinReplayedTypes = map[protocol.MessageType]protocol.MessageType{
+	protocol.MsgContestAnnouncement: protocol.MsgContestAnnouncements,
+}
+
+// RoomTypeSpec describes a room type: how its IDs are validated, who may
+// join its rooms and how messages sent to them travel.
+type RoomTypeSpec struct {
+	Type RoomType
+	// Parents lists the types this type may be nested under, as in
+	// "contest:42/problem:A". NestedOnly forbids top-level rooms.
+	Parents    []RoomType
+	NestedOnly bool
+	// Validate checks the entity ID beyond the default rules; nil accepts
+	// any ID that passes them.
+	Validate func(entityID string) error
+	// Permission, when set, is required to join rooms of this type or any
+	// room nested under them.
+	Permission auth.Permission
+	// Authorize decides whether client may join roomID, the room ID up to
+	// and including this type's segment; nil allows everyone.
+	Authorize func(client *Client, roomID string) bool
+	// WildcardPermission allows "<type>:*" subscriptions to clients holding
+	// it. Without it wildcards are refused for this type.
+	WildcardPermission auth.Permission
+	// Propagate delivers messages sent to a room of this type to the rooms
+	// nested under it as well.
+	Propagate bool
+	// History lists the message types kept per room and replayed, flagged
+	// as history, to clients joining it; see Hub.StartHistory.
+	History map[protocol.MessageType]HistoryPolicy
+}
+
+func (s RoomTypeSpec) allowsParent(parent RoomType) bool {
+	for _, t := range s.Parents {
+		if t == parent {
+			return true
+		}
+	}
+	return false
+}
+
+func defaultRoomTypes() map[RoomType]RoomTypeSpec {
+	specs := []RoomTypeSpec{
+		{
+			Type: RoomTypeContest,
+			// Non-public contests are only open to their audience; see
+			// Hub.SetContestRegistry.
+			Authorize: func(client *Client, roomID string) bool {
+				return client.Hub.canViewContest(client, ExtractRoomEntityID(roomID))
+			},
+			WildcardPermission: auth.PermJoinStaffRooms,
+		},
+		{Type: RoomTypeProblem, Parents: []RoomType{RoomTypeContest}, WildcardPermission: auth.PermJoinStaffRooms},
+		{Type: RoomTypeUser},
+		{
+			Type:               RoomTypeStaff,
+			Permission:         auth.PermJoinStaffRooms,
+			WildcardPermission: auth.PermJoinStaffRooms,
+		},
+		{
+			Type: RoomTypeOrg,
+			Authorize: func(client *Client, roomID string) bool {
+				return client.InOrg(ExtractRoomEntityID(roomID))
+			},
+		},
+		teamRoomType(),
+	}
+
+	types := make(map[RoomType]RoomTypeSpec, len(specs))
+	for _, spec := range specs {
+		types[spec.Type] = spec
+	}
+	return types
+}
+
+// RegisterRoomType adds a room type or replaces the spec of an existing
+// one. It must be called before clients are registered.
+func (h *Hub) RegisterRoomType(spec RoomTypeSpec) error {
+	if spec.Type == "" || spec.Type == RoomTypeGlobal {
+		return fmt.Errorf("invalid room type %q", spec.Type)
+	}
+	if strings.ContainsAny(string(spec.Type), ":/*") {
+		return fmt.Errorf("room type %q must not contain ':', '/' or '*'", spec.Type)
+	}
+	for msgType := range spec.History {
+		if replayedAs, ok := joinReplayedTypes[msgType]; ok {
+			return fmt.Errorf("%s cannot be kept as history; joining clients receive it as %s", msgType, replayedAs)
+		}
+	}
+	h.roomTypes[spec.Type] = spec
+	return nil
+}
+
+// RoomType returns the spec registered for t.
+func (h *Hub) RoomType(t RoomType) (RoomTypeSpec, bool) {
+	spec, ok := h.roomTypes[t]
+	return spec, ok
+}
+
+// validateRoomID checks that roomID is "global" or a path of registered
+// "<type>:<id>" segments, each nested under a permitted parent.
+func (h *Hub) validateRoomID(roomID string) error {
+	if roomID == "global" {
+		return nil
+	}
+
+	segments := strings.Split(roomID, roomSeparator)
+	if len(segments) > maxRoomDepth {
+		return fmt.Errorf("room is nested more than %d levels deep", maxRoomDepth)
+	}
+
+	var parent RoomType
+	for i, segment := range segments {
+		t, id, ok := strings.Cut(segment, ":")
+		if !ok {
+			return fmt.Errorf("room segment %q is not <type>:<id>", segment)
+		}
+		spec, ok := h.roomTypes[RoomType(t)]
+		if !ok {
+			return fmt.Errorf("unknown room type %q", t)
+		}
+		if i == 0 && spec.NestedOnly {
+			return fmt.Errorf("%s rooms must be nested", t)
+		}
+		if i > 0 && !spec.allowsParent(parent) {
+			return fmt.Errorf("%s rooms cannot be nested under %s", t, parent)
+		}
+		if id != wildcardID {
+			if err := validateEntityID(id); err != nil {
+				return err
+			}
+			if spec.Validate != nil {
+				if err := spec.Validate(id); err != nil {
+					return err
+				}
+			}
+		}
+		parent = spec.Type
+	}
+	return nil
+}
+
+func validateEntityID(id string) error {
+	if id == "" {
+		return errors.New("room entity ID is required")
+	}
+	if len(id) > maxEntityIDLength {
+		return fmt.Errorf("room entity ID is longer than %d bytes", maxEntityIDLength)
+	}
+	if strings.ContainsRune(id, '*') || strings.IndexFunc(id, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("invalid room entity ID %q", id)
+	}
+	return nil
+}
+
+// canJoinRoom checks every segment of a validated roomID against its
+// type's rules, so nesting never bypasses the checks of a parent room.
+// Wildcard segments are gated by WildcardPermission alone.
+func (h *Hub) canJoinRoom(client *Client, roomID string) bool {
+	if roomID == "global" {
+		return true
+	}
+
+	segments := strings.Split(roomID, roomSeparator)
+	for i, segment := range segments {
+		t, id, _ := strings.Cut(segment, ":")
+		spec := h.roomTypes[RoomType(t)]
+
+		if spec.Permission != "" && !client.Can(spec.Permission) {
+			return false
+		}
+		if id == wildcardID {
+			if spec.WildcardPermission == "" || !client.Can(spec.WildcardPermission) {
+				return false
+			}
+			continue
+		}
+		if spec.Authorize != nil && !spec.Authorize(client, strings.Join(segments[:i+1], roomSeparator)) {
+			return false
+		}
+	}
+	return true
+}
+
+// propagates reports whether messages sent to roomID also reach the rooms
+// nested under it.
+func (h *Hub) propagates(roomID string) bool {
+	return h.roomTypes[ParseRoomType(roomID)].Propagate
+}
@@ -0,0 +1,99 @@
+package hub
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func newTestHub(t *testing.T) *Hub {
+	t.Helper()
+	return NewHub(nil, zerolog.Nop())
+}
+
+func TestValidateRoomID(t *testing.T) {
+	h := newTestHub(t)
+	if err := h.RegisterRoomType(RoomTypeSpec{
+		Type:       "clarification",
+		Parents:    []RoomType{RoomTypeContest},
+		NestedOnly: true,
+		Validate: func(id string) error {
+			if strings.HasPrefix(id, "x") {
+				return errors.New("ids must not start with x")
+			}
+			return nil
+		},
+	}); err != nil {
+		t.Fatalf("RegisterRoomType: %v", err)
+	}
+
+	tests := []struct {
+		roomID  string
+		wantErr string
+	}{
+		{"global", ""},
+		{"contest:42", ""},
+		{"contest:42/problem:A", ""},
+		{"contest:*", ""},
+		{"contest:*/problem:*", ""},
+		{"contest:42/clarification:7", ""},
+		{"lobby", "is not <type>:<id>"},
+		{"chat:1", "unknown room type"},
+		{"problem:A/contest:42", "cannot be nested under"},
+		{"user:u1/problem:A", "cannot be nested under"},
+		{"clarification:7", "must be nested"},
+		{"contest:42/clarification:x1", "must not start with x"},
+		{"contest:", "entity ID is required"},
+		{"contest:4 2", "invalid room entity ID"},
+		{"contest:4*2", "invalid room entity ID"},
+		{"contest:" + strings.Repeat("a", maxEntityIDLength+1), "longer than"},
+		{"contest:1/problem:A/problem:B", "cannot be nested under"},
+		{"contest:1/problem:A/x:1/y:2/z:3", "nested more than"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.roomID, func(t *testing.T) {
+			err := h.validateRoomID(tt.roomID)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateRoomID() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateRoomID() = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegisterRoomType(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    RoomTypeSpec
+		wantErr bool
+	}{
+		{"valid", RoomTypeSpec{Type: "chat"}, false},
+		{"replaces default", RoomTypeSpec{Type: RoomTypeProblem}, false},
+		{"empty", RoomTypeSpec{}, true},
+		{"global", RoomTypeSpec{Type: RoomTypeGlobal}, true},
+		{"colon", RoomTypeSpec{Type: "a:b"}, true},
+		{"slash", RoomTypeSpec{Type: "a/b"}, true},
+		{"star", RoomTypeSpec{Type: "a*"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHub(t)
+			err := h.RegisterRoomType(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RegisterRoomType() = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, ok := h.RoomType(tt.spec.Type); !tt.wantErr && !ok {
+				t.Errorf("room type %q not registered", tt.spec.Type)
+			}
+		})
+	}
+}
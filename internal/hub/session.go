@@ -0,0 +1,254 @@
+package hub
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/auth"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+	"github.com/gorilla/websocket"
+)
+
+// Authenticator validates tokens presented on an open socket;
+// *auth.JWTValidator satisfies it.
+type Authenticator interface {
+	ValidateToken(token string) (*auth.Claims, error)
+}
+
+// SessionConfig controls token expiry enforcement on live connections.
+type SessionConfig struct {
+	// WarnBefore is how long before expiry TOKEN_EXPIRING is sent.
+	WarnBefore time.Duration
+	// Grace is how long after expiry a connection may still re-authenticate
+	// before it is closed with CloseTokenExpired.
+	Grace time.Duration
+}
+
+// SetAuthenticator enables in-band AUTHENTICATE messages. It must be called
+// before clients are registered.
+func (h *Hub) SetAuthenticator(authenticator Authenticator, config SessionConfig) {
+	h.authenticator = authenticator
+	h.session = config
+}
+
+// SetToken records the connection's current token and its permissions and
+// reschedules the expiry warning and close. A token without exp disables
+// both.
+func (c *Client) SetToken(claims *auth.Claims) {
+	var expiresAt time.Time
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+
+	permissions := c.Hub.permissionModel.Resolve(claims)
+	orgs := make(map[string]bool, len(claims.Orgs))
+	for _, org := range claims.Orgs {
+		orgs[org] = true
+	}
+
+	c.mu.Lock()
+	c.tokenExpiry = expiresAt
+	c.tokenID = claims.ID
+	c.tokenIssuedAt = claims.IssuedAtTime()
+	c.permissions = permissions
+	c.orgs = orgs
+	c.mu.Unlock()
+
+	select {
+	case c.expiryChanged <- struct{}{}:
+	default:
+	}
+}
+
+// SetPermissionModel replaces the default role to permission mapping. It
+// must be called before clients are registered.
+func (h *Hub) SetPermissionModel(model *auth.PermissionModel) {
+	h.permissionModel = model
+}
+
+// Permissions returns what the connection's current token allows.
+func (c *Client) Permissions() auth.Permissions {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.permissions
+}
+
+func (c *Client) Can(perm auth.Permission) bool {
+	return c.Permissions().Has(perm)
+}
+
+// InOrg reports whether the connection's current token lists orgID.
+func (c *Client) InOrg(orgID string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.orgs[orgID]
+}
+
+func (c *Client) TokenExpiry() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tokenExpiry
+}
+
+func (h *Hub) handleAuthenticate(client *Client, msg *protocol.Message) {
+	if h.authenticator == nil {
+		h.sendError(client, "UNSUPPORTED", "Re-authentication is not enabled", msg.RequestID)
+		return
+	}
+
+	var payload protocol.AuthenticatePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil || payload.Token == "" {
+		h.sendError(client, "INVALID_PAYLOAD", "Invalid authenticate payload", msg.RequestID)
+		return
+	}
+
+	claims, err := h.authenticator.ValidateToken(payload.Token)
+	if err != nil {
+		h.metrics.IncAuthFailures()
+		h.sendError(client, "AUTH_FAILED", err.Error(), msg.RequestID)
+		return
+	}
+
+	if claims.GetUserID() != client.UserID {
+		h.metrics.IncAuthFailures()
+		h.logger.Warn().
+			Str("clientId", client.ID).
+			Str("userId", client.UserID).
+			Str("tokenUserId", claims.GetUserID()).
+			Msg("Re-authentication with token for a different user")
+		h.sendError(client, "AUTH_MISMATCH", "Token subject does not match connection", msg.RequestID)
+		return
+	}
+
+	client.SetToken(claims)
+	expiresAt := client.TokenExpiry()
+
+	h.logger.Debug().
+		Str("clientId", client.ID).
+		Time("expiresAt", expiresAt).
+		Msg("Client re-authenticated")
+
+	var payloadExpiry int64
+	if !expiresAt.IsZero() {
+		payloadExpiry = expiresAt.UnixMilli()
+	}
+	response, _ := protocol.NewMessageWithRequestID(protocol.MsgAuthenticated, protocol.AuthenticatedPayload{
+		ExpiresAt: payloadExpiry,
+	}, msg.RequestID)
+	h.SendToClient(client, response)
+}
+
+// sessionTimers drives the expiry warning and close for one connection from
+// inside WritePump.
+type sessionTimers struct {
+	warn   *time.Timer
+	expire *time.Timer
+	// expiresAt is the expiry the timers were scheduled for.
+	expiresAt time.Time
+}
+
+func (t *sessionTimers) schedule(expiresAt time.Time, config SessionConfig) {
+	t.stop()
+	t.expiresAt = expiresAt
+	if expiresAt.IsZero() {
+		return
+	}
+
+	warnIn := time.Until(expiresAt.Add(-config.WarnBefore))
+	if warnIn < 0 {
+		warnIn = 0
+	}
+	t.warn = time.NewTimer(warnIn)
+	t.expire = time.NewTimer(time.Until(expiresAt.Add(config.Grace)))
+}
+
+func (t *sessionTimers) stop() {
+	if t.warn != nil {
+		t.warn.Stop()
+		t.warn = nil
+	}
+	if t.expire != nil {
+		t.expire.Stop()
+		t.expire = nil
+	}
+}
+
+func (t *sessionTimers) warnC() <-chan time.Time {
+	if t.warn == nil {
+		return nil
+	}
+	return t.warn.C
+}
+
+func (t *sessionTimers) expireC() <-chan time.Time {
+	if t.expire == nil {
+		return nil
+	}
+	return t.expire.C
+}
+
+func (c *Client) sendTokenExpiring(expiresAt time.Time) {
+	msg, _ := protocol.NewMessage(protocol.MsgTokenExpiring, protocol.TokenExpiringPayload{
+		ExpiresAt: expiresAt.UnixMilli(),
+		CloseAt:   expiresAt.Add(c.Hub.session.Grace).UnixMilli(),
+	})
+	c.Hub.SendToClient(c, msg)
+}
+
+// tokenInfo returns the jti and iat of the connection's current token.
+func (c *Client) tokenInfo() (string, time.Time) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tokenID, c.tokenIssuedAt
+}
+
+type closeRequest struct {
+	code   int
+	reason string
+	// final is written just before the close frame.
+	final []byte
+}
+
+// Close asks WritePump to send a close frame with code and reason and end
+// the connection. Only the first request is honored.
+func (c *Client) Close(code int, reason string) {
+	if !c.closing.CompareAndSwap(false, true) {
+		return
+	}
+	select {
+	case c.closeRequests <- closeRequest{code: code, reason: reason}:
+	default:
+	}
+}
+
+// CloseWithMessage is Close preceded by msg, so the client learns why it is
+// being disconnected even if its queue is full.
+func (c *Client) CloseWithMessage(msg *protocol.Message, code int, reason string) {
+	if !c.closing.CompareAndSwap(false, true) {
+		return
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		c.logger.Error().Err(err).Msg("Failed to marshal final message")
+	}
+	select {
+	case c.closeRequests <- closeRequest{code: code, reason: reason, final: data}:
+	default:
+	}
+}
+
+// isClosing reports whether Close or CloseWithMessage was called.
+func (c *Client) isClosing() bool {
+	return c.closing.Load()
+}
+
+func (c *Client) writeClose(code int, reason string) {
+	c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+	c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+}
+
+func (c *Client) closeExpired() {
+	c.logger.Info().Msg("Closing connection with expired token")
+	c.Hub.metrics.IncAuthFailures()
+	c.writeClose(protocol.CloseTokenExpired, "token expired")
+}
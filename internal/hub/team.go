@@ -0,0 +1,101 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/auth"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+)
+
+const teamTimeout = 2 * time.Second
+
+// Teams backs team rooms; *contest.Teams satisfies it.
+type Teams interface {
+	IsMember(ctx context.Context, contestID, teamID, userID string) bool
+	TeamPresence(ctx context.Context, contestID, teamID string) (*protocol.TeamPresencePayload, error)
+}
+
+// SetTeams lets members of a team join its "team:<contestId>:<teamId>"
+// room; without it only staff may join team rooms. It must be called
+// before clients are registered.
+func (h *Hub) SetTeams(teams Teams) {
+	h.teams = teams
+}
+
+func teamRoomType() RoomTypeSpec {
+	return RoomTypeSpec{
+		Type: RoomTypeTeam,
+		Validate: func(entityID string) error {
+			if _, _, ok := splitTeamEntity(entityID); !ok {
+				return errors.New("team rooms are team:<contestId>:<teamId>")
+			}
+			return nil
+		},
+		Authorize: func(client *Client, roomID string) bool {
+			if client.Can(auth.PermJoinStaffRooms) {
+				return true
+			}
+			h := client.Hub
+			if h.teams == nil {
+				return false
+			}
+			contestID, teamID, _ := ParseTeamRoomID(roomID)
+			ctx, cancel := context.WithTimeout(context.Background(), teamTimeout)
+			defer cancel()
+			return h.teams.IsMember(ctx, contestID, teamID, client.UserID)
+		},
+		WildcardPermission: auth.PermJoinStaffRooms,
+	}
+}
+
+// TeamRoomID returns the room of teamID in contestID.
+func TeamRoomID(contestID, teamID string) string {
+	return BuildRoomID(RoomTypeTeam, contestID+":"+teamID)
+}
+
+// ParseTeamRoomID splits a team room ID into its contest and team.
+func ParseTeamRoomID(roomID string) (contestID, teamID string, ok bool) {
+	if ParseRoomType(roomID) != RoomTypeTeam {
+		return "", "", false
+	}
+	return splitTeamEntity(ExtractRoomEntityID(roomID))
+}
+
+func splitTeamEntity(entityID string) (contestID, teamID string, ok bool) {
+	contestID, teamID, ok = strings.Cut(entityID, ":")
+	if !ok || contestID == "" || teamID == "" || strings.Contains(teamID, ":") {
+		return "", "", false
+	}
+	return contestID, teamID, true
+}
+
+// HasRoomMembers reports whether roomID has local members.
+func (h *Hub) HasRoomMembers(roomID string) bool {
+	return h.getRoomMemberCount(roomID) > 0
+}
+
+// sendTeamPresence tells a client that just joined a team room which of
+// its teammates are online.
+func (h *Hub) sendTeamPresence(client *Client, roomID string) {
+	if h.teams == nil {
+		return
+	}
+	contestID, teamID, ok := ParseTeamRoomID(roomID)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), teamTimeout)
+	defer cancel()
+
+	payload, err := h.teams.TeamPresence(ctx, contestID, teamID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("roomId", roomID).Msg("Failed to load team presence")
+		return
+	}
+	msg, _ := protocol.NewMessage(protocol.MsgTeamPresence, payload)
+	h.SendToClient(client, msg)
+}
@@ -2,43 +2,97 @@ package kafka
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/tracing"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/events"
 	"github.com/segmentio/kafka-go"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	defaultWorkersPerTopic = 8
+	defaultMaxInFlight     = 256
+)
+
+// ConsumerConfig holds the consumer group and fetch settings for a Consumer.
+type ConsumerConfig struct {
+	Brokers           []string
+	GroupID           string
+	Topics            []string
+	StartOffset       int64
+	MinBytes          int
+	MaxBytes          int
+	MaxWait           time.Duration
+	CommitInterval    time.Duration
+	SessionTimeout    time.Duration
+	HeartbeatInterval time.Duration
+	RebalanceTimeout  time.Duration
+	Workers           int
+	MaxInFlight       int
+}
+
+// ParseStartOffset maps "earliest"/"first" and "latest"/"last" to the
+// corresponding kafka-go offsets.
+func ParseStartOffset(value string) (int64, error) {
+	switch strings.ToLower(value) {
+	case "", "latest", "last":
+		return kafka.LastOffset, nil
+	case "earliest", "first":
+		return kafka.FirstOffset, nil
+	default:
+		return 0, fmt.Errorf("unknown start offset %q", value)
+	}
+}
+
+// RebalanceHook is called with the topic partitions involved in a consumer
+// group assignment or revocation.
+type RebalanceHook func(generationID int32, partitions map[string][]int)
+
 type Consumer struct {
-	readers  []*kafka.Reader
-	handlers map[string]EventHandler
-	logger   zerolog.Logger
-	ctx      context.Context
-	cancel   context.CancelFunc
+	config     ConsumerConfig
+	group      *kafka.ConsumerGroup
+	handlers   map[string]EventHandler
+	pools      map[string]*topicPool
+	keyFunc    KeyFunc
+	schemas    *events.SchemaRegistry
+	schemaOpts SchemaOptions
+	onAssigned []RebalanceHook
+	onRevoked  []RebalanceHook
+	metrics    *metrics.Metrics
+	logger     zerolog.Logger
+	ctx        context.Context
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
 }
 
 type EventHandler func(ctx context.Context, message kafka.Message) error
 
-func NewConsumer(brokers []string, groupID string, topics []string, logger zerolog.Logger) *Consumer {
+func NewConsumer(cfg ConsumerConfig, m *metrics.Metrics, logger zerolog.Logger) *Consumer {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	readers := make([]*kafka.Reader, 0, len(topics))
-	for _, topic := range topics {
-		reader := kafka.NewReader(kafka.ReaderConfig{
-			Brokers:        brokers,
-			GroupID:        groupID,
-			Topic:          topic,
-			MinBytes:       10e3,
-			MaxBytes:       10e6,
-			MaxWait:        1 * time.Second,
-			CommitInterval: 1 * time.Second,
-			StartOffset:    kafka.LastOffset,
-		})
-		readers = append(readers, reader)
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultWorkersPerTopic
+	}
+	if cfg.MaxInFlight <= 0 {
+		cfg.MaxInFlight = defaultMaxInFlight
 	}
 
 	return &Consumer{
-		readers:  readers,
+		config:   cfg,
 		handlers: make(map[string]EventHandler),
+		pools:    make(map[string]*topicPool),
+		keyFunc:  DefaultKeyFunc,
+		metrics:  m,
 		logger:   logger.With().Str("component", "kafka").Logger(),
 		ctx:      ctx,
 		cancel:   cancel,
@@ -49,67 +103,310 @@ func (c *Consumer) RegisterHandler(topic string, handler EventHandler) {
 	c.handlers[topic] = handler
 }
 
-func (c *Consumer) Start() {
-	for _, reader := range c.readers {
-		go c.consumeFromReader(reader)
+// SetKeyFunc overrides how ordering keys are derived. It must be called
+// before Start.
+func (c *Consumer) SetKeyFunc(fn KeyFunc) {
+	c.keyFunc = fn
+}
+
+// SchemaOptions controls how payloads are checked against a SchemaRegistry.
+type SchemaOptions struct {
+	// DefaultVersion is assumed when a message has no schemaVersion header.
+	DefaultVersion string
+	// RequireSchema rejects messages on topics with no registered schema.
+	RequireSchema bool
+}
+
+// SetSchemas enables payload validation before dispatch. Messages that fail
+// validation are logged, counted and skipped. It must be called before Start.
+func (c *Consumer) SetSchemas(registry *events.SchemaRegistry, opts SchemaOptions) {
+	c.schemas = registry
+	c.schemaOpts = opts
+}
+
+// OnPartitionsAssigned registers a hook run each time this instance joins a
+// new consumer group generation. It must be called before Start.
+func (c *Consumer) OnPartitionsAssigned(hook RebalanceHook) {
+	c.onAssigned = append(c.onAssigned, hook)
+}
+
+// OnPartitionsRevoked registers a hook run when a generation ends and its
+// partitions are given up. It must be called before Start.
+func (c *Consumer) OnPartitionsRevoked(hook RebalanceHook) {
+	c.onRevoked = append(c.onRevoked, hook)
+}
+
+func (c *Consumer) Start() error {
+	group, err := kafka.NewConsumerGroup(kafka.ConsumerGroupConfig{
+		ID:                c.config.GroupID,
+		Brokers:           c.config.Brokers,
+		Topics:            c.config.Topics,
+		StartOffset:       c.config.StartOffset,
+		SessionTimeout:    c.config.SessionTimeout,
+		HeartbeatInterval: c.config.HeartbeatInterval,
+		RebalanceTimeout:  c.config.RebalanceTimeout,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create consumer group: %w", err)
+	}
+	c.group = group
+
+	for _, topic := range c.config.Topics {
+		topic := topic
+		c.pools[topic] = newTopicPool(
+			topic,
+			c.config.Workers,
+			c.config.MaxInFlight,
+			c.config.CommitInterval,
+			c.keyFunc,
+			func(msg kafka.Message) { c.handleMessage(topic, msg) },
+			c.logger,
+		)
 	}
-	c.logger.Info().Int("topics", len(c.readers)).Msg("Kafka consumer started")
+
+	c.wg.Add(1)
+	go c.run()
+
+	c.logger.Info().
+		Strs("topics", c.config.Topics).
+		Str("groupId", c.config.GroupID).
+		Int("workersPerTopic", c.config.Workers).
+		Int("maxInFlight", c.config.MaxInFlight).
+		Msg("Kafka consumer started")
+	return nil
 }
 
-func (c *Consumer) consumeFromReader(reader *kafka.Reader) {
-	topic := reader.Config().Topic
-	c.logger.Info().Str("topic", topic).Msg("Starting consumer for topic")
+// run joins each consumer group generation in turn and starts one fetch
+// loop per assigned partition.
+func (c *Consumer) run() {
+	defer c.wg.Done()
 
 	for {
+		gen, err := c.group.Next(c.ctx)
+		if err != nil {
+			if c.ctx.Err() != nil || errors.Is(err, kafka.ErrGroupClosed) {
+				return
+			}
+			c.logger.Error().Err(err).Msg("Failed to join consumer group generation")
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
+		c.startGeneration(gen)
+	}
+}
+
+func (c *Consumer) startGeneration(gen *kafka.Generation) {
+	partitions := make(map[string][]int, len(gen.Assignments))
+	for topic, assignments := range gen.Assignments {
+		for _, assignment := range assignments {
+			partitions[topic] = append(partitions[topic], assignment.ID)
+		}
+	}
+
+	c.logger.Info().
+		Int32("generationId", gen.ID).
+		Str("memberId", gen.MemberID).
+		Interface("partitions", partitions).
+		Msg("Partitions assigned")
+	c.metrics.IncKafkaRebalance("assigned")
+	for _, hook := range c.onAssigned {
+		hook(gen.ID, partitions)
+	}
+
+	for topic, assignments := range gen.Assignments {
+		pool, ok := c.pools[topic]
+		if !ok {
+			continue
+		}
+		for _, assignment := range assignments {
+			topic, assignment := topic, assignment
+			gen.Start(func(ctx context.Context) {
+				c.consumePartition(ctx, gen, pool, topic, assignment)
+			})
+		}
+	}
+
+	gen.Start(func(ctx context.Context) {
+		<-ctx.Done()
+		c.revokeGeneration(gen, partitions)
+	})
+}
+
+func (c *Consumer) revokeGeneration(gen *kafka.Generation, partitions map[string][]int) {
+	// This is the generation's last chance to commit; the pools forget it
+	// afterwards so that nothing is committed through it once it ended.
+	for topic := range partitions {
+		if pool, ok := c.pools[topic]; ok {
+			pool.Revoke(gen, c.config.RebalanceTimeout/2)
+		}
+	}
+
+	c.logger.Info().
+		Int32("generationId", gen.ID).
+		Str("memberId", gen.MemberID).
+		Interface("partitions", partitions).
+		Msg("Partitions revoked")
+	c.metrics.IncKafkaRebalance("revoked")
+	for topic, ids := range partitions {
+		for _, id := range ids {
+			c.metrics.DeleteKafkaLag(topic, id)
+		}
+	}
+	for _, hook := range c.onRevoked {
+		hook(gen.ID, partitions)
+	}
+}
+
+func (c *Consumer) consumePartition(ctx context.Context, gen *kafka.Generation, pool *topicPool, topic string, assignment kafka.PartitionAssignment) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   c.config.Brokers,
+		Topic:     topic,
+		Partition: assignment.ID,
+		MinBytes:  c.config.MinBytes,
+		MaxBytes:  c.config.MaxBytes,
+		MaxWait:   c.config.MaxWait,
+	})
+	defer reader.Close()
+
+	// Returning would end the generation for every partition, so a
+	// partition that cannot be positioned is retried instead.
+	for {
+		err := reader.SetOffset(assignment.Offset)
+		if err == nil {
+			break
+		}
+		c.logger.Error().Err(err).Str("topic", topic).Int("partition", assignment.ID).Msg("Failed to set partition offset, retrying")
 		select {
-		case <-c.ctx.Done():
+		case <-ctx.Done():
 			return
-		default:
-			msg, err := reader.FetchMessage(c.ctx)
-			if err != nil {
-				if c.ctx.Err() != nil {
-					return
-				}
-				c.logger.Error().Err(err).Str("topic", topic).Msg("Failed to fetch message")
-				time.Sleep(1 * time.Second)
-				continue
-			}
+		case <-time.After(1 * time.Second):
+		}
+	}
 
-			c.logger.Debug().
-				Str("topic", topic).
-				Int("partition", msg.Partition).
-				Int64("offset", msg.Offset).
-				Msg("Received message")
-
-			handler, ok := c.handlers[topic]
-			if !ok {
-				c.logger.Warn().Str("topic", topic).Msg("No handler registered for topic")
-				reader.CommitMessages(c.ctx, msg)
-				continue
-			}
+	c.logger.Info().
+		Str("topic", topic).
+		Int("partition", assignment.ID).
+		Int64("offset", assignment.Offset).
+		Msg("Starting consumer for partition")
 
-			if err := handler(c.ctx, msg); err != nil {
-				c.logger.Error().Err(err).Str("topic", topic).Msg("Handler failed")
+	for {
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
 			}
+			c.logger.Error().Err(err).Str("topic", topic).Int("partition", assignment.ID).Msg("Failed to fetch message")
+			time.Sleep(1 * time.Second)
+			continue
+		}
 
-			if err := reader.CommitMessages(c.ctx, msg); err != nil {
-				c.logger.Error().Err(err).Str("topic", topic).Msg("Failed to commit message")
-			}
+		c.logger.Debug().
+			Str("topic", topic).
+			Int("partition", msg.Partition).
+			Int64("offset", msg.Offset).
+			Msg("Received message")
+
+		c.metrics.SetKafkaLag(topic, msg.Partition, msg.HighWaterMark-msg.Offset-1)
+
+		if !pool.Dispatch(ctx, delivery{msg: msg, gen: gen}) {
+			return
 		}
 	}
 }
 
+func (c *Consumer) handleMessage(topic string, msg kafka.Message) {
+	handler, ok := c.handlers[topic]
+	if !ok {
+		c.logger.Warn().Str("topic", topic).Msg("No handler registered for topic")
+		c.metrics.IncKafkaMessage(topic, "unhandled")
+		return
+	}
+
+	ctx := tracing.Extract(c.ctx, tracing.KafkaHeaders{Headers: &msg.Headers})
+	ctx, span := tracing.Tracer().Start(ctx, topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationTypeProcess,
+			semconv.MessagingDestinationName(topic),
+			semconv.MessagingDestinationPartitionID(strconv.Itoa(msg.Partition)),
+			semconv.MessagingKafkaOffset(int(msg.Offset)),
+			semconv.MessagingKafkaMessageKey(string(msg.Key)),
+		),
+	)
+	defer span.End()
+
+	if c.schemas != nil {
+		if err := c.validate(topic, msg); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "schema validation failed")
+			c.metrics.IncKafkaMessage(topic, "rejected")
+			return
+		}
+	}
+
+	if err := handler(ctx, msg); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "handler failed")
+		c.logger.Error().Err(err).Str("topic", topic).Msg("Handler failed")
+		c.metrics.IncKafkaMessage(topic, "error")
+		return
+	}
+	c.metrics.IncKafkaMessage(topic, "success")
+}
+
+func (c *Consumer) validate(topic string, msg kafka.Message) error {
+	version := c.schemaOpts.DefaultVersion
+	for _, header := range msg.Headers {
+		if header.Key == events.SchemaVersionHeader {
+			version = string(header.Value)
+			break
+		}
+	}
+
+	err := c.schemas.Validate(topic, version, msg.Value)
+	if err == nil {
+		return nil
+	}
+
+	reason := events.ReasonNoSchema
+	var validationErr *events.ValidationError
+	switch {
+	case errors.Is(err, events.ErrNoSchema):
+		if !c.schemaOpts.RequireSchema {
+			return nil
+		}
+	case errors.As(err, &validationErr):
+		reason = validationErr.Reason
+	}
+
+	c.logger.Warn().
+		Err(err).
+		Str("topic", topic).
+		Str("schemaVersion", version).
+		Int("partition", msg.Partition).
+		Int64("offset", msg.Offset).
+		Msg("Rejected event failing schema validation")
+	c.metrics.IncKafkaRejected(topic, reason)
+	return err
+}
+
 func (c *Consumer) Stop() error {
 	c.cancel()
 
-	var lastErr error
-	for _, reader := range c.readers {
-		if err := reader.Close(); err != nil {
-			lastErr = err
-			c.logger.Error().Err(err).Msg("Failed to close reader")
+	var err error
+	if c.group != nil {
+		if err = c.group.Close(); err != nil {
+			c.logger.Error().Err(err).Msg("Failed to close consumer group")
 		}
 	}
+	c.wg.Wait()
+
+	for _, pool := range c.pools {
+		pool.Close()
+	}
 
 	c.logger.Info().Msg("Kafka consumer stopped")
-	return lastErr
+	return err
 }
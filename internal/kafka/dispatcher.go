@@ -0,0 +1,346 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+)
+
+// KeyFunc returns the ordering key for a message. Messages that share a key
+// are always handled by the same worker, in the order they were fetched.
+type KeyFunc func(msg kafka.Message) string
+
+// orderingFields are the payload fields consulted, in priority order, when a
+// message carries no Kafka key.
+var orderingFields = []string{"submissionId", "contestId", "userId"}
+
+// DefaultKeyFunc uses the Kafka message key when present and otherwise falls
+// back to the submission, contest or user ID found in the JSON payload.
+func DefaultKeyFunc(msg kafka.Message) string {
+	if len(msg.Key) > 0 {
+		return string(msg.Key)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Value, &fields); err != nil {
+		return ""
+	}
+
+	for _, name := range orderingFields {
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err == nil && value != "" {
+			return name + ":" + value
+		}
+	}
+	return ""
+}
+
+func workerIndex(key string, workers int) int {
+	if workers <= 1 || key == "" {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(workers))
+}
+
+// delivery is a fetched message together with the consumer group generation
+// it was fetched under, which is the only generation allowed to commit it.
+type delivery struct {
+	msg kafka.Message
+	gen *kafka.Generation
+}
+
+// topicPool runs the workers for a single topic. Messages with the same key
+// always land on the same worker so per-key ordering is kept, while at most
+// cap(inFlight) messages are outstanding at once.
+type topicPool struct {
+	topic    string
+	handle   func(kafka.Message)
+	keyFunc  KeyFunc
+	queues   []chan delivery
+	inFlight chan struct{}
+	tracker  *offsetTracker
+	workers  sync.WaitGroup
+	stop     chan struct{}
+	flushed  chan struct{}
+	logger   zerolog.Logger
+}
+
+func newTopicPool(topic string, workers, maxInFlight int, commitInterval time.Duration, keyFunc KeyFunc, handle func(kafka.Message), logger zerolog.Logger) *topicPool {
+	p := &topicPool{
+		topic:    topic,
+		handle:   handle,
+		keyFunc:  keyFunc,
+		queues:   make([]chan delivery, workers),
+		inFlight: make(chan struct{}, maxInFlight),
+		tracker:  newOffsetTracker(),
+		stop:     make(chan struct{}),
+		flushed:  make(chan struct{}),
+		logger:   logger,
+	}
+
+	for i := range p.queues {
+		p.queues[i] = make(chan delivery, maxInFlight)
+		p.workers.Add(1)
+		go p.work(p.queues[i], commitInterval == 0)
+	}
+
+	go p.flushLoop(commitInterval)
+	return p
+}
+
+// Dispatch queues d for handling, blocking while the pool is at capacity. It
+// returns false if ctx ends before a slot frees up.
+func (p *topicPool) Dispatch(ctx context.Context, d delivery) bool {
+	select {
+	case p.inFlight <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+
+	p.tracker.Track(d)
+	p.queues[workerIndex(p.keyFunc(d.msg), len(p.queues))] <- d
+	return true
+}
+
+func (p *topicPool) work(queue <-chan delivery, syncCommit bool) {
+	defer p.workers.Done()
+	for d := range queue {
+		p.handle(d.msg)
+		<-p.inFlight
+		p.tracker.Complete(d)
+		if syncCommit {
+			p.Flush()
+		}
+	}
+}
+
+func (p *topicPool) flushLoop(interval time.Duration) {
+	defer close(p.flushed)
+	if interval <= 0 {
+		<-p.stop
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.Flush()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Revoke waits up to timeout for the messages fetched under gen to finish,
+// commits everything that is committable through gen and then forgets gen,
+// so messages of it finishing later are not committed through an ended
+// generation.
+func (p *topicPool) Revoke(gen *kafka.Generation, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-p.tracker.Idle(gen):
+	case <-timer.C:
+	}
+	p.Flush()
+	p.tracker.Release(gen)
+}
+
+func (p *topicPool) Flush() {
+	p.tracker.Flush(func(gen *kafka.Generation, partition int, offset int64) error {
+		err := gen.CommitOffsets(map[string]map[int]int64{
+			p.topic: {partition: offset},
+		})
+		if err != nil {
+			p.logger.Error().
+				Err(err).
+				Str("topic", p.topic).
+				Int("partition", partition).
+				Int64("offset", offset).
+				Msg("Failed to commit offset")
+		}
+		return err
+	})
+}
+
+// Close stops the workers. The final commit was made by Revoke when the
+// last generation ended.
+func (p *topicPool) Close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.workers.Wait()
+	close(p.stop)
+	<-p.flushed
+}
+
+// offsetTracker records fetched offsets per partition so that a commit is
+// only issued once every earlier message in the same partition has finished.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[int]*partitionOffsets
+	// idle holds the channels returned by Idle, closed once their
+	// generation has nothing pending.
+	idle map[*kafka.Generation][]chan struct{}
+}
+
+type partitionOffsets struct {
+	gen     *kafka.Generation
+	pending []int64
+	done    map[int64]bool
+	// commit is the next offset to commit, or -1 before any message has
+	// finished.
+	commit int64
+
+	// committed is the last offset committed through gen. It is guarded by
+	// commitMu, which serializes the commits of the partition without
+	// holding up the tracker.
+	committed int64
+	commitMu  sync.Mutex
+}
+
+type pendingCommit struct {
+	p         *partitionOffsets
+	partition int
+	offset    int64
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		partitions: make(map[int]*partitionOffsets),
+		idle:       make(map[*kafka.Generation][]chan struct{}),
+	}
+}
+
+func (t *offsetTracker) Track(d delivery) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	replaced, ok := t.partitions[d.msg.Partition]
+	p := replaced
+	if !ok || p.gen != d.gen {
+		p = &partitionOffsets{gen: d.gen, done: make(map[int64]bool), commit: -1, committed: -1}
+		t.partitions[d.msg.Partition] = p
+		// The replaced generation's messages of this partition will never
+		// count as finished.
+		if ok {
+			t.wakeIfIdle(replaced.gen)
+		}
+	}
+	p.pending = append(p.pending, d.msg.Offset)
+}
+
+// Complete marks d as handled and advances the partition's commit point past
+// every leading message that has finished.
+func (t *offsetTracker) Complete(d delivery) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[d.msg.Partition]
+	if !ok || p.gen != d.gen {
+		return
+	}
+	p.done[d.msg.Offset] = true
+
+	for len(p.pending) > 0 && p.done[p.pending[0]] {
+		delete(p.done, p.pending[0])
+		p.commit = p.pending[0] + 1
+		p.pending = p.pending[1:]
+	}
+	if len(p.pending) == 0 {
+		t.wakeIfIdle(d.gen)
+	}
+}
+
+// pending reports whether a message fetched under gen has not finished yet.
+// It must be called with mu held.
+func (t *offsetTracker) pending(gen *kafka.Generation) bool {
+	for _, p := range t.partitions {
+		if p.gen == gen && len(p.pending) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// wakeIfIdle closes the channels waiting for gen once nothing of it is
+// pending. It must be called with mu held.
+func (t *offsetTracker) wakeIfIdle(gen *kafka.Generation) {
+	if !t.pending(gen) {
+		t.wake(gen)
+	}
+}
+
+// wake closes the channels waiting for gen. It must be called with mu held.
+func (t *offsetTracker) wake(gen *kafka.Generation) {
+	for _, ch := range t.idle[gen] {
+		close(ch)
+	}
+	delete(t.idle, gen)
+}
+
+// Idle returns a channel that is closed once every message fetched under
+// gen has finished.
+func (t *offsetTracker) Idle(gen *kafka.Generation) <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ch := make(chan struct{})
+	if !t.pending(gen) {
+		close(ch)
+		return ch
+	}
+	t.idle[gen] = append(t.idle[gen], ch)
+	return ch
+}
+
+// Release forgets the partitions tracked for gen.
+func (t *offsetTracker) Release(gen *kafka.Generation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for partition, p := range t.partitions {
+		if p.gen == gen {
+			delete(t.partitions, partition)
+		}
+	}
+	t.wake(gen)
+}
+
+// Flush hands every commit point that has not been committed yet to
+// commit. The points are collected under the lock and committed after it
+// is released, so handlers never wait for a commit round trip; a point is
+// skipped if a later one of its partition was committed meanwhile, and a
+// failed commit is retried by the next Flush.
+func (t *offsetTracker) Flush(commit func(gen *kafka.Generation, partition int, offset int64) error) {
+	t.mu.Lock()
+	var points []pendingCommit
+	for partition, p := range t.partitions {
+		if p.commit >= 0 {
+			points = append(points, pendingCommit{p: p, partition: partition, offset: p.commit})
+		}
+	}
+	t.mu.Unlock()
+
+	for _, point := range points {
+		p := point.p
+		p.commitMu.Lock()
+		if point.offset > p.committed && commit(p.gen, point.partition, point.offset) == nil {
+			p.committed = point.offset
+		}
+		p.commitMu.Unlock()
+	}
+}
@@ -0,0 +1,236 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestDefaultKeyFunc(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  kafka.Message
+		want string
+	}{
+		{"kafka key wins", kafka.Message{Key: []byte("k"), Value: []byte(`{"userId":"u1"}`)}, "k"},
+		{"submission before contest", kafka.Message{Value: []byte(`{"contestId":"c1","submissionId":"s1"}`)}, "submissionId:s1"},
+		{"contest before user", kafka.Message{Value: []byte(`{"userId":"u1","contestId":"c1"}`)}, "contestId:c1"},
+		{"user", kafka.Message{Value: []byte(`{"userId":"u1"}`)}, "userId:u1"},
+		{"empty value skipped", kafka.Message{Value: []byte(`{"submissionId":"","userId":"u1"}`)}, "userId:u1"},
+		{"non-string skipped", kafka.Message{Value: []byte(`{"contestId":42,"userId":"u1"}`)}, "userId:u1"},
+		{"no ordering field", kafka.Message{Value: []byte(`{"other":"x"}`)}, ""},
+		{"not JSON", kafka.Message{Value: []byte(`nope`)}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DefaultKeyFunc(tt.msg); got != tt.want {
+				t.Errorf("DefaultKeyFunc() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkerIndex(t *testing.T) {
+	if got := workerIndex("", 8); got != 0 {
+		t.Errorf("empty key went to worker %d, want 0", got)
+	}
+	if got := workerIndex("contestId:c1", 1); got != 0 {
+		t.Errorf("single worker index = %d, want 0", got)
+	}
+	for _, key := range []string{"a", "userId:u1", "submissionId:s1"} {
+		first := workerIndex(key, 8)
+		if first < 0 || first >= 8 {
+			t.Fatalf("workerIndex(%q) = %d, out of range", key, first)
+		}
+		if again := workerIndex(key, 8); again != first {
+			t.Errorf("workerIndex(%q) moved from %d to %d", key, first, again)
+		}
+	}
+}
+
+type commitPoint struct {
+	gen       *kafka.Generation
+	partition int
+	offset    int64
+}
+
+func flushAll(tracker *offsetTracker) []commitPoint {
+	var commits []commitPoint
+	tracker.Flush(func(gen *kafka.Generation, partition int, offset int64) error {
+		commits = append(commits, commitPoint{gen, partition, offset})
+		return nil
+	})
+	return commits
+}
+
+func TestOffsetTracker(t *testing.T) {
+	gen := &kafka.Generation{ID: 1}
+
+	tests := []struct {
+		name      string
+		tracked   []int64
+		completed []int64
+		// want is the committed offset, or -1 for no commit.
+		want int64
+	}{
+		{"nothing completed", []int64{10, 11, 12}, nil, -1},
+		{"in order", []int64{10, 11, 12}, []int64{10, 11}, 12},
+		{"all completed", []int64{10, 11, 12}, []int64{10, 11, 12}, 13},
+		{"gap holds the commit", []int64{10, 11, 12}, []int64{11, 12}, -1},
+		{"gap filled", []int64{10, 11, 12}, []int64{12, 11, 10}, 13},
+		{"partial after gap", []int64{10, 11, 12, 13}, []int64{10, 12, 13}, 11},
+		{"sparse offsets", []int64{5, 9, 20}, []int64{5, 9}, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newOffsetTracker()
+			for _, offset := range tt.tracked {
+				tracker.Track(delivery{msg: kafka.Message{Partition: 3, Offset: offset}, gen: gen})
+			}
+			for _, offset := range tt.completed {
+				tracker.Complete(delivery{msg: kafka.Message{Partition: 3, Offset: offset}, gen: gen})
+			}
+
+			commits := flushAll(tracker)
+			if tt.want < 0 {
+				if len(commits) != 0 {
+					t.Fatalf("commits = %v, want none", commits)
+				}
+				return
+			}
+			if len(commits) != 1 || commits[0] != (commitPoint{gen, 3, tt.want}) {
+				t.Fatalf("commits = %v, want offset %d on partition 3", commits, tt.want)
+			}
+			if again := flushAll(tracker); len(again) != 0 {
+				t.Errorf("second flush committed %v again", again)
+			}
+		})
+	}
+}
+
+func TestOffsetTrackerPartitionsAreIndependent(t *testing.T) {
+	gen := &kafka.Generation{ID: 1}
+	tracker := newOffsetTracker()
+
+	tracker.Track(delivery{msg: kafka.Message{Partition: 0, Offset: 1}, gen: gen})
+	tracker.Track(delivery{msg: kafka.Message{Partition: 1, Offset: 1}, gen: gen})
+	tracker.Complete(delivery{msg: kafka.Message{Partition: 1, Offset: 1}, gen: gen})
+
+	commits := flushAll(tracker)
+	if len(commits) != 1 || commits[0] != (commitPoint{gen, 1, 2}) {
+		t.Fatalf("commits = %v, want offset 2 on partition 1 only", commits)
+	}
+}
+
+func TestOffsetTrackerGenerations(t *testing.T) {
+	oldGen := &kafka.Generation{ID: 1}
+	newGen := &kafka.Generation{ID: 2}
+	tracker := newOffsetTracker()
+
+	tracker.Track(delivery{msg: kafka.Message{Partition: 0, Offset: 1}, gen: oldGen})
+	tracker.Track(delivery{msg: kafka.Message{Partition: 0, Offset: 2}, gen: newGen})
+
+	// A message of the previous generation finishing late must not commit.
+	tracker.Complete(delivery{msg: kafka.Message{Partition: 0, Offset: 1}, gen: oldGen})
+	if commits := flushAll(tracker); len(commits) != 0 {
+		t.Fatalf("commits = %v, want none for the replaced generation", commits)
+	}
+
+	tracker.Complete(delivery{msg: kafka.Message{Partition: 0, Offset: 2}, gen: newGen})
+	if commits := flushAll(tracker); len(commits) != 1 || commits[0] != (commitPoint{newGen, 0, 3}) {
+		t.Fatalf("commits = %v, want offset 3 through the new generation", commits)
+	}
+}
+
+func TestOffsetTrackerRelease(t *testing.T) {
+	gen := &kafka.Generation{ID: 1}
+	other := &kafka.Generation{ID: 2}
+	tracker := newOffsetTracker()
+
+	tracker.Track(delivery{msg: kafka.Message{Partition: 0, Offset: 1}, gen: gen})
+	tracker.Track(delivery{msg: kafka.Message{Partition: 1, Offset: 1}, gen: other})
+	tracker.Release(gen)
+
+	tracker.Complete(delivery{msg: kafka.Message{Partition: 0, Offset: 1}, gen: gen})
+	tracker.Complete(delivery{msg: kafka.Message{Partition: 1, Offset: 1}, gen: other})
+
+	commits := flushAll(tracker)
+	if len(commits) != 1 || commits[0] != (commitPoint{other, 1, 2}) {
+		t.Fatalf("commits = %v, want only the unreleased generation", commits)
+	}
+}
+
+func TestOffsetTrackerRetriesFailedCommits(t *testing.T) {
+	gen := &kafka.Generation{ID: 1}
+	tracker := newOffsetTracker()
+
+	tracker.Track(delivery{msg: kafka.Message{Partition: 0, Offset: 1}, gen: gen})
+	tracker.Complete(delivery{msg: kafka.Message{Partition: 0, Offset: 1}, gen: gen})
+
+	attempts := 0
+	tracker.Flush(func(gen *kafka.Generation, partition int, offset int64) error {
+		attempts++
+		return errors.New("coordinator unavailable")
+	})
+	if attempts != 1 {
+		t.Fatalf("attempts = %d, want 1", attempts)
+	}
+
+	if commits := flushAll(tracker); len(commits) != 1 || commits[0] != (commitPoint{gen, 0, 2}) {
+		t.Fatalf("commits = %v, want the failed offset 2 again", commits)
+	}
+	if commits := flushAll(tracker); len(commits) != 0 {
+		t.Errorf("commits = %v, want none once committed", commits)
+	}
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestOffsetTrackerIdle(t *testing.T) {
+	gen := &kafka.Generation{ID: 1}
+	other := &kafka.Generation{ID: 2}
+	tracker := newOffsetTracker()
+
+	if !isClosed(tracker.Idle(gen)) {
+		t.Fatal("generation without messages is not idle")
+	}
+
+	tracker.Track(delivery{msg: kafka.Message{Partition: 0, Offset: 1}, gen: gen})
+	tracker.Track(delivery{msg: kafka.Message{Partition: 1, Offset: 1}, gen: gen})
+	tracker.Track(delivery{msg: kafka.Message{Partition: 2, Offset: 1}, gen: other})
+	idle := tracker.Idle(gen)
+
+	tracker.Complete(delivery{msg: kafka.Message{Partition: 0, Offset: 1}, gen: gen})
+	if isClosed(idle) {
+		t.Fatal("idle while partition 1 is still pending")
+	}
+	tracker.Complete(delivery{msg: kafka.Message{Partition: 1, Offset: 1}, gen: gen})
+	if !isClosed(idle) {
+		t.Fatal("not idle after every message finished")
+	}
+	if isClosed(tracker.Idle(other)) {
+		t.Error("other generation idle with a pending message")
+	}
+}
+
+func TestOffsetTrackerIdleOnRelease(t *testing.T) {
+	gen := &kafka.Generation{ID: 1}
+	tracker := newOffsetTracker()
+
+	tracker.Track(delivery{msg: kafka.Message{Partition: 0, Offset: 1}, gen: gen})
+	idle := tracker.Idle(gen)
+	tracker.Release(gen)
+	if !isClosed(idle) {
+		t.Error("released generation still waited on")
+	}
+}
@@ -3,17 +3,31 @@ package kafka
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"time"
 
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/auth"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/contest"
 	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/hub"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/presence"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/revocation"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/routing"
 	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/events"
 	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
 	"github.com/rs/zerolog"
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Handlers struct {
-	hub    *hub.Hub
-	logger zerolog.Logger
+	hub         *hub.Hub
+	router      *routing.Router
+	revocations *revocation.Store
+	exclusive   *presence.Exclusivity
+	registry    *contest.Registry
+	teams       *contest.Teams
+	board       *contest.Board
+	logger      zerolog.Logger
 }
 
 func NewHandlers(h *hub.Hub, logger zerolog.Logger) *Handlers {
@@ -23,6 +37,195 @@ func NewHandlers(h *hub.Hub, logger zerolog.Logger) *Handlers {
 	}
 }
 
+// newEventMessage builds the socket message for a Kafka event and stamps it
+// with the event's Kafka timestamp so delivery latency can be measured, and
+// with the processing span so the socket write joins the event's trace.
+func newEventMessage(ctx context.Context, source kafka.Message, msgType protocol.MessageType, payload interface{}) (*protocol.Message, error) {
+	msg, err := protocol.NewMessage(msgType, payload)
+	if err != nil {
+		return nil, err
+	}
+	msg.EventTime = source.Time
+	msg.SpanContext = trace.SpanContextFromContext(ctx)
+	return msg, nil
+}
+
+// SetRouter enables the declarative routing table. A route replaces the
+// delivery of the built-in handler of its topic; whatever else the handler
+// records still happens. It must be called before RegisterAll.
+func (h *Handlers) SetRouter(router *routing.Router) {
+	h.router = router
+	router.SetResolver(routeResolver{h: h})
+}
+
+// eventSender is the part of the hub built-in handlers deliver through.
+type eventSender interface {
+	SendToUser(userID string, msg *protocol.Message)
+	SendToRoom(roomID string, msg *protocol.Message)
+	SendToRoomWithPermission(roomID string, perm auth.Permission, msg *protocol.Message)
+	SendToAudience(rooms, users []string, msg *protocol.Message)
+	Broadcast(msg *protocol.Message)
+}
+
+// discardSender drops the messages of built-in handlers whose topic is
+// routed.
+type discardSender struct{}
+
+func (discardSender) SendToUser(string, *protocol.Message)                                {}
+func (discardSender) SendToRoom(string, *protocol.Message)                                {}
+func (discardSender) SendToRoomWithPermission(string, auth.Permission, *protocol.Message) {}
+func (discardSender) SendToAudience([]string, []string, *protocol.Message)                {}
+func (discardSender) Broadcast(*protocol.Message)                                         {}
+
+type routedKey struct{}
+
+// sender returns where a built-in handler delivers: the hub, or nowhere
+// when a route delivers the event instead.
+func (h *Handlers) sender(ctx context.Context) eventSender {
+	if routed, _ := ctx.Value(routedKey{}).(bool); routed {
+		return discardSender{}
+	}
+	return h.hub
+}
+
+// SetRevocations enables the user.session.revoked handler.
+func (h *Handlers) SetRevocations(store *revocation.Store) {
+	h.revocations = store
+}
+
+// SetExclusivity makes proctored contests single-connection rooms.
+func (h *Handlers) SetExclusivity(exclusive *presence.Exclusivity) {
+	h.exclusive = exclusive
+}
+
+// SetRegistry records contest lifecycle, problems and participants from
+// contest events, which also schedules the contest timers, and uses the
+// recorded phase to filter frozen leaderboards.
+func (h *Handlers) SetRegistry(registry *contest.Registry) {
+	h.registry = registry
+}
+
+// SetTeams enables contest.team.registered and routes the submissions of
+// team members to their team room.
+func (h *Handlers) SetTeams(teams *contest.Teams) {
+	h.teams = teams
+}
+
+// SetBoard stores announcements and clarification answers for history and
+// unread counts.
+func (h *Handlers) SetBoard(board *contest.Board) {
+	h.board = board
+}
+
+// parseEventTime parses an RFC 3339 event time, returning the zero time
+// for empty or malformed values.
+func parseEventTime(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// announceContest delivers a contest lifecycle message to every client for
+// public contests. Other contests only reach their contest and staff rooms,
+// their organization's room and users, and each client receives the
+// message once however many of those it matches.
+func (h *Handlers) announceContest(ctx context.Context, contestID, visibility, orgID string, users []string, msg *protocol.Message) {
+	if contest.IsPublic(visibility) {
+		h.sender(ctx).Broadcast(msg)
+		return
+	}
+	h.sender(ctx).SendToAudience(contestRooms(contestID, orgID), users, msg)
+}
+
+func contestRooms(contestID, orgID string) []string {
+	rooms := []string{
+		hub.BuildRoomID(hub.RoomTypeContest, contestID),
+		hub.BuildRoomID(hub.RoomTypeStaff, contestID),
+	}
+	if orgID != "" {
+		rooms = append(rooms, hub.BuildRoomID(hub.RoomTypeOrg, orgID))
+	}
+	return rooms
+}
+
+// contestAudience returns the visibility, organization and users of a
+// contest as recorded from contest.created. Contests the registry does not
+// know are treated as public.
+func (h *Handlers) contestAudience(ctx context.Context, contestID string) (visibility, orgID string, users []string) {
+	if h.registry == nil {
+		return "", "", nil
+	}
+	state, ok := h.registry.Get(contestID)
+	if !ok || contest.IsPublic(state.Visibility) {
+		return "", "", nil
+	}
+
+	participants, err := h.registry.Participants(ctx, contestID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("contestId", contestID).Msg("Failed to list contest participants")
+	}
+	invited, err := h.registry.Invited(ctx, contestID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("contestId", contestID).Msg("Failed to list invited users")
+	}
+	return state.Visibility, state.OrganizationID, append(participants, invited...)
+}
+
+// routeResolver answers the contest lookups of the routing table.
+type routeResolver struct {
+	h *Handlers
+}
+
+func (r routeResolver) TeamRoom(ctx context.Context, contestID, userID string) (string, error) {
+	if r.h.teams == nil {
+		return "", nil
+	}
+	teamID, err := r.h.teams.TeamOf(ctx, contestID, userID)
+	if err != nil || teamID == "" {
+		return "", err
+	}
+	return hub.TeamRoomID(contestID, teamID), nil
+}
+
+func (r routeResolver) ContestAudience(ctx context.Context, contestID string) (bool, []string, []string) {
+	visibility, orgID, users := r.h.contestAudience(ctx, contestID)
+	if contest.IsPublic(visibility) {
+		return true, nil, nil
+	}
+	return false, contestRooms(contestID, orgID), users
+}
+
+func (r routeResolver) Frozen(contestID string) bool {
+	return r.h.registry != nil && r.h.registry.IsFrozen(contestID)
+}
+
+func (h *Handlers) recordContest(contestID string, err error) {
+	if err != nil {
+		h.logger.Error().Err(err).Str("contestId", contestID).Msg("Failed to update contest registry")
+	}
+}
+
+// submissionRooms returns the contest room and, for team members, the team
+// room that a submission of userID is sent to.
+func (h *Handlers) submissionRooms(ctx context.Context, contestID *string, userID string) []string {
+	if contestID == nil || *contestID == "" {
+		return nil
+	}
+
+	rooms := []string{hub.BuildRoomID(hub.RoomTypeContest, *contestID)}
+	if h.teams != nil {
+		teamID, err := h.teams.TeamOf(ctx, *contestID, userID)
+		if err != nil {
+			h.logger.Error().Err(err).Str("contestId", *contestID).Str("userId", userID).Msg("Failed to look up team")
+		} else if teamID != "" {
+			rooms = append(rooms, hub.TeamRoomID(*contestID, teamID))
+		}
+	}
+	return rooms
+}
+
 func (h *Handlers) HandleSubmissionCreated(ctx context.Context, msg kafka.Message) error {
 	var event events.SubmissionCreatedEvent
 	if err := json.Unmarshal(msg.Value, &event); err != nil {
@@ -36,17 +239,12 @@ func (h *Handlers) HandleSubmissionCreated(ctx context.Context, msg kafka.Messag
 		Str("status", event.Status).
 		Msg("Processing submission.created")
 
-	wsMsg, err := protocol.NewMessage(protocol.MsgSubmissionCreated, event)
+	wsMsg, err := newEventMessage(ctx, msg, protocol.MsgSubmissionCreated, event)
 	if err != nil {
 		return err
 	}
 
-	h.hub.SendToUser(event.UserID, wsMsg)
-
-	if event.ContestID != nil && *event.ContestID != "" {
-		roomID := hub.BuildRoomID(hub.RoomTypeContest, *event.ContestID)
-		h.hub.SendToRoom(roomID, wsMsg)
-	}
+	h.sender(ctx).SendToAudience(h.submissionRooms(ctx, event.ContestID, event.UserID), []string{event.UserID}, wsMsg)
 
 	return nil
 }
@@ -64,17 +262,12 @@ func (h *Handlers) HandleSubmissionJudged(ctx context.Context, msg kafka.Message
 		Str("verdict", event.Verdict).
 		Msg("Processing submission.judged")
 
-	wsMsg, err := protocol.NewMessage(protocol.MsgSubmissionResult, event)
+	wsMsg, err := newEventMessage(ctx, msg, protocol.MsgSubmissionResult, event)
 	if err != nil {
 		return err
 	}
 
-	h.hub.SendToUser(event.UserID, wsMsg)
-
-	if event.ContestID != nil && *event.ContestID != "" {
-		roomID := hub.BuildRoomID(hub.RoomTypeContest, *event.ContestID)
-		h.hub.SendToRoom(roomID, wsMsg)
-	}
+	h.sender(ctx).SendToAudience(h.submissionRooms(ctx, event.ContestID, event.UserID), []string{event.UserID}, wsMsg)
 
 	return nil
 }
@@ -90,13 +283,17 @@ func (h *Handlers) HandleLeaderboardUpdated(ctx context.Context, msg kafka.Messa
 		Str("contestId", event.ContestID).
 		Msg("Processing leaderboard.updated")
 
-	wsMsg, err := protocol.NewMessage(protocol.MsgLeaderboardUpdate, event)
+	wsMsg, err := newEventMessage(ctx, msg, protocol.MsgLeaderboardUpdate, event)
 	if err != nil {
 		return err
 	}
 
 	roomID := hub.BuildRoomID(hub.RoomTypeContest, event.ContestID)
-	h.hub.SendToRoom(roomID, wsMsg)
+	if event.Frozen || (h.registry != nil && h.registry.IsFrozen(event.ContestID)) {
+		h.sender(ctx).SendToRoomWithPermission(roomID, auth.PermViewFrozenResults, wsMsg)
+		return nil
+	}
+	h.sender(ctx).SendToRoom(roomID, wsMsg)
 
 	return nil
 }
@@ -113,7 +310,14 @@ func (h *Handlers) HandleContestStarted(ctx context.Context, msg kafka.Message)
 		Str("title", event.Title).
 		Msg("Processing contest.started")
 
-	wsMsg, err := protocol.NewMessage(protocol.MsgContestEvent, map[string]interface{}{
+	visibility, orgID, users := h.contestAudience(ctx, event.ContestID)
+
+	if h.registry != nil {
+		h.recordContest(event.ContestID, h.registry.Started(ctx, event.ContestID,
+			parseEventTime(event.StartTime), parseEventTime(event.EndTime)))
+	}
+
+	wsMsg, err := newEventMessage(ctx, msg, protocol.MsgContestEvent, map[string]interface{}{
 		"type":      "STARTED",
 		"contestId": event.ContestID,
 		"title":     event.Title,
@@ -124,10 +328,8 @@ func (h *Handlers) HandleContestStarted(ctx context.Context, msg kafka.Message)
 		return err
 	}
 
-	roomID := hub.BuildRoomID(hub.RoomTypeContest, event.ContestID)
-	h.hub.SendToRoom(roomID, wsMsg)
-
-	h.hub.Broadcast(wsMsg)
+	h.hub.TrackRoomMetrics(hub.BuildRoomID(hub.RoomTypeContest, event.ContestID))
+	h.announceContest(ctx, event.ContestID, visibility, orgID, users, wsMsg)
 
 	return nil
 }
@@ -144,7 +346,7 @@ func (h *Handlers) HandleContestEnded(ctx context.Context, msg kafka.Message) er
 		Str("title", event.Title).
 		Msg("Processing contest.ended")
 
-	wsMsg, err := protocol.NewMessage(protocol.MsgContestEvent, map[string]interface{}{
+	wsMsg, err := newEventMessage(ctx, msg, protocol.MsgContestEvent, map[string]interface{}{
 		"type":      "ENDED",
 		"contestId": event.ContestID,
 		"title":     event.Title,
@@ -156,7 +358,12 @@ func (h *Handlers) HandleContestEnded(ctx context.Context, msg kafka.Message) er
 	}
 
 	roomID := hub.BuildRoomID(hub.RoomTypeContest, event.ContestID)
-	h.hub.SendToRoom(roomID, wsMsg)
+	h.sender(ctx).SendToRoom(roomID, wsMsg)
+	h.hub.UntrackRoomMetrics(roomID)
+
+	if h.registry != nil {
+		h.recordContest(event.ContestID, h.registry.Ended(ctx, event.ContestID, parseEventTime(event.EndTime)))
+	}
 
 	return nil
 }
@@ -171,9 +378,43 @@ func (h *Handlers) HandleContestCreated(ctx context.Context, msg kafka.Message)
 	h.logger.Info().
 		Str("contestId", event.ContestID).
 		Str("title", event.Title).
+		Str("visibility", event.Visibility).
+		Bool("proctored", event.Proctored).
 		Msg("Processing contest.created")
 
-	wsMsg, err := protocol.NewMessage(protocol.MsgContestEvent, map[string]interface{}{
+	startTime := parseEventTime(event.StartTime)
+	endTime := parseEventTime(event.EndTime)
+	freezeTime := parseEventTime(event.FreezeTime)
+	if h.registry != nil {
+		h.recordContest(event.ContestID, h.registry.Created(ctx, contest.Info{
+			ContestID:      event.ContestID,
+			Title:          event.Title,
+			Slug:           event.Slug,
+			Visibility:     event.Visibility,
+			OrganizationID: event.OrganizationID,
+			InvitedUserIDs: event.InvitedUserIDs,
+			ScoringMode:    event.ScoringMode,
+			StartTime:      startTime,
+			EndTime:        endTime,
+			FreezeTime:     freezeTime,
+			Proctored:      event.Proctored,
+		}))
+	}
+
+	if event.Proctored && h.exclusive != nil {
+		// Keep the mark until an hour after the contest ends, or for the
+		// default lifetime if the end time is unknown.
+		var ttl time.Duration
+		if !endTime.IsZero() {
+			ttl = time.Until(endTime) + time.Hour
+		}
+		roomID := hub.BuildRoomID(hub.RoomTypeContest, event.ContestID)
+		if err := h.exclusive.Mark(ctx, roomID, true, ttl); err != nil {
+			return err
+		}
+	}
+
+	wsMsg, err := newEventMessage(ctx, msg, protocol.MsgContestEvent, map[string]interface{}{
 		"type":        "CREATED",
 		"contestId":   event.ContestID,
 		"title":       event.Title,
@@ -188,7 +429,7 @@ func (h *Handlers) HandleContestCreated(ctx context.Context, msg kafka.Message)
 		return err
 	}
 
-	h.hub.Broadcast(wsMsg)
+	h.announceContest(ctx, event.ContestID, event.Visibility, event.OrganizationID, event.InvitedUserIDs, wsMsg)
 
 	return nil
 }
@@ -205,7 +446,11 @@ func (h *Handlers) HandleParticipantRegistered(ctx context.Context, msg kafka.Me
 		Str("userId", event.UserID).
 		Msg("Processing participant.registered")
 
-	wsMsg, err := protocol.NewMessage(protocol.MsgParticipantEvent, map[string]interface{}{
+	if h.registry != nil {
+		h.recordContest(event.ContestID, h.registry.ParticipantRegistered(ctx, event.ContestID, event.UserID))
+	}
+
+	wsMsg, err := newEventMessage(ctx, msg, protocol.MsgParticipantEvent, map[string]interface{}{
 		"type":        "REGISTERED",
 		"contestId":   event.ContestID,
 		"userId":      event.UserID,
@@ -218,7 +463,259 @@ func (h *Handlers) HandleParticipantRegistered(ctx context.Context, msg kafka.Me
 	}
 
 	roomID := hub.BuildRoomID(hub.RoomTypeContest, event.ContestID)
-	h.hub.SendToRoom(roomID, wsMsg)
+	h.sender(ctx).SendToRoom(roomID, wsMsg)
+
+	return nil
+}
+
+func (h *Handlers) HandleParticipantUnregistered(ctx context.Context, msg kafka.Message) error {
+	var event events.ParticipantUnregisteredEvent
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to unmarshal participant.unregistered event")
+		return err
+	}
+
+	h.logger.Info().
+		Str("contestId", event.ContestID).
+		Str("userId", event.UserID).
+		Msg("Processing participant.unregistered")
+
+	if h.registry != nil {
+		h.recordContest(event.ContestID, h.registry.ParticipantUnregistered(ctx, event.ContestID, event.UserID))
+	}
+
+	wsMsg, err := newEventMessage(ctx, msg, protocol.MsgParticipantUnregistered, map[string]interface{}{
+		"contestId": event.ContestID,
+		"userId":    event.UserID,
+		"timestamp": event.Timestamp,
+	})
+	if err != nil {
+		return err
+	}
+
+	roomID := hub.BuildRoomID(hub.RoomTypeContest, event.ContestID)
+	h.sender(ctx).SendToRoom(roomID, wsMsg)
+	h.hub.RemoveUserFromRoom(event.UserID, roomID, "UNREGISTERED")
+
+	return nil
+}
+
+// HandleTeamRegistered records the members of a team and takes users who
+// left it out of its room.
+func (h *Handlers) HandleTeamRegistered(ctx context.Context, msg kafka.Message) error {
+	var event events.TeamRegisteredEvent
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to unmarshal contest.team.registered event")
+		return err
+	}
+
+	h.logger.Info().
+		Str("contestId", event.ContestID).
+		Str("teamId", event.TeamID).
+		Int("members", len(event.MemberIDs)).
+		Msg("Processing contest.team.registered")
+
+	if h.teams == nil {
+		return nil
+	}
+
+	removed, err := h.teams.Register(ctx, event.ContestID, event.TeamID, event.MemberIDs)
+	if err != nil {
+		return err
+	}
+
+	roomID := hub.TeamRoomID(event.ContestID, event.TeamID)
+	for _, userID := range removed {
+		h.hub.RemoveUserFromRoom(userID, roomID, "TEAM_CHANGED")
+	}
+
+	return nil
+}
+
+// HandleClarificationRequested shows a new question to the contest staff
+// and to the asking user's other connections.
+func (h *Handlers) HandleClarificationRequested(ctx context.Context, msg kafka.Message) error {
+	var event events.ClarificationRequestedEvent
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to unmarshal contest.clarification.requested event")
+		return err
+	}
+
+	h.logger.Info().
+		Str("contestId", event.ContestID).
+		Str("clarificationId", event.ClarificationID).
+		Str("userId", event.UserID).
+		Msg("Processing contest.clarification.requested")
+
+	wsMsg, err := newEventMessage(ctx, msg, protocol.MsgClarificationRequested, protocol.ClarificationPayload{
+		ClarificationID: event.ClarificationID,
+		ContestID:       event.ContestID,
+		UserID:          event.UserID,
+		ProblemID:       event.ProblemID,
+		Question:        event.Question,
+		Timestamp:       event.Timestamp,
+	})
+	if err != nil {
+		return err
+	}
+
+	staffRoom := hub.BuildRoomID(hub.RoomTypeStaff, event.ContestID)
+	h.sender(ctx).SendToAudience([]string{staffRoom}, []string{event.UserID}, wsMsg)
+
+	return nil
+}
+
+// HandleClarificationAnswered sends a public answer to the whole contest
+// and a private one to the asking user only; staff see both.
+func (h *Handlers) HandleClarificationAnswered(ctx context.Context, msg kafka.Message) error {
+	var event events.ClarificationAnsweredEvent
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to unmarshal contest.clarification.answered event")
+		return err
+	}
+
+	h.logger.Info().
+		Str("contestId", event.ContestID).
+		Str("clarificationId", event.ClarificationID).
+		Bool("public", event.Public).
+		Msg("Processing contest.clarification.answered")
+
+	if h.board != nil {
+		if err := h.board.Answered(ctx, event.ContestID, event.UserID, event.ClarificationID, event.Public); err != nil {
+			h.logger.Error().Err(err).Str("contestId", event.ContestID).Msg("Failed to record clarification answer")
+		}
+	}
+
+	payload := protocol.ClarificationPayload{
+		ClarificationID: event.ClarificationID,
+		ContestID:       event.ContestID,
+		ProblemID:       event.ProblemID,
+		Question:        event.Question,
+		Answer:          event.Answer,
+		Public:          event.Public,
+		Timestamp:       event.Timestamp,
+	}
+	rooms := []string{hub.BuildRoomID(hub.RoomTypeStaff, event.ContestID)}
+	if event.Public {
+		rooms = append(rooms, hub.BuildRoomID(hub.RoomTypeContest, event.ContestID))
+	} else {
+		payload.UserID = event.UserID
+	}
+
+	wsMsg, err := newEventMessage(ctx, msg, protocol.MsgClarificationAnswered, payload)
+	if err != nil {
+		return err
+	}
+	h.sender(ctx).SendToAudience(rooms, []string{event.UserID}, wsMsg)
+
+	return nil
+}
+
+// HandleAnnouncement stores the announcement for late joiners and sends it
+// to the contest.
+func (h *Handlers) HandleAnnouncement(ctx context.Context, msg kafka.Message) error {
+	var event events.AnnouncementEvent
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to unmarshal contest.announcement event")
+		return err
+	}
+
+	h.logger.Info().
+		Str("contestId", event.ContestID).
+		Str("announcementId", event.AnnouncementID).
+		Msg("Processing contest.announcement")
+
+	announcement := protocol.AnnouncementPayload{
+		AnnouncementID: event.AnnouncementID,
+		ContestID:      event.ContestID,
+		ProblemID:      event.ProblemID,
+		Title:          event.Title,
+		Body:           event.Body,
+		Timestamp:      event.Timestamp,
+	}
+	// The board stamps announcements whose event carries no time.
+	if createdAt := parseEventTime(event.Timestamp); !createdAt.IsZero() {
+		announcement.CreatedAt = createdAt.UnixMilli()
+	}
+	if h.board != nil {
+		if err := h.board.Announce(ctx, &announcement); err != nil {
+			h.logger.Error().Err(err).Str("contestId", event.ContestID).Msg("Failed to store announcement")
+		}
+	}
+
+	wsMsg, err := newEventMessage(ctx, msg, protocol.MsgContestAnnouncement, announcement)
+	if err != nil {
+		return err
+	}
+
+	h.sender(ctx).SendToAudience([]string{
+		hub.BuildRoomID(hub.RoomTypeContest, event.ContestID),
+		hub.BuildRoomID(hub.RoomTypeStaff, event.ContestID),
+	}, nil, wsMsg)
+
+	return nil
+}
+
+func (h *Handlers) HandleProblemAdded(ctx context.Context, msg kafka.Message) error {
+	var event events.ProblemAddedEvent
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to unmarshal contest.problem.added event")
+		return err
+	}
+
+	h.logger.Info().
+		Str("contestId", event.ContestID).
+		Str("problemId", event.ProblemID).
+		Str("label", event.Label).
+		Msg("Processing contest.problem.added")
+
+	if h.registry != nil {
+		h.recordContest(event.ContestID, h.registry.ProblemAdded(ctx, event.ContestID, event.ProblemID, event.Label))
+	}
+
+	wsMsg, err := newEventMessage(ctx, msg, protocol.MsgContestProblemAdded, map[string]interface{}{
+		"contestId": event.ContestID,
+		"problemId": event.ProblemID,
+		"label":     event.Label,
+		"timestamp": event.Timestamp,
+	})
+	if err != nil {
+		return err
+	}
+
+	roomID := hub.BuildRoomID(hub.RoomTypeContest, event.ContestID)
+	h.sender(ctx).SendToRoom(roomID, wsMsg)
+
+	return nil
+}
+
+func (h *Handlers) HandleProblemRemoved(ctx context.Context, msg kafka.Message) error {
+	var event events.ProblemRemovedEvent
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to unmarshal contest.problem.removed event")
+		return err
+	}
+
+	h.logger.Info().
+		Str("contestId", event.ContestID).
+		Str("problemId", event.ProblemID).
+		Msg("Processing contest.problem.removed")
+
+	if h.registry != nil {
+		h.recordContest(event.ContestID, h.registry.ProblemRemoved(ctx, event.ContestID, event.ProblemID))
+	}
+
+	wsMsg, err := newEventMessage(ctx, msg, protocol.MsgContestProblemRemoved, map[string]interface{}{
+		"contestId": event.ContestID,
+		"problemId": event.ProblemID,
+		"timestamp": event.Timestamp,
+	})
+	if err != nil {
+		return err
+	}
+
+	roomID := hub.BuildRoomID(hub.RoomTypeContest, event.ContestID)
+	h.sender(ctx).SendToRoom(roomID, wsMsg)
 
 	return nil
 }
@@ -234,7 +731,11 @@ func (h *Handlers) HandleLeaderboardFrozen(ctx context.Context, msg kafka.Messag
 		Str("contestId", event.ContestID).
 		Msg("Processing leaderboard.frozen")
 
-	wsMsg, err := protocol.NewMessage(protocol.MsgLeaderboardFrozen, map[string]interface{}{
+	if h.registry != nil {
+		h.recordContest(event.ContestID, h.registry.Frozen(ctx, event.ContestID, parseEventTime(event.FreezeTime)))
+	}
+
+	wsMsg, err := newEventMessage(ctx, msg, protocol.MsgLeaderboardFrozen, map[string]interface{}{
 		"contestId":  event.ContestID,
 		"freezeTime": event.FreezeTime,
 		"timestamp":  event.Timestamp,
@@ -244,7 +745,7 @@ func (h *Handlers) HandleLeaderboardFrozen(ctx context.Context, msg kafka.Messag
 	}
 
 	roomID := hub.BuildRoomID(hub.RoomTypeContest, event.ContestID)
-	h.hub.SendToRoom(roomID, wsMsg)
+	h.sender(ctx).SendToRoom(roomID, wsMsg)
 
 	return nil
 }
@@ -260,7 +761,11 @@ func (h *Handlers) HandleLeaderboardUnfrozen(ctx context.Context, msg kafka.Mess
 		Str("contestId", event.ContestID).
 		Msg("Processing leaderboard.unfrozen")
 
-	wsMsg, err := protocol.NewMessage(protocol.MsgLeaderboardUnfrozen, map[string]interface{}{
+	if h.registry != nil {
+		h.recordContest(event.ContestID, h.registry.Unfrozen(ctx, event.ContestID))
+	}
+
+	wsMsg, err := newEventMessage(ctx, msg, protocol.MsgLeaderboardUnfrozen, map[string]interface{}{
 		"contestId": event.ContestID,
 		"timestamp": event.Timestamp,
 	})
@@ -269,7 +774,7 @@ func (h *Handlers) HandleLeaderboardUnfrozen(ctx context.Context, msg kafka.Mess
 	}
 
 	roomID := hub.BuildRoomID(hub.RoomTypeContest, event.ContestID)
-	h.hub.SendToRoom(roomID, wsMsg)
+	h.sender(ctx).SendToRoom(roomID, wsMsg)
 
 	return nil
 }
@@ -287,7 +792,7 @@ func (h *Handlers) HandleProctoringViolation(ctx context.Context, msg kafka.Mess
 		Str("type", event.Type).
 		Msg("Processing proctoring.violation")
 
-	wsMsg, err := protocol.NewMessage(protocol.MsgProctoringViolation, map[string]interface{}{
+	wsMsg, err := newEventMessage(ctx, msg, protocol.MsgProctoringViolation, map[string]interface{}{
 		"contestId":           event.ContestID,
 		"userId":              event.UserID,
 		"type":                event.Type,
@@ -301,20 +806,154 @@ func (h *Handlers) HandleProctoringViolation(ctx context.Context, msg kafka.Mess
 		return err
 	}
 
-	h.hub.SendToUser(event.UserID, wsMsg)
+	h.sender(ctx).SendToUser(event.UserID, wsMsg)
+	h.sender(ctx).SendToRoom(hub.BuildRoomID(hub.RoomTypeStaff, event.ContestID), wsMsg)
 
 	return nil
 }
 
-func (h *Handlers) RegisterAll(consumer *Consumer) {
-	consumer.RegisterHandler("submission.created", h.HandleSubmissionCreated)
-	consumer.RegisterHandler("submission.judged", h.HandleSubmissionJudged)
-	consumer.RegisterHandler("leaderboard.updated", h.HandleLeaderboardUpdated)
-	consumer.RegisterHandler("leaderboard.frozen", h.HandleLeaderboardFrozen)
-	consumer.RegisterHandler("leaderboard.unfrozen", h.HandleLeaderboardUnfrozen)
-	consumer.RegisterHandler("contest.created", h.HandleContestCreated)
-	consumer.RegisterHandler("contest.started", h.HandleContestStarted)
-	consumer.RegisterHandler("contest.ended", h.HandleContestEnded)
-	consumer.RegisterHandler("contest.participant.registered", h.HandleParticipantRegistered)
-	consumer.RegisterHandler("proctoring.violation", h.HandleProctoringViolation)
+// byName returns the built-in handlers keyed by the name used in the
+// topic-to-handler configuration.
+func (h *Handlers) byName() map[string]EventHandler {
+	return map[string]EventHandler{
+		"submission.created":               h.HandleSubmissionCreated,
+		"submission.judged":                h.HandleSubmissionJudged,
+		"leaderboard.updated":              h.HandleLeaderboardUpdated,
+		"leaderboard.frozen":               h.HandleLeaderboardFrozen,
+		"leaderboard.unfrozen":             h.HandleLeaderboardUnfrozen,
+		"contest.created":                  h.HandleContestCreated,
+		"contest.started":                  h.HandleContestStarted,
+		"contest.ended":                    h.HandleContestEnded,
+		"contest.participant.registered":   h.HandleParticipantRegistered,
+		"contest.participant.unregistered": h.HandleParticipantUnregistered,
+		"contest.team.registered":          h.HandleTeamRegistered,
+		"contest.clarification.requested":  h.HandleClarificationRequested,
+		"contest.clarification.answered":   h.HandleClarificationAnswered,
+		"contest.announcement":             h.HandleAnnouncement,
+		"contest.problem.added":            h.HandleProblemAdded,
+		"contest.problem.removed":          h.HandleProblemRemoved,
+		"proctoring.violation":             h.HandleProctoringViolation,
+		"user.session.revoked":             h.HandleSessionRevoked,
+	}
+}
+
+// RegisterAll binds each topic to the handler named for it in topicHandlers,
+// plus any topic that only appears in the routing table. It fails if the
+// routing table would deliver a built-in topic to clients the handler keeps
+// it from.
+func (h *Handlers) RegisterAll(consumer *Consumer, topicHandlers map[string]string) error {
+	if h.router != nil {
+		if err := h.router.SetValidator(func(table *routing.Table) error {
+			return checkRoutes(table, topicHandlers)
+		}); err != nil {
+			return err
+		}
+	}
+
+	handlers := h.byName()
+	for topic, name := range topicHandlers {
+		handler, ok := handlers[name]
+		if !ok {
+			h.logger.Error().Str("topic", topic).Str("handler", name).Msg("Unknown handler configured for topic")
+		}
+		consumer.RegisterHandler(topic, h.routed(topic, handler))
+	}
+
+	if h.router == nil {
+		return nil
+	}
+	for _, topic := range h.router.Topics() {
+		if _, ok := topicHandlers[topic]; !ok {
+			consumer.RegisterHandler(topic, h.routed(topic, nil))
+		}
+	}
+	return nil
+}
+
+// checkRoutes refuses routes that would leak what a built-in handler
+// withholds: leaderboard updates while results are frozen, and submissions
+// without the submitter's team room.
+func checkRoutes(table *routing.Table, topicHandlers map[string]string) error {
+	for _, route := range table.Routes {
+		switch topicHandlers[route.Topic] {
+		case "leaderboard.updated":
+			for i, target := range route.Targets {
+				restricted := target.Room != "" && target.Permission == auth.PermViewFrozenResults
+				if !restricted && (target.Unless == nil || target.Unless.Frozen == "") {
+					return fmt.Errorf("topic %q: target %d must require %s or set unless.frozen", route.Topic, i, auth.PermViewFrozenResults)
+				}
+			}
+		case "submission.created", "submission.judged":
+			hasTeam := false
+			for _, target := range route.Targets {
+				hasTeam = hasTeam || target.Team != nil
+			}
+			if !hasTeam {
+				return fmt.Errorf("topic %q: route must include a team target", route.Topic)
+			}
+		}
+	}
+	return nil
+}
+
+// HandleSessionRevoked records the revocation, which disconnects the user's
+// connections on every instance.
+func (h *Handlers) HandleSessionRevoked(ctx context.Context, msg kafka.Message) error {
+	var event events.SessionRevokedEvent
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		h.logger.Error().Err(err).Msg("Failed to unmarshal user.session.revoked event")
+		return err
+	}
+
+	h.logger.Info().
+		Str("userId", event.UserID).
+		Str("sessionId", event.SessionID).
+		Str("reason", event.Reason).
+		Msg("Processing user.session.revoked")
+
+	if h.revocations == nil {
+		h.hub.DisconnectRevoked(event.UserID, event.SessionID, event.Reason)
+		return nil
+	}
+
+	// The event says when the session was revoked; the Kafka timestamp may
+	// be later, and would revoke tokens issued in between.
+	revokedAt := parseEventTime(event.Timestamp)
+	if revokedAt.IsZero() {
+		revokedAt = msg.Time
+	}
+	if revokedAt.IsZero() {
+		revokedAt = time.Now()
+	}
+	return h.revocations.Revoke(ctx, revocation.Revocation{
+		UserID:    event.UserID,
+		TokenID:   event.SessionID,
+		Reason:    event.Reason,
+		RevokedAt: revokedAt.UnixMilli(),
+	})
+}
+
+// routed checks the routing table on every message so that hot-reloaded
+// routes take effect without re-registering handlers. With a route, builtin
+// still runs for what it records but the route delivers the message.
+func (h *Handlers) routed(topic string, builtin EventHandler) EventHandler {
+	return func(ctx context.Context, msg kafka.Message) error {
+		hasRoute := h.router != nil && h.router.HasRoute(topic)
+		if builtin == nil && !hasRoute {
+			return fmt.Errorf("no route or handler for topic %s", topic)
+		}
+		if builtin != nil {
+			handlerCtx := ctx
+			if hasRoute {
+				handlerCtx = context.WithValue(ctx, routedKey{}, true)
+			}
+			if err := builtin(handlerCtx, msg); err != nil {
+				return err
+			}
+		}
+		if hasRoute {
+			return h.router.Dispatch(ctx, topic, msg.Value, msg.Time)
+		}
+		return nil
+	}
 }
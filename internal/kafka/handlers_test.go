@@ -0,0 +1,100 @@
+package kafka
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/routing"
+)
+
+func TestCheckRoutes(t *testing.T) {
+	topicHandlers := map[string]string{
+		"leaderboard.updated":   "leaderboard.updated",
+		"scores":                "leaderboard.updated",
+		"submission.judged":     "submission.judged",
+		"contest.problem.added": "contest.problem.added",
+	}
+
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{
+			name: "guarded leaderboard",
+			yaml: `routes:
+  - topic: leaderboard.updated
+    type: LEADERBOARD_UPDATE
+    targets:
+      - room: contest:$.contestId
+        permission: leaderboard:view_frozen
+      - room: contest:$.contestId
+        unless: {frozen: $.contestId}`,
+		},
+		{
+			name:    "unguarded leaderboard",
+			yaml:    "routes:\n  - topic: leaderboard.updated\n    type: L\n    targets:\n      - room: contest:$.contestId",
+			wantErr: "target 0 must require",
+		},
+		{
+			name:    "renamed leaderboard topic",
+			yaml:    "routes:\n  - topic: scores\n    type: L\n    targets:\n      - broadcast: true",
+			wantErr: "target 0 must require",
+		},
+		{
+			name:    "leaderboard guarded by field only",
+			yaml:    "routes:\n  - topic: leaderboard.updated\n    type: L\n    targets:\n      - room: r\n        unless: {field: $.frozen}",
+			wantErr: "target 0 must require",
+		},
+		{
+			name: "submission with team",
+			yaml: "routes:\n  - topic: submission.judged\n    type: S\n    targets:\n      - user: $.userId\n      - team: {contest: $.contestId, user: $.userId}",
+		},
+		{
+			name:    "submission without team",
+			yaml:    "routes:\n  - topic: submission.judged\n    type: S\n    targets:\n      - user: $.userId",
+			wantErr: "team target",
+		},
+		{
+			name: "other built-in topic",
+			yaml: "routes:\n  - topic: contest.problem.added\n    type: P\n    targets:\n      - broadcast: true",
+		},
+		{
+			name: "routed-only topic",
+			yaml: "routes:\n  - topic: user.notification\n    type: N\n    targets:\n      - user: $.userId",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := routing.ParseTable([]byte(tt.yaml))
+			if err != nil {
+				t.Fatalf("ParseTable: %v", err)
+			}
+			err = checkRoutes(table, topicHandlers)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkRoutes() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkRoutes() = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckRoutesExample(t *testing.T) {
+	table, err := routing.LoadTable("../../config/routes.example.yaml")
+	if err != nil {
+		t.Fatalf("LoadTable: %v", err)
+	}
+	handlers := make(map[string]string)
+	for name := range (&Handlers{}).byName() {
+		handlers[name] = name
+	}
+	if err := checkRoutes(table, handlers); err != nil {
+		t.Errorf("example table refused: %v", err)
+	}
+}
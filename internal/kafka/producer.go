@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/tracing"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/events"
+	"github.com/rs/zerolog"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	producerBatchTimeout = 10 * time.Millisecond
+	producerWriteTimeout = 10 * time.Second
+)
+
+// Producer publishes events emitted by the socket service, such as
+// proctoring signals, as JSON with the current trace context in the
+// headers.
+type Producer struct {
+	writer  *kafka.Writer
+	metrics *metrics.Metrics
+	logger  zerolog.Logger
+}
+
+func NewProducer(brokers []string, m *metrics.Metrics, logger zerolog.Logger) *Producer {
+	return &Producer{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireOne,
+			BatchTimeout: producerBatchTimeout,
+			WriteTimeout: producerWriteTimeout,
+		},
+		metrics: m,
+		logger:  logger.With().Str("component", "kafka-producer").Logger(),
+	}
+}
+
+// Publish writes event to topic keyed by key, tagged with schema version 1.
+func (p *Producer) Publish(ctx context.Context, topic, key string, event interface{}) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	msg := kafka.Message{
+		Topic:   topic,
+		Key:     []byte(key),
+		Value:   value,
+		Headers: []kafka.Header{{Key: events.SchemaVersionHeader, Value: []byte("1")}},
+	}
+	tracing.Inject(ctx, tracing.KafkaHeaders{Headers: &msg.Headers})
+
+	err = p.writer.WriteMessages(ctx, msg)
+	p.metrics.IncKafkaProduced(topic, metrics.Status(err))
+	if err != nil {
+		p.logger.Error().Err(err).Str("topic", topic).Str("key", key).Msg("Failed to publish event")
+		return err
+	}
+	return nil
+}
+
+func (p *Producer) Close() error {
+	return p.writer.Close()
+}
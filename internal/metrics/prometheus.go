@@ -1,19 +1,40 @@
 package metrics
 
 import (
+	"strconv"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// Metrics holds every collector exported by the service. All helper methods
+// are safe to call on a nil *Metrics so components can run without metrics.
 type Metrics struct {
-	ConnectionsTotal   prometheus.Gauge
-	ConnectionsByRoom  *prometheus.GaugeVec
-	MessagesReceived   prometheus.Counter
-	MessagesSent       prometheus.Counter
-	MessageLatency     prometheus.Histogram
-	KafkaMessages      *prometheus.CounterVec
-	RedisOperations    *prometheus.CounterVec
-	AuthFailures       prometheus.Counter
+	ConnectionsTotal        prometheus.Gauge
+	ConnectionsByRoomType   *prometheus.GaugeVec
+	ConnectionsByRoom       *prometheus.GaugeVec
+	MessagesReceived        prometheus.Counter
+	MessagesSent            prometheus.Counter
+	MessageLatency          prometheus.Histogram
+	SendBufferDrops         *prometheus.CounterVec
+	SlowConsumerDisconnects prometheus.Counter
+	FanoutSize              *prometheus.HistogramVec
+	DeliveryLatency         *prometheus.HistogramVec
+	KafkaMessages           *prometheus.CounterVec
+	KafkaConsumerLag        *prometheus.GaugeVec
+	KafkaRebalances         *prometheus.CounterVec
+	KafkaRejected           *prometheus.CounterVec
+	KafkaProduced           *prometheus.CounterVec
+	RedisOperations         *prometheus.CounterVec
+	AuthFailures            prometheus.Counter
+	RevokedDisconnects      prometheus.Counter
+	RejectedOrigins         *prometheus.CounterVec
+	ThrottledMessages       *prometheus.CounterVec
+	ThrottledClients        *prometheus.CounterVec
+	RateLimitedRequests     *prometheus.CounterVec
+	RateLimitFallbacks      prometheus.Counter
+	ConnectionsRejected     *prometheus.CounterVec
+	ConnectionsEvicted      prometheus.Counter
 }
 
 func New() *Metrics {
@@ -22,9 +43,13 @@ func New() *Metrics {
 			Name: "ws_connections_total",
 			Help: "Total number of active WebSocket connections",
 		}),
+		ConnectionsByRoomType: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ws_connections_by_room_type",
+			Help: "Number of room memberships per room type",
+		}, []string{"room_type"}),
 		ConnectionsByRoom: promauto.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "ws_connections_by_room",
-			Help: "Number of connections per room",
+			Help: "Number of connections per room, only for tracked or largest rooms",
 		}, []string{"room_type", "room_id"}),
 		MessagesReceived: promauto.NewCounter(prometheus.CounterOpts{
 			Name: "ws_messages_received_total",
@@ -39,10 +64,44 @@ func New() *Metrics {
 			Help:    "Message processing latency in seconds",
 			Buckets: prometheus.DefBuckets,
 		}),
+		SendBufferDrops: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "ws_send_buffer_drops_total",
+			Help: "Total number of messages dropped because a client send buffer was full",
+		}, []string{"target"}),
+		SlowConsumerDisconnects: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "ws_slow_consumer_disconnects_total",
+			Help: "Total number of clients disconnected for not draining their send buffer",
+		}),
+		FanoutSize: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "ws_fanout_size",
+			Help:    "Number of local connections a single message was delivered to",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 9),
+		}, []string{"target"}),
+		DeliveryLatency: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "ws_delivery_latency_seconds",
+			Help:    "Time from the Kafka event timestamp to the socket write",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"type"}),
 		KafkaMessages: promauto.NewCounterVec(prometheus.CounterOpts{
 			Name: "kafka_messages_processed_total",
 			Help: "Total number of Kafka messages processed",
 		}, []string{"topic", "status"}),
+		KafkaConsumerLag: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kafka_consumer_lag",
+			Help: "Messages between the last fetched offset and the partition high watermark",
+		}, []string{"topic", "partition"}),
+		KafkaRebalances: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_consumer_rebalances_total",
+			Help: "Total number of consumer group partition assignments and revocations",
+		}, []string{"event"}),
+		KafkaRejected: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_events_rejected_total",
+			Help: "Total number of Kafka events rejected by schema validation",
+		}, []string{"topic", "reason"}),
+		KafkaProduced: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "kafka_messages_produced_total",
+			Help: "Total number of Kafka messages published by the service",
+		}, []string{"topic", "status"}),
 		RedisOperations: promauto.NewCounterVec(prometheus.CounterOpts{
 			Name: "redis_operations_total",
 			Help: "Total number of Redis operations",
@@ -51,45 +110,248 @@ func New() *Metrics {
 			Name: "ws_auth_failures_total",
 			Help: "Total number of authentication failures",
 		}),
+		RevokedDisconnects: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "ws_revoked_disconnects_total",
+			Help: "Total number of connections closed because their session was revoked",
+		}),
+		RejectedOrigins: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_rejected_origins_total",
+			Help: "Total number of requests rejected for a disallowed Origin",
+		}, []string{"check"}),
+		ThrottledMessages: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "ws_throttled_messages_total",
+			Help: "Total number of inbound messages dropped by rate limits",
+		}, []string{"type"}),
+		ThrottledClients: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "ws_throttled_clients_total",
+			Help: "Total number of escalation actions taken against throttled clients",
+		}, []string{"action"}),
+		RateLimitedRequests: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_rate_limited_requests_total",
+			Help: "Total number of HTTP requests rejected by a rate limit policy",
+		}, []string{"policy"}),
+		RateLimitFallbacks: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "http_rate_limit_fallbacks_total",
+			Help: "Total number of rate limit decisions made locally because Redis was unavailable",
+		}),
+		ConnectionsRejected: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "ws_connections_rejected_total",
+			Help: "Total number of WebSocket connections rejected by a connection cap",
+		}, []string{"limit"}),
+		ConnectionsEvicted: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "ws_connections_evicted_total",
+			Help: "Total number of WebSocket connections evicted to admit a newer one",
+		}),
 	}
 }
 
 func (m *Metrics) IncConnections() {
+	if m == nil {
+		return
+	}
 	m.ConnectionsTotal.Inc()
 }
 
 func (m *Metrics) DecConnections() {
+	if m == nil {
+		return
+	}
 	m.ConnectionsTotal.Dec()
 }
 
-func (m *Metrics) IncRoomConnections(roomType, roomID string) {
-	m.ConnectionsByRoom.WithLabelValues(roomType, roomID).Inc()
+func (m *Metrics) IncRoomConnections(roomType string) {
+	if m == nil {
+		return
+	}
+	m.ConnectionsByRoomType.WithLabelValues(roomType).Inc()
 }
 
-func (m *Metrics) DecRoomConnections(roomType, roomID string) {
-	m.ConnectionsByRoom.WithLabelValues(roomType, roomID).Dec()
+func (m *Metrics) DecRoomConnections(roomType string) {
+	if m == nil {
+		return
+	}
+	m.ConnectionsByRoomType.WithLabelValues(roomType).Dec()
+}
+
+func (m *Metrics) SetRoomConnections(roomType, roomID string, count int) {
+	if m == nil {
+		return
+	}
+	m.ConnectionsByRoom.WithLabelValues(roomType, roomID).Set(float64(count))
+}
+
+func (m *Metrics) DeleteRoomConnections(roomType, roomID string) {
+	if m == nil {
+		return
+	}
+	m.ConnectionsByRoom.DeleteLabelValues(roomType, roomID)
 }
 
 func (m *Metrics) IncMessagesReceived() {
+	if m == nil {
+		return
+	}
 	m.MessagesReceived.Inc()
 }
 
 func (m *Metrics) IncMessagesSent() {
+	if m == nil {
+		return
+	}
 	m.MessagesSent.Inc()
 }
 
 func (m *Metrics) ObserveLatency(seconds float64) {
+	if m == nil {
+		return
+	}
 	m.MessageLatency.Observe(seconds)
 }
 
+func (m *Metrics) IncSendBufferDrops(target string) {
+	if m == nil {
+		return
+	}
+	m.SendBufferDrops.WithLabelValues(target).Inc()
+}
+
+func (m *Metrics) IncSlowConsumerDisconnects() {
+	if m == nil {
+		return
+	}
+	m.SlowConsumerDisconnects.Inc()
+}
+
+func (m *Metrics) ObserveFanout(target string, size int) {
+	if m == nil {
+		return
+	}
+	m.FanoutSize.WithLabelValues(target).Observe(float64(size))
+}
+
+func (m *Metrics) ObserveDeliveryLatency(msgType string, seconds float64) {
+	if m == nil {
+		return
+	}
+	m.DeliveryLatency.WithLabelValues(msgType).Observe(seconds)
+}
+
 func (m *Metrics) IncKafkaMessage(topic, status string) {
+	if m == nil {
+		return
+	}
 	m.KafkaMessages.WithLabelValues(topic, status).Inc()
 }
 
+func (m *Metrics) SetKafkaLag(topic string, partition int, lag int64) {
+	if m == nil {
+		return
+	}
+	m.KafkaConsumerLag.WithLabelValues(topic, strconv.Itoa(partition)).Set(float64(lag))
+}
+
+func (m *Metrics) DeleteKafkaLag(topic string, partition int) {
+	if m == nil {
+		return
+	}
+	m.KafkaConsumerLag.DeleteLabelValues(topic, strconv.Itoa(partition))
+}
+
+func (m *Metrics) IncKafkaRebalance(event string) {
+	if m == nil {
+		return
+	}
+	m.KafkaRebalances.WithLabelValues(event).Inc()
+}
+
+func (m *Metrics) IncKafkaRejected(topic, reason string) {
+	if m == nil {
+		return
+	}
+	m.KafkaRejected.WithLabelValues(topic, reason).Inc()
+}
+
 func (m *Metrics) IncRedisOperation(operation, status string) {
+	if m == nil {
+		return
+	}
 	m.RedisOperations.WithLabelValues(operation, status).Inc()
 }
 
 func (m *Metrics) IncAuthFailures() {
+	if m == nil {
+		return
+	}
 	m.AuthFailures.Inc()
 }
+
+func (m *Metrics) IncRevokedDisconnects() {
+	if m == nil {
+		return
+	}
+	m.RevokedDisconnects.Inc()
+}
+
+func (m *Metrics) IncRejectedOrigin(check string) {
+	if m == nil {
+		return
+	}
+	m.RejectedOrigins.WithLabelValues(check).Inc()
+}
+
+func (m *Metrics) IncThrottledMessages(msgType string) {
+	if m == nil {
+		return
+	}
+	m.ThrottledMessages.WithLabelValues(msgType).Inc()
+}
+
+func (m *Metrics) IncThrottledClients(action string) {
+	if m == nil {
+		return
+	}
+	m.ThrottledClients.WithLabelValues(action).Inc()
+}
+
+// Status maps an error to the "ok"/"error" status label.
+func Status(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+func (m *Metrics) IncRateLimitedRequests(policy string) {
+	if m == nil {
+		return
+	}
+	m.RateLimitedRequests.WithLabelValues(policy).Inc()
+}
+
+func (m *Metrics) IncRateLimitFallbacks() {
+	if m == nil {
+		return
+	}
+	m.RateLimitFallbacks.Inc()
+}
+
+func (m *Metrics) IncConnectionsRejected(limit string) {
+	if m == nil {
+		return
+	}
+	m.ConnectionsRejected.WithLabelValues(limit).Inc()
+}
+
+func (m *Metrics) IncConnectionsEvicted() {
+	if m == nil {
+		return
+	}
+	m.ConnectionsEvicted.Inc()
+}
+
+func (m *Metrics) IncKafkaProduced(topic, status string) {
+	if m == nil {
+		return
+	}
+	m.KafkaProduced.WithLabelValues(topic, status).Inc()
+}
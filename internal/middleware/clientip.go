@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// ClientIPResolver determines the address of the client behind any trusted
+// reverse proxies. X-Forwarded-For and X-Real-IP are only honoured when the
+// direct peer is a trusted proxy, and X-Forwarded-For is walked from the
+// right so that entries prepended by the client are ignored.
+type ClientIPResolver struct {
+	trusted []netip.Prefix
+}
+
+// NewClientIPResolver parses trustedProxies, a list of CIDRs or single
+// addresses. An empty list trusts no proxy and always uses the peer address.
+func NewClientIPResolver(trustedProxies []string) (*ClientIPResolver, error) {
+	r := &ClientIPResolver{}
+	for _, entry := range trustedProxies {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			r.trusted = append(r.trusted, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		r.trusted = append(r.trusted, prefix.Masked())
+	}
+	return r, nil
+}
+
+func (r *ClientIPResolver) isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range r.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the client IP of req.
+func (r *ClientIPResolver) Resolve(req *http.Request) string {
+	peer, err := parseIP(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	if !r.isTrusted(peer) {
+		return peer.String()
+	}
+
+	if values := req.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		hops := strings.Split(strings.Join(values, ","), ",")
+		client := peer
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := parseIP(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			client = hop
+			if !r.isTrusted(hop) {
+				break
+			}
+		}
+		return client.String()
+	}
+
+	if xri, err := parseIP(strings.TrimSpace(req.Header.Get("X-Real-IP"))); err == nil {
+		return xri.String()
+	}
+	return peer.String()
+}
+
+// Middleware resolves the client IP once and stores it in the request
+// context for ClientIP.
+func (r *ClientIPResolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := context.WithValue(req.Context(), clientIPKey{}, r.Resolve(req))
+		next.ServeHTTP(w, req.WithContext(ctx))
+	})
+}
+
+// ClientIP returns the IP stored by ClientIPResolver.Middleware, or the peer
+// address when the request did not pass through it.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	if peer, err := parseIP(r.RemoteAddr); err == nil {
+		return peer.String()
+	}
+	return r.RemoteAddr
+}
+
+// parseIP accepts a bare address or host:port.
+func parseIP(s string) (netip.Addr, error) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap().WithZone(""), nil
+}
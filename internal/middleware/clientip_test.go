@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	resolver, err := NewClientIPResolver([]string{"10.0.0.0/8", "192.168.1.1", " "})
+	if err != nil {
+		t.Fatalf("NewClientIPResolver: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		peer string
+		xff  []string
+		xri  string
+		want string
+	}{
+		{"untrusted peer", "203.0.113.9:5000", nil, "", "203.0.113.9"},
+		{"untrusted peer ignores headers", "203.0.113.9:5000", []string{"198.51.100.1"}, "198.51.100.2", "203.0.113.9"},
+		{"trusted proxy", "10.0.0.1:5000", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.1:5000", []string{"198.51.100.1, 10.0.0.2, 192.168.1.1"}, "", "198.51.100.1"},
+		{"spoofed leftmost entry", "10.0.0.1:5000", []string{"1.1.1.1, 198.51.100.1"}, "", "198.51.100.1"},
+		{"spoofed entry in a separate header", "10.0.0.1:5000", []string{"1.1.1.1", "198.51.100.1"}, "", "198.51.100.1"},
+		{"malformed entry stops the walk", "10.0.0.1:5000", []string{"198.51.100.1, not-an-ip"}, "", "10.0.0.1"},
+		{"malformed entry behind a proxy", "10.0.0.1:5000", []string{"not-an-ip, 10.0.0.2"}, "", "10.0.0.2"},
+		{"only trusted hops", "10.0.0.1:5000", []string{"10.0.0.3"}, "", "10.0.0.3"},
+		{"real ip from trusted proxy", "10.0.0.1:5000", nil, "198.51.100.2", "198.51.100.2"},
+		{"malformed real ip", "10.0.0.1:5000", nil, "bogus", "10.0.0.1"},
+		{"mapped ipv4 peer", "[::ffff:203.0.113.9]:5000", nil, "", "203.0.113.9"},
+		{"ipv6 client", "10.0.0.1:5000", []string{"2001:db8::1"}, "", "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			r.RemoteAddr = tt.peer
+			for _, value := range tt.xff {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if tt.xri != "" {
+				r.Header.Set("X-Real-IP", tt.xri)
+			}
+			if got := resolver.Resolve(r); got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPResolverNoProxies(t *testing.T) {
+	resolver, err := NewClientIPResolver(nil)
+	if err != nil {
+		t.Fatalf("NewClientIPResolver: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := resolver.Resolve(r); got != "10.0.0.1" {
+		t.Errorf("Resolve() = %q, want the peer address", got)
+	}
+}
+
+func TestNewClientIPResolverInvalid(t *testing.T) {
+	for _, entry := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0.1/"} {
+		if _, err := NewClientIPResolver([]string{entry}); err == nil {
+			t.Errorf("NewClientIPResolver(%q) succeeded", entry)
+		}
+	}
+}
+
+func TestClientIPMiddleware(t *testing.T) {
+	resolver, err := NewClientIPResolver([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatalf("NewClientIPResolver: %v", err)
+	}
+
+	var got string
+	handler := resolver.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ClientIP(r)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if got != "198.51.100.1" {
+		t.Errorf("ClientIP() = %q, want 198.51.100.1", got)
+	}
+
+	r.RemoteAddr = "203.0.113.9:5000"
+	if got := ClientIP(r); got != "203.0.113.9" {
+		t.Errorf("ClientIP() without the middleware = %q, want the peer address", got)
+	}
+}
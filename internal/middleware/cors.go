@@ -6,15 +6,15 @@ import (
 )
 
 type CORSConfig struct {
-	AllowedOrigins   []string
+	Origins          *OriginPolicy
 	AllowedMethods   []string
 	AllowedHeaders   []string
 	AllowCredentials bool
 }
 
-func DefaultCORSConfig() CORSConfig {
+func DefaultCORSConfig(origins *OriginPolicy) CORSConfig {
 	return CORSConfig{
-		AllowedOrigins:   []string{"*"},
+		Origins:          origins,
 		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
 		AllowedHeaders:   []string{"Origin", "Content-Type", "Authorization"},
 		AllowCredentials: true,
@@ -25,27 +25,37 @@ func CORS(config CORSConfig) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-			allowed := false
-			for _, o := range config.AllowedOrigins {
-				if o == "*" || o == origin {
-					allowed = true
-					break
+			w.Header().Add("Vary", "Origin")
+
+			if !config.Origins.Check(origin, "cors") {
+				if r.Method == http.MethodOptions {
+					http.Error(w, "Origin not allowed", http.StatusForbidden)
+					return
 				}
+				next.ServeHTTP(w, r)
+				return
 			}
 
-			if allowed {
+			// A "*" policy must not be combined with credentials, otherwise
+			// any site could make credentialed requests.
+			if config.Origins.AllowsAll() {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
 				w.Header().Set("Access-Control-Allow-Origin", origin)
+				if config.AllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
 			}
 
 			w.Header().Set("Access-Control-Allow-Methods", strings.Join(config.AllowedMethods, ", "))
 			w.Header().Set("Access-Control-Allow-Headers", strings.Join(config.AllowedHeaders, ", "))
 
-			if config.AllowCredentials {
-				w.Header().Set("Access-Control-Allow-Credentials", "true")
-			}
-
-			if r.Method == "OPTIONS" {
+			if r.Method == http.MethodOptions {
 				w.WriteHeader(http.StatusOK)
 				return
 			}
@@ -50,7 +50,7 @@ func Logging(logger zerolog.Logger) func(http.Handler) http.Handler {
 				Int("status", rw.status).
 				Int("size", rw.size).
 				Dur("duration", duration).
-				Str("ip", getClientIP(r)).
+				Str("ip", ClientIP(r)).
 				Msg("HTTP request")
 		})
 	}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
+	"github.com/rs/zerolog"
+)
+
+const regexOriginPrefix = "re:"
+
+// OriginPolicy decides which browser origins may call the service and open
+// WebSocket connections. Patterns are one of:
+//
+//	https://app.example.com      exact origin
+//	https://*.example.com        any subdomain of example.com
+//	re:https://pr-\d+\.example\.com    regular expression
+//	*                            any origin (never with credentials)
+//
+// Regular expressions must match the whole origin, so an unanchored
+// pattern cannot be satisfied by an attacker's origin that contains it.
+type OriginPolicy struct {
+	exact    map[string]bool
+	suffixes []wildcardOrigin
+	patterns []*regexp.Regexp
+	allowAll bool
+	metrics  *metrics.Metrics
+	logger   zerolog.Logger
+}
+
+type wildcardOrigin struct {
+	scheme string
+	suffix string // ".example.com"
+	port   string
+}
+
+func NewOriginPolicy(patterns []string, m *metrics.Metrics, logger zerolog.Logger) (*OriginPolicy, error) {
+	p := &OriginPolicy{
+		exact:   make(map[string]bool),
+		metrics: m,
+		logger:  logger.With().Str("component", "origin").Logger(),
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		switch {
+		case pattern == "":
+			continue
+		case pattern == "*":
+			p.allowAll = true
+		case strings.HasPrefix(pattern, regexOriginPrefix):
+			re, err := regexp.Compile("^(?:" + strings.TrimPrefix(pattern, regexOriginPrefix) + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid origin pattern %q: %w", pattern, err)
+			}
+			p.patterns = append(p.patterns, re)
+		case strings.Contains(pattern, "://*."):
+			u, err := url.Parse(strings.Replace(pattern, "://*.", "://wildcard.", 1))
+			if err != nil || u.Host == "" {
+				return nil, fmt.Errorf("invalid origin pattern %q", pattern)
+			}
+			p.suffixes = append(p.suffixes, wildcardOrigin{
+				scheme: u.Scheme,
+				suffix: strings.TrimPrefix(u.Hostname(), "wildcard"),
+				port:   u.Port(),
+			})
+		default:
+			p.exact[strings.ToLower(strings.TrimSuffix(pattern, "/"))] = true
+		}
+	}
+
+	return p, nil
+}
+
+// AllowsAll reports whether the policy contains "*".
+func (p *OriginPolicy) AllowsAll() bool {
+	return p.allowAll
+}
+
+func (p *OriginPolicy) Allowed(origin string) bool {
+	if p.allowAll {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	if p.exact[origin] {
+		return true
+	}
+
+	if len(p.suffixes) > 0 {
+		if u, err := url.Parse(origin); err == nil {
+			host := u.Hostname()
+			for _, w := range p.suffixes {
+				if u.Scheme == w.scheme && u.Port() == w.port &&
+					strings.HasSuffix(host, w.suffix) && len(host) > len(w.suffix) {
+					return true
+				}
+			}
+		}
+	}
+
+	for _, re := range p.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check is Allowed that logs and counts rejections. check names the caller,
+// e.g. "cors" or "upgrade".
+func (p *OriginPolicy) Check(origin, check string) bool {
+	if p.Allowed(origin) {
+		return true
+	}
+	p.logger.Warn().Str("origin", origin).Str("check", check).Msg("Rejected request from disallowed origin")
+	p.metrics.IncRejectedOrigin(check)
+	return false
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	policy, err := NewOriginPolicy([]string{
+		"https://app.example.com/",
+		"https://*.example.com",
+		"http://*.local.test:3000",
+		`re:https://pr-\d+\.preview\.test`,
+	}, nil, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewOriginPolicy: %v", err)
+	}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"https://docs.example.com", true},
+		{"https://a.b.example.com", true},
+		{"https://example.com", false},
+		{"https://evil-example.com", false},
+		{"https://evilexample.com", false},
+		{"https://example.com.evil.com", false},
+		{"https://docs.example.com.evil.com", false},
+		{"http://docs.example.com", false},
+		{"https://docs.example.com:8443", false},
+		{"http://dev.local.test:3000", true},
+		{"http://dev.local.test", false},
+		{"https://pr-12.preview.test", true},
+		{"https://pr-12.preview.test.evil.com", false},
+		{"https://evil.com/https://pr-12.preview.test", false},
+		{"https://pr-x.preview.test", false},
+		{"", false},
+		{"null", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			if got := policy.Allowed(tt.origin); got != tt.want {
+				t.Errorf("Allowed(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOriginPolicyInvalid(t *testing.T) {
+	for _, pattern := range []string{"re:(", "https://*.:bad"} {
+		if _, err := NewOriginPolicy([]string{pattern}, nil, zerolog.Nop()); err == nil {
+			t.Errorf("NewOriginPolicy(%q) succeeded", pattern)
+		}
+	}
+}
+
+func TestCORSOrigin(t *testing.T) {
+	policy, err := NewOriginPolicy([]string{"https://app.example.com"}, nil, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewOriginPolicy: %v", err)
+	}
+	handler := CORS(DefaultCORSConfig(policy))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name      string
+		method    string
+		origin    string
+		status    int
+		allowedAs string
+	}{
+		{"allowed", http.MethodGet, "https://app.example.com", http.StatusOK, "https://app.example.com"},
+		{"allowed preflight", http.MethodOptions, "https://app.example.com", http.StatusOK, "https://app.example.com"},
+		{"disallowed", http.MethodGet, "https://evil.example.org", http.StatusOK, ""},
+		{"disallowed preflight", http.MethodOptions, "https://evil.example.org", http.StatusForbidden, ""},
+		{"missing origin", http.MethodGet, "", http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowedAs {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.allowedAs)
+			}
+		})
+	}
+}
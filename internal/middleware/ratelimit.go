@@ -1,95 +1,215 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/redis"
 	"github.com/rs/zerolog"
 )
 
-type RateLimiter struct {
-	requests map[string]*clientLimit
-	mu       sync.RWMutex
-	limit    int
-	window   time.Duration
-	logger   zerolog.Logger
+const (
+	rateLimitKeyPrefix = "ratelimit:"
+	redisRetryAfter    = 5 * time.Second
+	redisLimitTimeout  = 100 * time.Millisecond
+)
+
+// gcraScript implements the generic cell rate algorithm. KEYS[1] holds the
+// theoretical arrival time in milliseconds; ARGV are the emission interval
+// and the burst, both in milliseconds. It returns {allowed, retryAfterMs}.
+// The Redis clock is used so instances with skewed clocks agree.
+var gcraScript = redis.NewScript(`
+local interval = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local tat = tonumber(redis.call('GET', KEYS[1])) or now
+if tat < now then
+	tat = now
+end
+local newTat = tat + interval
+if newTat - now > tolerance then
+	return {0, newTat - now - tolerance}
+end
+redis.call('SET', KEYS[1], newTat, 'PX', newTat - now)
+return {1, 0}
+`)
+
+// RateLimitPolicy allows Limit requests per Window from a client IP with
+// bursts of up to Burst requests. A zero Limit disables the policy.
+type RateLimitPolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+	Burst  int
+}
+
+func (p RateLimitPolicy) interval() time.Duration {
+	return p.Window / time.Duration(p.Limit)
 }
 
-type clientLimit struct {
-	count     int
-	resetTime time.Time
+func (p RateLimitPolicy) burst() int {
+	if p.Burst <= 0 {
+		return 1
+	}
+	return p.Burst
+}
+
+// RateLimiter enforces RateLimitPolicies per client IP. State lives in Redis
+// so limits hold across replicas; while Redis is unavailable decisions are
+// made from process-local state instead.
+type RateLimiter struct {
+	redis   *redis.Client
+	local   map[string]time.Time
+	mu      sync.Mutex
+	metrics *metrics.Metrics
+	logger  zerolog.Logger
+
+	// redisDownUntil skips Redis for a while after a failure so requests do
+	// not each wait for a timeout.
+	redisDownUntil time.Time
+
+	done chan struct{}
 }
 
-func NewRateLimiter(limit int, window time.Duration, logger zerolog.Logger) *RateLimiter {
+func NewRateLimiter(redisClient *redis.Client, m *metrics.Metrics, logger zerolog.Logger) *RateLimiter {
 	rl := &RateLimiter{
-		requests: make(map[string]*clientLimit),
-		limit:    limit,
-		window:   window,
-		logger:   logger.With().Str("component", "ratelimit").Logger(),
+		redis:   redisClient,
+		local:   make(map[string]time.Time),
+		metrics: m,
+		logger:  logger.With().Str("component", "ratelimit").Logger(),
+		done:    make(chan struct{}),
 	}
 
 	go rl.cleanup()
 	return rl
 }
 
+func (rl *RateLimiter) Stop() {
+	close(rl.done)
+}
+
 func (rl *RateLimiter) cleanup() {
 	ticker := time.NewTicker(1 * time.Minute)
-	for range ticker.C {
-		rl.mu.Lock()
-		now := time.Now()
-		for ip, cl := range rl.requests {
-			if now.After(cl.resetTime) {
-				delete(rl.requests, ip)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rl.done:
+			return
+		case <-ticker.C:
+			rl.mu.Lock()
+			now := time.Now()
+			for key, tat := range rl.local {
+				if now.After(tat) {
+					delete(rl.local, key)
+				}
 			}
+			rl.mu.Unlock()
+		}
+	}
+}
+
+// Allow reports whether a request from ip is within policy and, if not, how
+// long the client should wait.
+func (rl *RateLimiter) Allow(ctx context.Context, policy RateLimitPolicy, ip string) (bool, time.Duration) {
+	key := rateLimitKeyPrefix + policy.Name + ":" + ip
+
+	if rl.redis != nil && rl.redisAvailable() {
+		allowed, retryAfter, err := rl.allowRedis(ctx, policy, key)
+		if err == nil {
+			return allowed, retryAfter
 		}
-		rl.mu.Unlock()
+		rl.markRedisDown(err)
 	}
+
+	rl.metrics.IncRateLimitFallbacks()
+	return rl.allowLocal(policy, key)
+}
+
+func (rl *RateLimiter) allowRedis(ctx context.Context, policy RateLimitPolicy, key string) (bool, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, redisLimitTimeout)
+	defer cancel()
+
+	interval := policy.interval()
+	tolerance := interval * time.Duration(policy.burst())
+	result, err := rl.redis.RunScript(ctx, gcraScript, []string{key},
+		ceilMillis(interval), ceilMillis(tolerance))
+	if err != nil {
+		return false, 0, err
+	}
+
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit script result %v", result)
+	}
+	allowed, _ := values[0].(int64)
+	retryAfter, _ := values[1].(int64)
+	return allowed == 1, time.Duration(retryAfter) * time.Millisecond, nil
 }
 
-func (rl *RateLimiter) Allow(ip string) bool {
+func (rl *RateLimiter) allowLocal(policy RateLimitPolicy, key string) (bool, time.Duration) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
 	now := time.Now()
-	cl, exists := rl.requests[ip]
+	interval := policy.interval()
+	tolerance := interval * time.Duration(policy.burst())
 
-	if !exists || now.After(cl.resetTime) {
-		rl.requests[ip] = &clientLimit{
-			count:     1,
-			resetTime: now.Add(rl.window),
-		}
-		return true
+	tat, ok := rl.local[key]
+	if !ok || tat.Before(now) {
+		tat = now
 	}
-
-	if cl.count >= rl.limit {
-		return false
+	newTat := tat.Add(interval)
+	if excess := newTat.Sub(now) - tolerance; excess > 0 {
+		return false, excess
 	}
+	rl.local[key] = newTat
+	return true, 0
+}
 
-	cl.count++
-	return true
+func (rl *RateLimiter) redisAvailable() bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return time.Now().After(rl.redisDownUntil)
 }
 
-func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := getClientIP(r)
+func (rl *RateLimiter) markRedisDown(err error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.redisDownUntil = time.Now().Add(redisRetryAfter)
+	rl.logger.Warn().Err(err).Dur("retryAfter", redisRetryAfter).Msg("Redis rate limiting unavailable, using local limits")
+}
 
-		if !rl.Allow(ip) {
-			rl.logger.Warn().Str("ip", ip).Msg("Rate limit exceeded")
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
-			return
+// Limit returns middleware that enforces policy on the wrapped handler.
+func (rl *RateLimiter) Limit(policy RateLimitPolicy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if policy.Limit <= 0 || policy.Window <= 0 {
+			return next
 		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := ClientIP(r)
+
+			allowed, retryAfter := rl.Allow(r.Context(), policy, ip)
+			if !allowed {
+				rl.logger.Warn().Str("ip", ip).Str("policy", policy.Name).Msg("Rate limit exceeded")
+				rl.metrics.IncRateLimitedRequests(policy.Name)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				return
+			}
 
-		next.ServeHTTP(w, r)
-	})
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
-func getClientIP(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		return xff
-	}
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
-	}
-	return r.RemoteAddr
+func ceilMillis(d time.Duration) int64 {
+	return int64(math.Ceil(float64(d) / float64(time.Millisecond)))
 }
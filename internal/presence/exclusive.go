@@ -0,0 +1,243 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
+	redisclient "github.com/CDeX-Labs/CDeX-Socket-Service/internal/redis"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+const (
+	exclusiveKeyFmt = "ws:exclusive:%s"
+	// ExclusiveChannel carries exclusivity changes and claims between
+	// instances.
+	ExclusiveChannel = "ws:exclusive"
+
+	exclusiveCacheTTL = 30 * time.Second
+	exclusiveTimeout  = 2 * time.Second
+)
+
+// Claim records that ClientID is now the only connection of UserID allowed
+// in RoomID.
+type Claim struct {
+	RoomID     string `json:"roomId"`
+	UserID     string `json:"userId"`
+	ClientID   string `json:"clientId"`
+	InstanceID string `json:"instanceId"`
+}
+
+type exclusiveMessage struct {
+	Claim     *Claim `json:"claim,omitempty"`
+	RoomID    string `json:"roomId,omitempty"`
+	Exclusive bool   `json:"exclusive,omitempty"`
+}
+
+type exclusiveEntry struct {
+	exclusive bool
+	expires   time.Time
+}
+
+// Exclusivity tracks rooms that allow a single connection per user, such as
+// proctored contests, and tells every instance holding another connection
+// of a claiming user to drop it.
+type Exclusivity struct {
+	redis      *redisclient.Client
+	presence   *Manager
+	instanceID string
+	ttl        time.Duration
+	metrics    *metrics.Metrics
+	logger     zerolog.Logger
+
+	cache map[string]exclusiveEntry
+	mu    sync.Mutex
+
+	listeners []func(Claim)
+	pubsub    *goredis.PubSub
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// NewExclusivity keeps exclusivity marks for ttl unless Mark is given an
+// explicit lifetime.
+func NewExclusivity(redis *redisclient.Client, presence *Manager, ttl time.Duration, m *metrics.Metrics, logger zerolog.Logger) *Exclusivity {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Exclusivity{
+		redis:      redis,
+		presence:   presence,
+		instanceID: presence.instanceID,
+		ttl:        ttl,
+		metrics:    m,
+		logger:     logger.With().Str("component", "exclusivity").Logger(),
+		cache:      make(map[string]exclusiveEntry),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// OnClaim registers fn to run for every claim that may affect this
+// instance's connections. It must be called before Start.
+func (e *Exclusivity) OnClaim(fn func(Claim)) {
+	e.listeners = append(e.listeners, fn)
+}
+
+func (e *Exclusivity) Start() error {
+	e.pubsub = e.redis.Subscribe(e.ctx, ExclusiveChannel)
+	if _, err := e.pubsub.Receive(e.ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to exclusivity changes: %w", err)
+	}
+
+	go e.listen()
+	return nil
+}
+
+func (e *Exclusivity) Stop() error {
+	e.cancel()
+	if e.pubsub != nil {
+		return e.pubsub.Close()
+	}
+	return nil
+}
+
+func (e *Exclusivity) listen() {
+	ch := e.pubsub.Channel()
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var m exclusiveMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+				e.logger.Error().Err(err).Msg("Failed to unmarshal exclusivity message")
+				continue
+			}
+			if m.Claim != nil {
+				if m.Claim.InstanceID != e.instanceID {
+					e.apply(*m.Claim)
+				}
+				continue
+			}
+			e.setCached(m.RoomID, m.Exclusive)
+		}
+	}
+}
+
+// Mark makes roomID exclusive, or ordinary again, across the cluster. A
+// zero ttl uses the configured default.
+func (e *Exclusivity) Mark(ctx context.Context, roomID string, exclusive bool, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = e.ttl
+	}
+
+	key := fmt.Sprintf(exclusiveKeyFmt, roomID)
+	var err error
+	if exclusive {
+		err = e.redis.Set(ctx, key, 1, ttl)
+	} else {
+		err = e.redis.Del(ctx, key)
+	}
+	e.metrics.IncRedisOperation("exclusive_mark", metrics.Status(err))
+	if err != nil {
+		return fmt.Errorf("failed to mark room exclusive: %w", err)
+	}
+
+	e.logger.Info().Str("roomId", roomID).Bool("exclusive", exclusive).Msg("Room exclusivity changed")
+	e.setCached(roomID, exclusive)
+	return e.publish(ctx, exclusiveMessage{RoomID: roomID, Exclusive: exclusive})
+}
+
+// IsExclusive reports whether roomID allows one connection per user. Lookup
+// errors are treated as not exclusive.
+func (e *Exclusivity) IsExclusive(ctx context.Context, roomID string) bool {
+	now := time.Now()
+
+	e.mu.Lock()
+	entry, ok := e.cache[roomID]
+	e.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.exclusive
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, exclusiveTimeout)
+	defer cancel()
+
+	_, err := e.redis.Get(ctx, fmt.Sprintf(exclusiveKeyFmt, roomID))
+	exclusive := err == nil
+	if errors.Is(err, goredis.Nil) {
+		err = nil
+	}
+	e.metrics.IncRedisOperation("exclusive_get", metrics.Status(err))
+	if err != nil {
+		e.logger.Error().Err(err).Str("roomId", roomID).Msg("Failed to look up room exclusivity")
+		return false
+	}
+
+	e.setCached(roomID, exclusive)
+	return exclusive
+}
+
+// Claim makes clientID the only connection of userID in roomID. Local
+// listeners run immediately; other instances are only told when the
+// presence hash shows the user connected to them.
+func (e *Exclusivity) Claim(ctx context.Context, roomID, userID, clientID string) {
+	claim := Claim{RoomID: roomID, UserID: userID, ClientID: clientID, InstanceID: e.instanceID}
+	e.apply(claim)
+
+	instances, err := e.presence.GetUserInstances(ctx, userID)
+	if err != nil {
+		e.logger.Error().Err(err).Str("userId", userID).Msg("Failed to look up user instances")
+	} else if !hasOtherInstance(instances, e.instanceID) {
+		return
+	}
+
+	if err := e.publish(ctx, exclusiveMessage{Claim: &claim}); err != nil {
+		e.logger.Error().Err(err).Str("userId", userID).Str("roomId", roomID).Msg("Failed to publish exclusive claim")
+	}
+}
+
+func hasOtherInstance(instances map[string]string, self string) bool {
+	for instance := range instances {
+		if instance != self {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Exclusivity) apply(claim Claim) {
+	for _, fn := range e.listeners {
+		fn(claim)
+	}
+}
+
+func (e *Exclusivity) publish(ctx context.Context, m exclusiveMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	err = e.redis.Publish(ctx, ExclusiveChannel, data)
+	e.metrics.IncRedisOperation("exclusive_publish", metrics.Status(err))
+	return err
+}
+
+func (e *Exclusivity) setCached(roomID string, exclusive bool) {
+	now := time.Now()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cache[roomID] = exclusiveEntry{exclusive: exclusive, expires: now.Add(exclusiveCacheTTL)}
+	for id, entry := range e.cache {
+		if now.After(entry.expires) {
+			delete(e.cache, id)
+		}
+	}
+}
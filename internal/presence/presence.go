@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
 	redisclient "github.com/CDeX-Labs/CDeX-Socket-Service/internal/redis"
 	"github.com/rs/zerolog"
 )
@@ -24,34 +25,60 @@ const (
 type Manager struct {
 	redis      *redisclient.Client
 	instanceID string
+	metrics    *metrics.Metrics
 	logger     zerolog.Logger
+
+	listeners []func(userID string, status Status)
 }
 
-func NewManager(redis *redisclient.Client, instanceID string, logger zerolog.Logger) *Manager {
+func NewManager(redis *redisclient.Client, instanceID string, m *metrics.Metrics, logger zerolog.Logger) *Manager {
 	return &Manager{
 		redis:      redis,
 		instanceID: instanceID,
+		metrics:    m,
 		logger:     logger.With().Str("component", "presence").Logger(),
 	}
 }
 
+// OnChange registers fn to run after this instance records a user as
+// online or offline. It must be called before connections are accepted.
+func (m *Manager) OnChange(fn func(userID string, status Status)) {
+	m.listeners = append(m.listeners, fn)
+}
+
 func (m *Manager) SetOnline(ctx context.Context, userID string) error {
 	key := fmt.Sprintf(presenceKeyFmt, userID)
 	err := m.redis.HSet(ctx, key, m.instanceID, time.Now().Unix())
-	if err != nil {
-		return err
+	if err == nil {
+		err = m.redis.Expire(ctx, key, presenceTTL)
 	}
-	return m.redis.Expire(ctx, key, presenceTTL)
+	m.metrics.IncRedisOperation("presence_set_online", metrics.Status(err))
+	if err == nil {
+		m.notify(userID, StatusOnline)
+	}
+	return err
 }
 
 func (m *Manager) SetOffline(ctx context.Context, userID string) error {
 	key := fmt.Sprintf(presenceKeyFmt, userID)
-	return m.redis.HDel(ctx, key, m.instanceID)
+	err := m.redis.HDel(ctx, key, m.instanceID)
+	m.metrics.IncRedisOperation("presence_set_offline", metrics.Status(err))
+	if err == nil {
+		m.notify(userID, StatusOffline)
+	}
+	return err
+}
+
+func (m *Manager) notify(userID string, status Status) {
+	for _, fn := range m.listeners {
+		fn(userID, status)
+	}
 }
 
 func (m *Manager) IsOnline(ctx context.Context, userID string) (bool, error) {
 	key := fmt.Sprintf(presenceKeyFmt, userID)
 	count, err := m.redis.HLen(ctx, key)
+	m.metrics.IncRedisOperation("presence_is_online", metrics.Status(err))
 	if err != nil {
 		return false, err
 	}
@@ -75,14 +102,35 @@ func (m *Manager) GetOnlineUsers(ctx context.Context, userIDs []string) ([]strin
 
 func (m *Manager) GetUserInstances(ctx context.Context, userID string) (map[string]string, error) {
 	key := fmt.Sprintf(presenceKeyFmt, userID)
-	return m.redis.HGetAll(ctx, key)
+	instances, err := m.redis.HGetAll(ctx, key)
+	m.metrics.IncRedisOperation("presence_get_instances", metrics.Status(err))
+	return instances, err
 }
 
 func (m *Manager) RefreshPresence(ctx context.Context, userID string) error {
 	key := fmt.Sprintf(presenceKeyFmt, userID)
 	err := m.redis.HSet(ctx, key, m.instanceID, time.Now().Unix())
-	if err != nil {
-		return err
+	if err == nil {
+		err = m.redis.Expire(ctx, key, presenceTTL)
 	}
-	return m.redis.Expire(ctx, key, presenceTTL)
+	m.metrics.IncRedisOperation("presence_refresh", metrics.Status(err))
+	return err
+}
+
+// StartRefresh keeps the presence entries of this instance's users from
+// expiring. users returns the users currently connected here.
+func (m *Manager) StartRefresh(users func() []string) {
+	go func() {
+		ticker := time.NewTicker(presenceTTL / 3)
+		defer ticker.Stop()
+		for range ticker.C {
+			ctx := context.Background()
+			for _, userID := range users() {
+				if err := m.RefreshPresence(ctx, userID); err != nil {
+					m.logger.Error().Err(err).Str("userId", userID).Msg("Failed to refresh presence")
+					break
+				}
+			}
+		}
+	}()
 }
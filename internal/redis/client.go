@@ -57,6 +57,11 @@ func (c *Client) Get(ctx context.Context, key string) (string, error) {
 	return c.rdb.Get(ctx, key).Result()
 }
 
+// GetDel returns the value of key and deletes it atomically.
+func (c *Client) GetDel(ctx context.Context, key string) (string, error) {
+	return c.rdb.GetDel(ctx, key).Result()
+}
+
 func (c *Client) Del(ctx context.Context, keys ...string) error {
 	return c.rdb.Del(ctx, keys...).Err()
 }
@@ -65,6 +70,11 @@ func (c *Client) HSet(ctx context.Context, key string, field string, value inter
 	return c.rdb.HSet(ctx, key, field, value).Err()
 }
 
+// HSetAll sets several fields of a hash at once.
+func (c *Client) HSetAll(ctx context.Context, key string, values map[string]interface{}) error {
+	return c.rdb.HSet(ctx, key, values).Err()
+}
+
 func (c *Client) HGet(ctx context.Context, key string, field string) (string, error) {
 	return c.rdb.HGet(ctx, key, field).Result()
 }
@@ -93,10 +103,57 @@ func (c *Client) SMembers(ctx context.Context, key string) ([]string, error) {
 	return c.rdb.SMembers(ctx, key).Result()
 }
 
+func (c *Client) SIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
+	return c.rdb.SIsMember(ctx, key, member).Result()
+}
+
+func (c *Client) SCard(ctx context.Context, key string) (int64, error) {
+	return c.rdb.SCard(ctx, key).Result()
+}
+
+func (c *Client) ZAdd(ctx context.Context, key string, score float64, member string) error {
+	return c.rdb.ZAdd(ctx, key, redis.Z{Score: score, Member: member}).Err()
+}
+
+// ZRange returns the members ranked start to stop, lowest score first.
+func (c *Client) ZRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return c.rdb.ZRange(ctx, key, start, stop).Result()
+}
+
+// ZCount counts the members scored between min and max, which use the
+// Redis range syntax such as "(1700000000000" or "+inf".
+func (c *Client) ZCount(ctx context.Context, key, min, max string) (int64, error) {
+	return c.rdb.ZCount(ctx, key, min, max).Result()
+}
+
+func (c *Client) ZRemRangeByRank(ctx context.Context, key string, start, stop int64) error {
+	return c.rdb.ZRemRangeByRank(ctx, key, start, stop).Err()
+}
+
+// ZRemRangeByScore removes the members scored between min and max, in the
+// same syntax as ZCount.
+func (c *Client) ZRemRangeByScore(ctx context.Context, key, min, max string) error {
+	return c.rdb.ZRemRangeByScore(ctx, key, min, max).Err()
+}
+
 func (c *Client) Expire(ctx context.Context, key string, expiration time.Duration) error {
 	return c.rdb.Expire(ctx, key, expiration).Err()
 }
 
+// Script is a Lua script run with EVALSHA, falling back to EVAL when Redis
+// does not have it cached yet.
+type Script struct {
+	script *redis.Script
+}
+
+func NewScript(src string) *Script {
+	return &Script{script: redis.NewScript(src)}
+}
+
+func (c *Client) RunScript(ctx context.Context, script *Script, keys []string, args ...interface{}) (interface{}, error) {
+	return script.script.Run(ctx, c.rdb, keys, args...).Result()
+}
+
 func (c *Client) Publish(ctx context.Context, channel string, message interface{}) error {
 	return c.rdb.Publish(ctx, channel, message).Err()
 }
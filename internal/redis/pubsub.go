@@ -4,11 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/tracing"
 	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -18,10 +25,15 @@ const (
 )
 
 type PubSubEnvelope struct {
-	SourceInstance string           `json:"sourceInstance"`
+	SourceInstance string            `json:"sourceInstance"`
 	Message        *protocol.Message `json:"message"`
-	TargetRoom     string           `json:"targetRoom,omitempty"`
-	TargetUser     string           `json:"targetUser,omitempty"`
+	TargetRoom     string            `json:"targetRoom,omitempty"`
+	TargetUser     string            `json:"targetUser,omitempty"`
+	// EventTime carries Message.EventTime (Unix ms) across instances.
+	EventTime int64 `json:"eventTime,omitempty"`
+	// TraceContext carries the W3C trace context of the message so the
+	// receiving instance's socket writes join the same trace.
+	TraceContext map[string]string `json:"traceContext,omitempty"`
 }
 
 type MessageHandler func(envelope *PubSubEnvelope)
@@ -31,17 +43,19 @@ type PubSub struct {
 	pubsub     *redis.PubSub
 	instanceID string
 	handler    MessageHandler
+	metrics    *metrics.Metrics
 	logger     zerolog.Logger
 	ctx        context.Context
 	cancel     context.CancelFunc
 }
 
-func NewPubSub(client *Client, handler MessageHandler, logger zerolog.Logger) *PubSub {
+func NewPubSub(client *Client, handler MessageHandler, m *metrics.Metrics, logger zerolog.Logger) *PubSub {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &PubSub{
 		client:     client,
 		instanceID: uuid.New().String()[:8],
 		handler:    handler,
+		metrics:    m,
 		logger:     logger.With().Str("component", "pubsub").Logger(),
 		ctx:        ctx,
 		cancel:     cancel,
@@ -96,8 +110,10 @@ func (p *PubSub) handleMessage(msg *redis.Message) {
 	var envelope PubSubEnvelope
 	if err := json.Unmarshal([]byte(msg.Payload), &envelope); err != nil {
 		p.logger.Error().Err(err).Msg("Failed to unmarshal pubsub message")
+		p.metrics.IncRedisOperation("pubsub_receive", "error")
 		return
 	}
+	p.metrics.IncRedisOperation("pubsub_receive", "ok")
 
 	if envelope.SourceInstance == p.instanceID {
 		return
@@ -108,6 +124,24 @@ func (p *PubSub) handleMessage(msg *redis.Message) {
 		Str("sourceInstance", envelope.SourceInstance).
 		Msg("Received pubsub message")
 
+	if envelope.Message != nil && envelope.EventTime != 0 {
+		envelope.Message.EventTime = time.UnixMilli(envelope.EventTime)
+	}
+
+	if envelope.Message != nil && len(envelope.TraceContext) > 0 {
+		ctx := tracing.Extract(p.ctx, propagation.MapCarrier(envelope.TraceContext))
+		_, span := tracing.Tracer().Start(ctx, msg.Channel+" receive",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				semconv.MessagingSystemKey.String("redis"),
+				semconv.MessagingDestinationName(msg.Channel),
+				attribute.String("ws.source_instance", envelope.SourceInstance),
+			),
+		)
+		envelope.Message.SpanContext = span.SpanContext()
+		span.End()
+	}
+
 	if p.handler != nil {
 		p.handler(&envelope)
 	}
@@ -117,6 +151,8 @@ func (p *PubSub) PublishToRoom(ctx context.Context, roomID string, msg *protocol
 	envelope := PubSubEnvelope{
 		SourceInstance: p.instanceID,
 		Message:        msg,
+		EventTime:      eventTimeMillis(msg),
+		TraceContext:   traceContext(ctx, msg),
 		TargetRoom:     roomID,
 	}
 
@@ -126,13 +162,15 @@ func (p *PubSub) PublishToRoom(ctx context.Context, roomID string, msg *protocol
 	}
 
 	channel := fmt.Sprintf(ChannelRoomFmt, roomID)
-	return p.client.Publish(ctx, channel, data)
+	return p.publish(ctx, channel, data)
 }
 
 func (p *PubSub) PublishToUser(ctx context.Context, userID string, msg *protocol.Message) error {
 	envelope := PubSubEnvelope{
 		SourceInstance: p.instanceID,
 		Message:        msg,
+		EventTime:      eventTimeMillis(msg),
+		TraceContext:   traceContext(ctx, msg),
 		TargetUser:     userID,
 	}
 
@@ -142,13 +180,15 @@ func (p *PubSub) PublishToUser(ctx context.Context, userID string, msg *protocol
 	}
 
 	channel := fmt.Sprintf(ChannelUserFmt, userID)
-	return p.client.Publish(ctx, channel, data)
+	return p.publish(ctx, channel, data)
 }
 
 func (p *PubSub) PublishBroadcast(ctx context.Context, msg *protocol.Message) error {
 	envelope := PubSubEnvelope{
 		SourceInstance: p.instanceID,
 		Message:        msg,
+		EventTime:      eventTimeMillis(msg),
+		TraceContext:   traceContext(ctx, msg),
 	}
 
 	data, err := json.Marshal(envelope)
@@ -156,7 +196,34 @@ func (p *PubSub) PublishBroadcast(ctx context.Context, msg *protocol.Message) er
 		return err
 	}
 
-	return p.client.Publish(ctx, ChannelBroadcast, data)
+	return p.publish(ctx, ChannelBroadcast, data)
+}
+
+func (p *PubSub) publish(ctx context.Context, channel string, data []byte) error {
+	err := p.client.Publish(ctx, channel, data)
+	p.metrics.IncRedisOperation("pubsub_publish", metrics.Status(err))
+	return err
+}
+
+func eventTimeMillis(msg *protocol.Message) int64 {
+	if msg == nil || msg.EventTime.IsZero() {
+		return 0
+	}
+	return msg.EventTime.UnixMilli()
+}
+
+// traceContext serializes the span in ctx, or failing that the span the
+// message was created under.
+func traceContext(ctx context.Context, msg *protocol.Message) map[string]string {
+	if !trace.SpanContextFromContext(ctx).IsValid() && msg != nil {
+		ctx = tracing.WithSpanContext(ctx, msg.SpanContext)
+	}
+	carrier := propagation.MapCarrier{}
+	tracing.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
 }
 
 func (p *PubSub) SubscribeToRoom(roomID string) error {
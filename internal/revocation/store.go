@@ -0,0 +1,266 @@
+package revocation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/metrics"
+	redisclient "github.com/CDeX-Labs/CDeX-Socket-Service/internal/redis"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog"
+)
+
+const (
+	userKeyFmt  = "revoked:user:%s"
+	tokenKeyFmt = "revoked:token:%s"
+	// Channel carries every revocation so all instances disconnect the
+	// affected connections and drop their cached entries.
+	Channel = "ws:revocations"
+
+	defaultTTL      = 24 * time.Hour
+	defaultCacheTTL = 30 * time.Second
+	lookupTimeout   = 2 * time.Second
+)
+
+// Revocation invalidates either every token of a user issued up to
+// RevokedAt or, when TokenID is set, the single token with that jti.
+type Revocation struct {
+	UserID    string `json:"userId"`
+	TokenID   string `json:"tokenId,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	RevokedAt int64  `json:"revokedAt"` // Unix ms
+}
+
+// Config tunes the store. TTL must be at least the longest token lifetime,
+// since a revocation is forgotten once it expires.
+type Config struct {
+	TTL      time.Duration
+	CacheTTL time.Duration
+}
+
+type cacheEntry struct {
+	revokedAt int64 // 0 when not revoked
+	expires   time.Time
+}
+
+// Store records revocations in Redis and caches lookups locally so that
+// checking every connection stays cheap.
+type Store struct {
+	redis   *redisclient.Client
+	config  Config
+	metrics *metrics.Metrics
+	logger  zerolog.Logger
+
+	users  map[string]cacheEntry
+	tokens map[string]cacheEntry
+	mu     sync.Mutex
+
+	listeners []func(Revocation)
+	pubsub    *goredis.PubSub
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+func NewStore(redis *redisclient.Client, config Config, m *metrics.Metrics, logger zerolog.Logger) *Store {
+	if config.TTL <= 0 {
+		config.TTL = defaultTTL
+	}
+	if config.CacheTTL <= 0 {
+		config.CacheTTL = defaultCacheTTL
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Store{
+		redis:   redis,
+		config:  config,
+		metrics: m,
+		logger:  logger.With().Str("component", "revocation").Logger(),
+		users:   make(map[string]cacheEntry),
+		tokens:  make(map[string]cacheEntry),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// OnRevoke registers fn to run for every revocation, whether it was issued
+// on this instance or received from another one. It must be called before
+// Start.
+func (s *Store) OnRevoke(fn func(Revocation)) {
+	s.listeners = append(s.listeners, fn)
+}
+
+func (s *Store) Start() error {
+	s.pubsub = s.redis.Subscribe(s.ctx, Channel)
+	if _, err := s.pubsub.Receive(s.ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to revocations: %w", err)
+	}
+
+	go s.listen()
+	return nil
+}
+
+func (s *Store) Stop() error {
+	s.cancel()
+	if s.pubsub != nil {
+		return s.pubsub.Close()
+	}
+	return nil
+}
+
+func (s *Store) listen() {
+	ch := s.pubsub.Channel()
+	ticker := time.NewTicker(s.config.CacheTTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.prune()
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var rev Revocation
+			if err := json.Unmarshal([]byte(msg.Payload), &rev); err != nil {
+				s.logger.Error().Err(err).Msg("Failed to unmarshal revocation")
+				continue
+			}
+			s.apply(rev)
+		}
+	}
+}
+
+// Revoke persists rev, applies it locally and announces it to the other
+// instances.
+func (s *Store) Revoke(ctx context.Context, rev Revocation) error {
+	if rev.UserID == "" {
+		return errors.New("userId is required")
+	}
+	if rev.RevokedAt == 0 {
+		rev.RevokedAt = time.Now().UnixMilli()
+	}
+
+	key := fmt.Sprintf(userKeyFmt, rev.UserID)
+	if rev.TokenID != "" {
+		key = fmt.Sprintf(tokenKeyFmt, rev.TokenID)
+	}
+	err := s.redis.Set(ctx, key, rev.RevokedAt, s.config.TTL)
+	s.metrics.IncRedisOperation("revocation_set", metrics.Status(err))
+	if err != nil {
+		return fmt.Errorf("failed to store revocation: %w", err)
+	}
+
+	s.logger.Info().
+		Str("userId", rev.UserID).
+		Str("tokenId", rev.TokenID).
+		Str("reason", rev.Reason).
+		Msg("Session revoked")
+
+	s.apply(rev)
+
+	data, err := json.Marshal(rev)
+	if err != nil {
+		return err
+	}
+	err = s.redis.Publish(ctx, Channel, data)
+	s.metrics.IncRedisOperation("revocation_publish", metrics.Status(err))
+	if err != nil {
+		return fmt.Errorf("failed to publish revocation: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) apply(rev Revocation) {
+	entry := cacheEntry{revokedAt: rev.RevokedAt, expires: time.Now().Add(s.config.CacheTTL)}
+
+	s.mu.Lock()
+	if rev.TokenID != "" {
+		s.tokens[rev.TokenID] = entry
+	} else if current, ok := s.users[rev.UserID]; !ok || current.revokedAt < rev.RevokedAt {
+		s.users[rev.UserID] = entry
+	}
+	s.mu.Unlock()
+
+	for _, fn := range s.listeners {
+		fn(rev)
+	}
+}
+
+// IsRevoked reports whether a token of userID with the given jti and iat has
+// been revoked. A token without iat is revoked by any user revocation. The
+// times are compared in milliseconds, so a token issued later within the
+// second of a revocation stays valid when its iat carries them.
+func (s *Store) IsRevoked(ctx context.Context, userID, tokenID string, issuedAt time.Time) (bool, error) {
+	if tokenID != "" {
+		revokedAt, err := s.lookup(ctx, s.tokens, tokenKeyFmt, tokenID)
+		if err != nil {
+			return false, err
+		}
+		if revokedAt != 0 {
+			return true, nil
+		}
+	}
+
+	revokedAt, err := s.lookup(ctx, s.users, userKeyFmt, userID)
+	if err != nil || revokedAt == 0 {
+		return false, err
+	}
+	return issuedAt.IsZero() || issuedAt.UnixMilli() <= revokedAt, nil
+}
+
+func (s *Store) lookup(ctx context.Context, cache map[string]cacheEntry, keyFmt, id string) (int64, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	entry, ok := cache[id]
+	s.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.revokedAt, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	var revokedAt int64
+	value, err := s.redis.Get(ctx, fmt.Sprintf(keyFmt, id))
+	switch {
+	case errors.Is(err, goredis.Nil):
+		err = nil
+	case err == nil:
+		if _, scanErr := fmt.Sscan(value, &revokedAt); scanErr != nil {
+			s.logger.Warn().Err(scanErr).Str("id", id).Msg("Ignoring malformed revocation entry")
+		}
+	}
+	s.metrics.IncRedisOperation("revocation_get", metrics.Status(err))
+	if err != nil {
+		s.logger.Error().Err(err).Str("id", id).Msg("Failed to look up revocation")
+		return 0, err
+	}
+
+	s.mu.Lock()
+	cache[id] = cacheEntry{revokedAt: revokedAt, expires: now.Add(s.config.CacheTTL)}
+	s.mu.Unlock()
+	return revokedAt, nil
+}
+
+func (s *Store) prune() {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, entry := range s.users {
+		if now.After(entry.expires) {
+			delete(s.users, id)
+		}
+	}
+	for id, entry := range s.tokens {
+		if now.After(entry.expires) {
+			delete(s.tokens, id)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package routing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/auth"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Dispatcher delivers routed messages; *hub.Hub satisfies it.
+type Dispatcher interface {
+	// SendToAudience delivers msg once per client however many of the
+	// rooms and users it matches.
+	SendToAudience(rooms, users []string, msg *protocol.Message)
+	SendToRoomWithPermission(roomID string, perm auth.Permission, msg *protocol.Message)
+	Broadcast(msg *protocol.Message)
+}
+
+// Resolver answers the contest lookups of team, contest and frozen targets.
+type Resolver interface {
+	// TeamRoom returns the team room of userID in contestID, or "" if the
+	// user is on no team.
+	TeamRoom(ctx context.Context, contestID, userID string) (string, error)
+	// ContestAudience returns who may see contestID: everyone when
+	// broadcast is set, otherwise rooms and users.
+	ContestAudience(ctx context.Context, contestID string) (broadcast bool, rooms, users []string)
+	// Frozen reports whether the results of contestID are frozen.
+	Frozen(contestID string) bool
+}
+
+// Router applies a routing Table to incoming events and reloads the table
+// whenever the backing file changes.
+type Router struct {
+	path       string
+	dispatcher Dispatcher
+	resolver   Resolver
+	validate   func(*Table) error
+	logger     zerolog.Logger
+
+	table   *Table
+	modTime time.Time
+	size    int64
+	mu      sync.RWMutex
+
+	stop chan struct{}
+}
+
+func NewRouter(path string, dispatcher Dispatcher, logger zerolog.Logger) (*Router, error) {
+	r := &Router{
+		path:       path,
+		dispatcher: dispatcher,
+		logger:     logger.With().Str("component", "routing").Logger(),
+		stop:       make(chan struct{}),
+	}
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetResolver enables team, contest and frozen targets; without it they
+// reach no one and frozen conditions only look at the event. It must be
+// called before events are dispatched.
+func (r *Router) SetResolver(resolver Resolver) {
+	r.resolver = resolver
+}
+
+// SetValidator adds a check that every table must pass, on top of its own
+// validation, before it is loaded. The current table is checked right away
+// and the error is returned if it fails; a later file that fails is not
+// loaded. It must be called before Watch.
+func (r *Router) SetValidator(validate func(*Table) error) error {
+	r.validate = validate
+	return validate(r.current())
+}
+
+// Watch polls the routing file every interval and swaps in the new table
+// when it changes. A file that fails to parse is logged and ignored so the
+// previous table stays active.
+func (r *Router) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if !r.changed() {
+					continue
+				}
+				if err := r.reload(); err != nil {
+					r.logger.Error().Err(err).Str("path", r.path).Msg("Failed to reload routing table, keeping previous")
+				}
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (r *Router) Stop() {
+	close(r.stop)
+}
+
+func (r *Router) changed() bool {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !info.ModTime().Equal(r.modTime) || info.Size() != r.size
+}
+
+func (r *Router) reload() error {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to stat routing file: %w", err)
+	}
+
+	table, err := LoadTable(r.path)
+	if err != nil {
+		return err
+	}
+	if r.validate != nil {
+		if err := r.validate(table); err != nil {
+			return fmt.Errorf("invalid routing table: %w", err)
+		}
+	}
+
+	r.mu.Lock()
+	previous := r.table
+	r.table = table
+	r.modTime = info.ModTime()
+	r.size = info.Size()
+	r.mu.Unlock()
+
+	if previous != nil {
+		known := make(map[string]bool, len(previous.Routes))
+		for _, topic := range previous.Topics() {
+			known[topic] = true
+		}
+		for _, topic := range table.Topics() {
+			if !known[topic] {
+				r.logger.Warn().Str("topic", topic).Msg("Route added for new topic; restart to subscribe to it")
+			}
+		}
+	}
+
+	r.logger.Info().Str("path", r.path).Int("routes", len(table.Routes)).Msg("Routing table loaded")
+	return nil
+}
+
+func (r *Router) current() *Table {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.table
+}
+
+// Topics returns the topics routed by the currently loaded table.
+func (r *Router) Topics() []string {
+	return r.current().Topics()
+}
+
+func (r *Router) HasRoute(topic string) bool {
+	return r.current().Route(topic) != nil
+}
+
+// Dispatch routes a raw event payload received on topic. eventTime is the
+// Kafka timestamp of the event and, like the span in ctx, is carried on the
+// outbound message.
+func (r *Router) Dispatch(ctx context.Context, topic string, value []byte, eventTime time.Time) error {
+	route := r.current().Route(topic)
+	if route == nil {
+		return fmt.Errorf("no route for topic %s", topic)
+	}
+
+	var event map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&event); err != nil {
+		r.logger.Error().Err(err).Str("topic", topic).Msg("Failed to unmarshal routed event")
+		return err
+	}
+
+	payload, err := route.BuildPayload(event, value)
+	if err != nil {
+		return err
+	}
+
+	msg := &protocol.Message{
+		Type:        route.Type,
+		Payload:     payload,
+		Timestamp:   time.Now().UnixMilli(),
+		EventTime:   eventTime,
+		SpanContext: trace.SpanContextFromContext(ctx),
+	}
+
+	r.logger.Info().
+		Str("topic", topic).
+		Str("type", string(route.Type)).
+		Msg("Routing event")
+
+	// Targets are collected first so a client matched by several of them
+	// receives the message once; a broadcast already reaches everyone.
+	var frozen func(string) bool
+	if r.resolver != nil {
+		frozen = r.resolver.Frozen
+	}
+	var rooms, users []string
+	restricted := make(map[string]auth.Permission)
+	for _, target := range route.Targets {
+		if (target.When != nil && !target.When.holds(event, frozen)) ||
+			(target.Unless != nil && target.Unless.holds(event, frozen)) {
+			continue
+		}
+
+		switch {
+		case target.Broadcast:
+			r.dispatcher.Broadcast(msg)
+			return nil
+		case target.User != "":
+			userID, err := EvalString(target.User, event)
+			if err != nil {
+				r.logger.Debug().Err(err).Str("topic", topic).Msg("Skipping user target")
+				continue
+			}
+			users = append(users, userID)
+		case target.Room != "":
+			roomID, err := EvalString(target.Room, event)
+			if err != nil {
+				r.logger.Debug().Err(err).Str("topic", topic).Msg("Skipping room target")
+				continue
+			}
+			if target.Permission != "" {
+				restricted[roomID] = target.Permission
+				continue
+			}
+			rooms = append(rooms, roomID)
+		case target.Team != nil:
+			roomID, err := r.teamRoom(ctx, target.Team, event)
+			if err != nil {
+				r.logger.Debug().Err(err).Str("topic", topic).Msg("Skipping team target")
+				continue
+			}
+			if roomID != "" {
+				rooms = append(rooms, roomID)
+			}
+		case target.Contest != "":
+			contestID, err := EvalString(target.Contest, event)
+			if err != nil || r.resolver == nil {
+				r.logger.Debug().Err(err).Str("topic", topic).Msg("Skipping contest target")
+				continue
+			}
+			broadcast, contestRooms, contestUsers := r.resolver.ContestAudience(ctx, contestID)
+			if broadcast {
+				r.dispatcher.Broadcast(msg)
+				return nil
+			}
+			rooms = append(rooms, contestRooms...)
+			users = append(users, contestUsers...)
+		}
+	}
+
+	for roomID, perm := range restricted {
+		r.dispatcher.SendToRoomWithPermission(roomID, perm, msg)
+	}
+	if len(rooms) > 0 || len(users) > 0 {
+		r.dispatcher.SendToAudience(rooms, users, msg)
+	}
+	return nil
+}
+
+func (r *Router) teamRoom(ctx context.Context, team *TeamTarget, event map[string]interface{}) (string, error) {
+	if r.resolver == nil {
+		return "", nil
+	}
+	contestID, err := EvalString(team.Contest, event)
+	if err != nil {
+		return "", err
+	}
+	userID, err := EvalString(team.User, event)
+	if err != nil {
+		return "", err
+	}
+	return r.resolver.TeamRoom(ctx, contestID, userID)
+}
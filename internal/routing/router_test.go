@@ -0,0 +1,359 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/auth"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+	"github.com/rs/zerolog"
+)
+
+type delivered struct {
+	rooms     []string
+	users     []string
+	broadcast bool
+	perm      auth.Permission
+	msg       *protocol.Message
+}
+
+type recordingDispatcher struct {
+	sent []delivered
+}
+
+func (d *recordingDispatcher) SendToAudience(rooms, users []string, msg *protocol.Message) {
+	d.sent = append(d.sent, delivered{rooms: rooms, users: users, msg: msg})
+}
+
+func (d *recordingDispatcher) SendToRoomWithPermission(roomID string, perm auth.Permission, msg *protocol.Message) {
+	d.sent = append(d.sent, delivered{rooms: []string{roomID}, perm: perm, msg: msg})
+}
+
+func (d *recordingDispatcher) Broadcast(msg *protocol.Message) {
+	d.sent = append(d.sent, delivered{broadcast: true, msg: msg})
+}
+
+func newTestRouter(t *testing.T, table string) (*Router, *recordingDispatcher) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	if err := os.WriteFile(path, []byte(table), 0o600); err != nil {
+		t.Fatalf("write routing file: %v", err)
+	}
+	dispatcher := &recordingDispatcher{}
+	router, err := NewRouter(path, dispatcher, zerolog.Nop())
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	return router, dispatcher
+}
+
+const testTable = `routes:
+  - topic: submission.judged
+    type: SUBMISSION_RESULT
+    targets:
+      - user: $.userId
+      - room: contest:$.contestId
+      - room: team:$.contestId:$.teamId
+  - topic: problem.published
+    type: PROBLEM_PUBLISHED
+    fields:
+      problemId: $.problemId
+    targets:
+      - user: $.authorId
+      - broadcast: true
+`
+
+func TestRouterDispatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		topic string
+		event string
+		want  delivered
+		none  bool
+	}{
+		{
+			name:  "all targets",
+			topic: "submission.judged",
+			event: `{"userId":"u1","contestId":"c1","teamId":"t1"}`,
+			want:  delivered{rooms: []string{"contest:c1", "team:c1:t1"}, users: []string{"u1"}},
+		},
+		{
+			name:  "targets with missing fields skipped",
+			topic: "submission.judged",
+			event: `{"userId":"u1","contestId":"c1"}`,
+			want:  delivered{rooms: []string{"contest:c1"}, users: []string{"u1"}},
+		},
+		{
+			name:  "no target resolves",
+			topic: "submission.judged",
+			event: `{"verdict":"AC"}`,
+			none:  true,
+		},
+		{
+			name:  "broadcast replaces other targets",
+			topic: "problem.published",
+			event: `{"problemId":"p1","authorId":"u1"}`,
+			want:  delivered{broadcast: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, dispatcher := newTestRouter(t, testTable)
+			eventTime := time.UnixMilli(1700000000000)
+
+			if err := router.Dispatch(context.Background(), tt.topic, []byte(tt.event), eventTime); err != nil {
+				t.Fatalf("Dispatch: %v", err)
+			}
+			if tt.none {
+				if len(dispatcher.sent) != 0 {
+					t.Fatalf("sent %d messages, want none", len(dispatcher.sent))
+				}
+				return
+			}
+			if len(dispatcher.sent) != 1 {
+				t.Fatalf("sent %d messages, want 1", len(dispatcher.sent))
+			}
+
+			got := dispatcher.sent[0]
+			if got.broadcast != tt.want.broadcast || !reflect.DeepEqual(got.rooms, tt.want.rooms) || !reflect.DeepEqual(got.users, tt.want.users) {
+				t.Errorf("delivered to rooms %v users %v broadcast %v, want rooms %v users %v broadcast %v",
+					got.rooms, got.users, got.broadcast, tt.want.rooms, tt.want.users, tt.want.broadcast)
+			}
+			if !got.msg.EventTime.Equal(eventTime) {
+				t.Errorf("EventTime = %v, want %v", got.msg.EventTime, eventTime)
+			}
+		})
+	}
+}
+
+func TestRouterDispatchErrors(t *testing.T) {
+	router, dispatcher := newTestRouter(t, testTable)
+
+	if err := router.Dispatch(context.Background(), "unknown.topic", []byte(`{}`), time.Time{}); err == nil {
+		t.Error("Dispatch of an unrouted topic succeeded")
+	}
+	if err := router.Dispatch(context.Background(), "submission.judged", []byte(`not json`), time.Time{}); err == nil {
+		t.Error("Dispatch of a malformed event succeeded")
+	}
+	if len(dispatcher.sent) != 0 {
+		t.Errorf("sent %d messages, want none", len(dispatcher.sent))
+	}
+}
+
+func TestRouterReload(t *testing.T) {
+	router, _ := newTestRouter(t, testTable)
+	if !router.HasRoute("submission.judged") {
+		t.Fatal("initial table not loaded")
+	}
+
+	replaced := "routes:\n  - topic: user.notification\n    type: NOTIFICATION\n    targets:\n      - user: $.userId\n"
+	if err := os.WriteFile(router.path, []byte(replaced), 0o600); err != nil {
+		t.Fatalf("rewrite routing file: %v", err)
+	}
+	if !router.changed() {
+		t.Fatal("rewritten file not detected")
+	}
+	if err := router.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if router.HasRoute("submission.judged") || !router.HasRoute("user.notification") {
+		t.Errorf("topics after reload = %v", router.Topics())
+	}
+
+	if err := os.WriteFile(router.path, []byte("routes: ["), 0o600); err != nil {
+		t.Fatalf("rewrite routing file: %v", err)
+	}
+	if err := router.reload(); err == nil {
+		t.Fatal("malformed table was loaded")
+	}
+	if !router.HasRoute("user.notification") {
+		t.Error("previous table dropped after a failed reload")
+	}
+}
+
+type fakeResolver struct {
+	teams  map[string]string
+	public map[string]bool
+	frozen map[string]bool
+}
+
+func (f fakeResolver) TeamRoom(_ context.Context, contestID, userID string) (string, error) {
+	if teamID, ok := f.teams[userID]; ok {
+		return "team:" + contestID + ":" + teamID, nil
+	}
+	return "", nil
+}
+
+func (f fakeResolver) ContestAudience(_ context.Context, contestID string) (bool, []string, []string) {
+	if f.public[contestID] {
+		return true, nil, nil
+	}
+	return false, []string{"contest:" + contestID, "staff:" + contestID}, []string{"invited"}
+}
+
+func (f fakeResolver) Frozen(contestID string) bool {
+	return f.frozen[contestID]
+}
+
+const resolvedTable = `routes:
+  - topic: submission.judged
+    type: SUBMISSION_RESULT
+    targets:
+      - user: $.userId
+      - team: {contest: $.contestId, user: $.userId}
+  - topic: leaderboard.updated
+    type: LEADERBOARD_UPDATE
+    targets:
+      - room: contest:$.contestId
+        permission: leaderboard:view_frozen
+        when: {field: $.frozen, frozen: $.contestId}
+      - room: contest:$.contestId
+        unless: {field: $.frozen, frozen: $.contestId}
+  - topic: contest.started
+    type: CONTEST_EVENT
+    targets:
+      - contest: $.contestId
+`
+
+func TestRouterDispatchResolved(t *testing.T) {
+	resolver := fakeResolver{
+		teams:  map[string]string{"u1": "t1"},
+		public: map[string]bool{"open": true},
+		frozen: map[string]bool{"cold": true},
+	}
+
+	tests := []struct {
+		name  string
+		topic string
+		event string
+		want  delivered
+	}{
+		{
+			name:  "team member",
+			topic: "submission.judged",
+			event: `{"userId":"u1","contestId":"c1"}`,
+			want:  delivered{rooms: []string{"team:c1:t1"}, users: []string{"u1"}},
+		},
+		{
+			name:  "user on no team",
+			topic: "submission.judged",
+			event: `{"userId":"u2","contestId":"c1"}`,
+			want:  delivered{users: []string{"u2"}},
+		},
+		{
+			name:  "live leaderboard",
+			topic: "leaderboard.updated",
+			event: `{"contestId":"c1"}`,
+			want:  delivered{rooms: []string{"contest:c1"}},
+		},
+		{
+			name:  "frozen flag on the event",
+			topic: "leaderboard.updated",
+			event: `{"contestId":"c1","frozen":true}`,
+			want:  delivered{rooms: []string{"contest:c1"}, perm: auth.PermViewFrozenResults},
+		},
+		{
+			name:  "contest frozen in the registry",
+			topic: "leaderboard.updated",
+			event: `{"contestId":"cold"}`,
+			want:  delivered{rooms: []string{"contest:cold"}, perm: auth.PermViewFrozenResults},
+		},
+		{
+			name:  "private contest",
+			topic: "contest.started",
+			event: `{"contestId":"c1"}`,
+			want:  delivered{rooms: []string{"contest:c1", "staff:c1"}, users: []string{"invited"}},
+		},
+		{
+			name:  "public contest",
+			topic: "contest.started",
+			event: `{"contestId":"open"}`,
+			want:  delivered{broadcast: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, dispatcher := newTestRouter(t, resolvedTable)
+			router.SetResolver(resolver)
+
+			if err := router.Dispatch(context.Background(), tt.topic, []byte(tt.event), time.Time{}); err != nil {
+				t.Fatalf("Dispatch: %v", err)
+			}
+			if len(dispatcher.sent) != 1 {
+				t.Fatalf("sent %d messages, want 1", len(dispatcher.sent))
+			}
+
+			got := dispatcher.sent[0]
+			if got.broadcast != tt.want.broadcast || got.perm != tt.want.perm ||
+				!reflect.DeepEqual(got.rooms, tt.want.rooms) || !reflect.DeepEqual(got.users, tt.want.users) {
+				t.Errorf("delivered to rooms %v users %v broadcast %v perm %q, want rooms %v users %v broadcast %v perm %q",
+					got.rooms, got.users, got.broadcast, got.perm, tt.want.rooms, tt.want.users, tt.want.broadcast, tt.want.perm)
+			}
+		})
+	}
+}
+
+func TestRouterDispatchWithoutResolver(t *testing.T) {
+	router, dispatcher := newTestRouter(t, resolvedTable)
+
+	if err := router.Dispatch(context.Background(), "contest.started", []byte(`{"contestId":"c1"}`), time.Time{}); err != nil {
+		t.Fatalf("Dispatch: %v", err)
+	}
+	if len(dispatcher.sent) != 0 {
+		t.Errorf("sent %d messages, want none", len(dispatcher.sent))
+	}
+}
+
+func TestRouterValidator(t *testing.T) {
+	router, _ := newTestRouter(t, testTable)
+	refuseBroadcast := func(table *Table) error {
+		for _, route := range table.Routes {
+			for _, target := range route.Targets {
+				if target.Broadcast {
+					return errors.New("broadcast refused")
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := router.SetValidator(refuseBroadcast); err == nil {
+		t.Fatal("SetValidator accepted a table with a broadcast")
+	}
+
+	replaced := "routes:\n  - topic: user.notification\n    type: NOTIFICATION\n    targets:\n      - user: $.userId\n"
+	if err := os.WriteFile(router.path, []byte(replaced), 0o600); err != nil {
+		t.Fatalf("rewrite routing file: %v", err)
+	}
+	if err := router.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+
+	refused := replaced + "  - topic: problem.published\n    type: PROBLEM_PUBLISHED\n    targets:\n      - broadcast: true\n"
+	if err := os.WriteFile(router.path, []byte(refused), 0o600); err != nil {
+		t.Fatalf("rewrite routing file: %v", err)
+	}
+	if err := router.reload(); err == nil {
+		t.Fatal("reload accepted a refused table")
+	}
+	if router.HasRoute("problem.published") || !router.HasRoute("user.notification") {
+		t.Errorf("topics after a refused reload = %v", router.Topics())
+	}
+}
+
+func TestExampleTable(t *testing.T) {
+	table, err := LoadTable(filepath.Join("..", "..", "config", "routes.example.yaml"))
+	if err != nil {
+		t.Fatalf("LoadTable: %v", err)
+	}
+	if len(table.Routes) == 0 {
+		t.Error("example table has no routes")
+	}
+}
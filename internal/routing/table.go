@@ -0,0 +1,290 @@
+package routing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/CDeX-Labs/CDeX-Socket-Service/internal/auth"
+	"github.com/CDeX-Labs/CDeX-Socket-Service/pkg/protocol"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrMissingField = errors.New("referenced field is missing or empty")
+)
+
+// exprPattern matches a field reference such as $.userId or $.details.type.
+var exprPattern = regexp.MustCompile(`\$\.[A-Za-z_][A-Za-z0-9_]*(?:\.[A-Za-z_][A-Za-z0-9_]*)*`)
+
+// Table is a parsed routing file.
+type Table struct {
+	Routes []Route `yaml:"routes"`
+
+	byTopic map[string]*Route
+}
+
+// Route describes how one Kafka topic is turned into a socket message.
+// When Fields is empty the event payload is forwarded unchanged; otherwise
+// it is projected into a new object whose values may reference event fields.
+type Route struct {
+	Topic   string                 `yaml:"topic"`
+	Type    protocol.MessageType   `yaml:"type"`
+	Fields  map[string]interface{} `yaml:"fields"`
+	Targets []Target               `yaml:"targets"`
+}
+
+// Target is one audience for a routed message. Exactly one of User, Room,
+// Team, Contest or Broadcast must be set. User, Room and Contest are
+// expressions, e.g. "$.userId" or "contest:$.contestId"; the target is
+// skipped when a referenced field is missing or empty.
+type Target struct {
+	User      string `yaml:"user"`
+	Room      string `yaml:"room"`
+	Broadcast bool   `yaml:"broadcast"`
+	// Team is the team room of a contest participant; users on no team
+	// are skipped.
+	Team *TeamTarget `yaml:"team"`
+	// Contest is everyone who may see the contest: every client for public
+	// contests, otherwise its contest, staff and organization rooms, its
+	// participants and its invited users.
+	Contest string `yaml:"contest"`
+	// Permission restricts a Room target to the members holding it.
+	Permission auth.Permission `yaml:"permission"`
+	// When and Unless make the target conditional.
+	When   *Condition `yaml:"when"`
+	Unless *Condition `yaml:"unless"`
+}
+
+// TeamTarget names the contest and user whose team room is targeted.
+type TeamTarget struct {
+	Contest string `yaml:"contest"`
+	User    string `yaml:"user"`
+}
+
+// Condition holds when any of its checks does. Field is a single reference
+// that holds when it is true, a non-zero number or a non-empty string;
+// Frozen is a contest ID expression that holds while the contest's results
+// are frozen.
+type Condition struct {
+	Field  string `yaml:"field"`
+	Frozen string `yaml:"frozen"`
+}
+
+func LoadTable(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routing file: %w", err)
+	}
+	return ParseTable(data)
+}
+
+func ParseTable(data []byte) (*Table, error) {
+	var table Table
+	if err := yaml.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("failed to parse routing file: %w", err)
+	}
+
+	table.byTopic = make(map[string]*Route, len(table.Routes))
+	for i := range table.Routes {
+		route := &table.Routes[i]
+		if err := route.validate(); err != nil {
+			return nil, fmt.Errorf("route %d: %w", i, err)
+		}
+		if _, exists := table.byTopic[route.Topic]; exists {
+			return nil, fmt.Errorf("route %d: duplicate topic %q", i, route.Topic)
+		}
+		table.byTopic[route.Topic] = route
+	}
+
+	return &table, nil
+}
+
+func (t *Table) Route(topic string) *Route {
+	return t.byTopic[topic]
+}
+
+func (t *Table) Topics() []string {
+	topics := make([]string, 0, len(t.Routes))
+	for _, route := range t.Routes {
+		topics = append(topics, route.Topic)
+	}
+	return topics
+}
+
+func (r *Route) validate() error {
+	if r.Topic == "" {
+		return errors.New("topic is required")
+	}
+	if r.Type == "" {
+		return fmt.Errorf("topic %q: type is required", r.Topic)
+	}
+	if len(r.Targets) == 0 {
+		return fmt.Errorf("topic %q: at least one target is required", r.Topic)
+	}
+	for i, target := range r.Targets {
+		if err := target.validate(); err != nil {
+			return fmt.Errorf("topic %q: target %d: %w", r.Topic, i, err)
+		}
+	}
+	return nil
+}
+
+func (t Target) validate() error {
+	set := 0
+	for _, isSet := range []bool{t.User != "", t.Room != "", t.Broadcast, t.Team != nil, t.Contest != ""} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("must set exactly one of user, room, team, contest or broadcast")
+	}
+	if t.Team != nil && (t.Team.Contest == "" || t.Team.User == "") {
+		return errors.New("team needs contest and user")
+	}
+	if t.Permission != "" && t.Room == "" {
+		return errors.New("permission only applies to room targets")
+	}
+	for _, c := range []*Condition{t.When, t.Unless} {
+		if c == nil {
+			continue
+		}
+		if c.Field == "" && c.Frozen == "" {
+			return errors.New("condition needs field or frozen")
+		}
+		if c.Field != "" && !isReference(c.Field) {
+			return fmt.Errorf("condition field %q must be a single reference", c.Field)
+		}
+	}
+	return nil
+}
+
+// isReference reports whether expr is exactly one field reference.
+func isReference(expr string) bool {
+	loc := exprPattern.FindStringIndex(expr)
+	return loc != nil && loc[0] == 0 && loc[1] == len(expr)
+}
+
+// holds evaluates c against event; frozen answers the Frozen check and may
+// be nil.
+func (c *Condition) holds(event map[string]interface{}, frozen func(contestID string) bool) bool {
+	if c.Field != "" {
+		if value, ok := lookup(event, c.Field); ok && truthy(value) {
+			return true
+		}
+	}
+	if c.Frozen != "" && frozen != nil {
+		if contestID, err := EvalString(c.Frozen, event); err == nil && frozen(contestID) {
+			return true
+		}
+	}
+	return false
+}
+
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case json.Number:
+		f, err := v.Float64()
+		return err == nil && f != 0
+	}
+	return value != nil
+}
+
+// BuildPayload returns the outbound payload for an event.
+func (r *Route) BuildPayload(event map[string]interface{}, raw []byte) (json.RawMessage, error) {
+	if len(r.Fields) == 0 {
+		return json.RawMessage(raw), nil
+	}
+	return json.Marshal(project(r.Fields, event))
+}
+
+func project(fields map[string]interface{}, event map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		switch v := value.(type) {
+		case string:
+			out[key] = evalValue(v, event)
+		case map[string]interface{}:
+			out[key] = project(v, event)
+		default:
+			out[key] = v
+		}
+	}
+	return out
+}
+
+// evalValue resolves an expression used as a payload value. A string that is
+// exactly one reference yields the referenced value with its original type;
+// anything else is interpolated as a string.
+func evalValue(expr string, event map[string]interface{}) interface{} {
+	if isReference(expr) {
+		value, _ := lookup(event, expr)
+		return value
+	}
+	return exprPattern.ReplaceAllStringFunc(expr, func(ref string) string {
+		value, _ := lookup(event, ref)
+		if value == nil {
+			return ""
+		}
+		return stringify(value)
+	})
+}
+
+// EvalString interpolates every reference in expr, failing if any of them
+// resolves to a missing or empty value.
+func EvalString(expr string, event map[string]interface{}) (string, error) {
+	var missing error
+	result := exprPattern.ReplaceAllStringFunc(expr, func(ref string) string {
+		value, ok := lookup(event, ref)
+		s := ""
+		if ok && value != nil {
+			s = stringify(value)
+		}
+		if s == "" && missing == nil {
+			missing = fmt.Errorf("%w: %s", ErrMissingField, ref)
+		}
+		return s
+	})
+	if missing != nil {
+		return "", missing
+	}
+	return result, nil
+}
+
+func lookup(event map[string]interface{}, ref string) (interface{}, bool) {
+	var current interface{} = event
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "$."), ".") {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = obj[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func stringify(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+}
@@ -0,0 +1,177 @@
+package routing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func decodeEvent(t *testing.T, raw string) map[string]interface{} {
+	t.Helper()
+	var event map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&event); err != nil {
+		t.Fatalf("decode event: %v", err)
+	}
+	return event
+}
+
+func TestParseTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			yaml: `routes:
+  - topic: a
+    type: A
+    targets:
+      - user: $.userId
+      - room: contest:$.contestId
+      - broadcast: true
+      - team: {contest: $.contestId, user: $.userId}
+      - contest: $.contestId
+      - room: staff:$.contestId
+        permission: leaderboard:view_frozen
+        when: {field: $.frozen, frozen: $.contestId}
+        unless: {field: $.hidden}`,
+		},
+		{"missing topic", "routes:\n  - type: A\n    targets:\n      - broadcast: true", "topic is required"},
+		{"missing type", "routes:\n  - topic: a\n    targets:\n      - broadcast: true", "type is required"},
+		{"no targets", "routes:\n  - topic: a\n    type: A", "at least one target"},
+		{"empty target", "routes:\n  - topic: a\n    type: A\n    targets:\n      - {}", "exactly one of"},
+		{"two audiences", "routes:\n  - topic: a\n    type: A\n    targets:\n      - user: $.u\n        room: r", "exactly one of"},
+		{
+			name:    "duplicate topic",
+			yaml:    "routes:\n  - topic: a\n    type: A\n    targets:\n      - broadcast: true\n  - topic: a\n    type: B\n    targets:\n      - broadcast: true",
+			wantErr: "duplicate topic",
+		},
+		{"team without user", "routes:\n  - topic: a\n    type: A\n    targets:\n      - team: {contest: $.c}", "team needs contest and user"},
+		{"permission on user", "routes:\n  - topic: a\n    type: A\n    targets:\n      - user: $.u\n        permission: p", "only applies to room"},
+		{"empty condition", "routes:\n  - topic: a\n    type: A\n    targets:\n      - room: r\n        when: {}", "needs field or frozen"},
+		{"interpolated condition", "routes:\n  - topic: a\n    type: A\n    targets:\n      - room: r\n        when: {field: x$.f}", "single reference"},
+		{"malformed", "routes: [", "failed to parse"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := ParseTable([]byte(tt.yaml))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ParseTable: %v", err)
+				}
+				if table.Route("a") == nil || table.Route("b") != nil {
+					t.Errorf("Route lookup does not match the parsed topics %v", table.Topics())
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvalString(t *testing.T) {
+	event := decodeEvent(t, `{"userId":"u1","contestId":"c1","count":3,"empty":"","details":{"type":"TAB"}}`)
+
+	tests := []struct {
+		expr    string
+		want    string
+		missing bool
+	}{
+		{"$.userId", "u1", false},
+		{"contest:$.contestId", "contest:c1", false},
+		{"$.contestId/$.userId", "c1/u1", false},
+		{"n=$.count", "n=3", false},
+		{"$.details.type", "TAB", false},
+		{"literal", "literal", false},
+		{"$.missing", "", true},
+		{"contest:$.empty", "", true},
+		{"$.details.missing", "", true},
+		{"$.userId.nested", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := EvalString(tt.expr, event)
+			if tt.missing {
+				if !errors.Is(err, ErrMissingField) {
+					t.Errorf("err = %v, want %v", err, ErrMissingField)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("EvalString() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionHolds(t *testing.T) {
+	event := decodeEvent(t, `{"contestId":"c1","on":true,"off":false,"n":2,"zero":0,"s":"x","empty":""}`)
+	frozen := func(contestID string) bool { return contestID == "c1" }
+
+	tests := []struct {
+		name      string
+		condition Condition
+		frozen    func(string) bool
+		want      bool
+	}{
+		{"true", Condition{Field: "$.on"}, nil, true},
+		{"false", Condition{Field: "$.off"}, nil, false},
+		{"number", Condition{Field: "$.n"}, nil, true},
+		{"zero", Condition{Field: "$.zero"}, nil, false},
+		{"string", Condition{Field: "$.s"}, nil, true},
+		{"empty string", Condition{Field: "$.empty"}, nil, false},
+		{"missing", Condition{Field: "$.missing"}, nil, false},
+		{"frozen contest", Condition{Frozen: "$.contestId"}, frozen, true},
+		{"live contest", Condition{Frozen: "other"}, frozen, false},
+		{"frozen without resolver", Condition{Frozen: "$.contestId"}, nil, false},
+		{"either check", Condition{Field: "$.off", Frozen: "$.contestId"}, frozen, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.condition.holds(event, tt.frozen); got != tt.want {
+				t.Errorf("holds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildPayload(t *testing.T) {
+	raw := `{"contestId":"c1","score":12.5,"frozen":true,"details":{"type":"TAB"}}`
+	event := decodeEvent(t, raw)
+
+	tests := []struct {
+		name   string
+		fields map[string]interface{}
+		want   string
+	}{
+		{"forwarded unchanged", nil, raw},
+		{"single reference keeps its type", map[string]interface{}{"score": "$.score", "frozen": "$.frozen"}, `{"frozen":true,"score":12.5}`},
+		{"interpolated", map[string]interface{}{"room": "contest:$.contestId"}, `{"room":"contest:c1"}`},
+		{"literal", map[string]interface{}{"type": "CREATED", "n": 1}, `{"n":1,"type":"CREATED"}`},
+		{"nested", map[string]interface{}{"meta": map[string]interface{}{"kind": "$.details.type"}}, `{"meta":{"kind":"TAB"}}`},
+		{"missing reference", map[string]interface{}{"user": "$.userId", "label": "by $.userId"}, `{"label":"by ","user":null}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := &Route{Fields: tt.fields}
+			got, err := route.BuildPayload(event, []byte(raw))
+			if err != nil {
+				t.Fatalf("BuildPayload: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("BuildPayload() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
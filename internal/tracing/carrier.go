@@ -0,0 +1,37 @@
+package tracing
+
+import (
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaHeaders adapts Kafka message headers to a propagation.TextMapCarrier.
+type KafkaHeaders struct {
+	Headers *[]kafka.Header
+}
+
+func (c KafkaHeaders) Get(key string) string {
+	for _, header := range *c.Headers {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+func (c KafkaHeaders) Set(key, value string) {
+	for i, header := range *c.Headers {
+		if header.Key == key {
+			(*c.Headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c.Headers = append(*c.Headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c KafkaHeaders) Keys() []string {
+	keys := make([]string, 0, len(*c.Headers))
+	for _, header := range *c.Headers {
+		keys = append(keys, header.Key)
+	}
+	return keys
+}
@@ -0,0 +1,273 @@
+package events
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// SchemaVersionHeader is the Kafka header carrying the payload schema version.
+const SchemaVersionHeader = "schemaVersion"
+
+// Rejection reasons reported by ValidationError.
+const (
+	ReasonInvalidJSON    = "invalid_json"
+	ReasonUnknownVersion = "unknown_version"
+	ReasonNoSchema       = "no_schema"
+	ReasonMissingField   = "missing_field"
+	ReasonInvalidType    = "invalid_type"
+	ReasonInvalidValue   = "invalid_value"
+)
+
+var ErrNoSchema = errors.New("no schema registered for topic")
+
+//go:embed schemas/*.json
+var builtinSchemas embed.FS
+
+// ValidationError describes why an event payload was rejected.
+type ValidationError struct {
+	Topic   string
+	Version string
+	Reason  string
+	Field   string
+	Detail  string
+}
+
+func (e *ValidationError) Error() string {
+	msg := fmt.Sprintf("%s v%s: %s", e.Topic, e.Version, e.Reason)
+	if e.Field != "" {
+		msg += " at " + e.Field
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+// Schema is the subset of JSON Schema used to describe event payloads:
+// type, required, properties, items, enum and minLength.
+type Schema struct {
+	Type       schemaType         `json:"type"`
+	Required   []string           `json:"required"`
+	Properties map[string]*Schema `json:"properties"`
+	Items      *Schema            `json:"items"`
+	Enum       []interface{}      `json:"enum"`
+	MinLength  *int               `json:"minLength"`
+}
+
+// schemaType accepts both "string" and ["string", "null"] forms.
+type schemaType []string
+
+func (t *schemaType) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaType{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(data, &multi); err != nil {
+		return err
+	}
+	*t = multi
+	return nil
+}
+
+// SchemaRegistry holds every known schema version per topic.
+type SchemaRegistry struct {
+	schemas map[string]map[string]*Schema
+}
+
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{
+		schemas: make(map[string]map[string]*Schema),
+	}
+}
+
+// DefaultSchemaRegistry returns a registry loaded with the built-in schemas.
+func DefaultSchemaRegistry() (*SchemaRegistry, error) {
+	r := NewSchemaRegistry()
+	sub, err := fs.Sub(builtinSchemas, "schemas")
+	if err != nil {
+		return nil, err
+	}
+	if err := r.LoadFS(sub); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// LoadFS registers every file named <topic>.v<version>.json found at the
+// root of fsys, replacing any schema already registered for that version.
+func (r *SchemaRegistry) LoadFS(fsys fs.FS) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || path.Ext(name) != ".json" {
+			continue
+		}
+
+		base := strings.TrimSuffix(name, ".json")
+		idx := strings.LastIndex(base, ".v")
+		if idx <= 0 {
+			return fmt.Errorf("schema file %s is not named <topic>.v<version>.json", name)
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		var schema Schema
+		if err := json.Unmarshal(data, &schema); err != nil {
+			return fmt.Errorf("failed to parse schema %s: %w", name, err)
+		}
+		r.Register(base[:idx], base[idx+2:], &schema)
+	}
+	return nil
+}
+
+func (r *SchemaRegistry) Register(topic, version string, schema *Schema) {
+	if r.schemas[topic] == nil {
+		r.schemas[topic] = make(map[string]*Schema)
+	}
+	r.schemas[topic][version] = schema
+}
+
+func (r *SchemaRegistry) HasTopic(topic string) bool {
+	return len(r.schemas[topic]) > 0
+}
+
+// Versions returns the registered versions for topic in sorted order.
+func (r *SchemaRegistry) Versions(topic string) []string {
+	versions := make([]string, 0, len(r.schemas[topic]))
+	for version := range r.schemas[topic] {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	return versions
+}
+
+// Validate checks payload against the schema registered for topic and
+// version. It returns ErrNoSchema if the topic has no schemas at all and a
+// *ValidationError for every other failure.
+func (r *SchemaRegistry) Validate(topic, version string, payload []byte) error {
+	versions, ok := r.schemas[topic]
+	if !ok || len(versions) == 0 {
+		return ErrNoSchema
+	}
+
+	schema, ok := versions[version]
+	if !ok {
+		return &ValidationError{Topic: topic, Version: version, Reason: ReasonUnknownVersion}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return &ValidationError{Topic: topic, Version: version, Reason: ReasonInvalidJSON, Detail: err.Error()}
+	}
+
+	if reason, field, detail := schema.validate(value, "$"); reason != "" {
+		return &ValidationError{Topic: topic, Version: version, Reason: reason, Field: field, Detail: detail}
+	}
+	return nil
+}
+
+func (s *Schema) validate(value interface{}, at string) (reason, field, detail string) {
+	if len(s.Type) > 0 && !s.Type.matches(value) {
+		return ReasonInvalidType, at, fmt.Sprintf("expected %s", strings.Join(s.Type, " or "))
+	}
+
+	if len(s.Enum) > 0 && value != nil && !inEnum(value, s.Enum) {
+		return ReasonInvalidValue, at, "not one of the allowed values"
+	}
+
+	switch v := value.(type) {
+	case string:
+		if s.MinLength != nil && len(v) < *s.MinLength {
+			return ReasonInvalidValue, at, fmt.Sprintf("shorter than %d", *s.MinLength)
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return ReasonMissingField, at + "." + name, ""
+			}
+		}
+		for name, prop := range s.Properties {
+			child, ok := v[name]
+			if !ok {
+				continue
+			}
+			if reason, field, detail := prop.validate(child, at+"."+name); reason != "" {
+				return reason, field, detail
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				if reason, field, detail := s.Items.validate(item, fmt.Sprintf("%s[%d]", at, i)); reason != "" {
+					return reason, field, detail
+				}
+			}
+		}
+	}
+	return "", "", ""
+}
+
+func (t schemaType) matches(value interface{}) bool {
+	for _, name := range t {
+		switch name {
+		case "null":
+			if value == nil {
+				return true
+			}
+		case "string":
+			if _, ok := value.(string); ok {
+				return true
+			}
+		case "boolean":
+			if _, ok := value.(bool); ok {
+				return true
+			}
+		case "number":
+			if _, ok := value.(json.Number); ok {
+				return true
+			}
+		case "integer":
+			if n, ok := value.(json.Number); ok {
+				if _, err := n.Int64(); err == nil {
+					return true
+				}
+			}
+		case "object":
+			if _, ok := value.(map[string]interface{}); ok {
+				return true
+			}
+		case "array":
+			if _, ok := value.([]interface{}); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func inEnum(value interface{}, enum []interface{}) bool {
+	for _, allowed := range enum {
+		if fmt.Sprint(allowed) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -28,6 +28,9 @@ type SubmissionJudgedEvent struct {
 
 type LeaderboardUpdatedEvent struct {
 	ContestID string `json:"contestId"`
+	// Frozen marks updates made during a scoreboard freeze, which only
+	// staff may see.
+	Frozen    bool   `json:"frozen,omitempty"`
 	Timestamp string `json:"timestamp"`
 }
 
@@ -35,6 +38,7 @@ type ContestStartedEvent struct {
 	ContestID string `json:"contestId"`
 	Title     string `json:"title"`
 	StartTime string `json:"startTime"`
+	EndTime   string `json:"endTime,omitempty"`
 	Timestamp string `json:"timestamp"`
 }
 
@@ -53,10 +57,28 @@ type ContestCreatedEvent struct {
 	ScoringMode string `json:"scoringMode"`
 	StartTime   string `json:"startTime"`
 	EndTime     string `json:"endTime"`
-	CreatedBy   string `json:"createdBy"`
-	Timestamp   string `json:"timestamp"`
+	// FreezeTime is when the scoreboard freezes, if it does.
+	FreezeTime string `json:"freezeTime,omitempty"`
+	CreatedBy  string `json:"createdBy"`
+	// Proctored contests allow one connection per contestant in their
+	// contest room.
+	Proctored bool `json:"proctored,omitempty"`
+	// OrganizationID and InvitedUserIDs are the audience of a contest
+	// that is not public.
+	OrganizationID string   `json:"organizationId,omitempty"`
+	InvitedUserIDs []string `json:"invitedUserIds,omitempty"`
+	Timestamp      string   `json:"timestamp"`
 }
 
+// Contest visibilities. Only public contests are announced to everyone;
+// any other value limits announcements to the contest's organization and
+// invited users.
+const (
+	VisibilityPublic     = "public"
+	VisibilityPrivate    = "private"
+	VisibilityInviteOnly = "invite_only"
+)
+
 type ParticipantRegisteredEvent struct {
 	ContestID   string `json:"contestId"`
 	UserID      string `json:"userId"`
@@ -65,6 +87,49 @@ type ParticipantRegisteredEvent struct {
 	Timestamp   string `json:"timestamp"`
 }
 
+// TeamRegisteredEvent carries the full member list of a contest team; a
+// later event for the same team replaces it.
+type TeamRegisteredEvent struct {
+	ContestID string   `json:"contestId"`
+	TeamID    string   `json:"teamId"`
+	Name      string   `json:"name"`
+	MemberIDs []string `json:"memberIds"`
+	Timestamp string   `json:"timestamp"`
+}
+
+type ClarificationRequestedEvent struct {
+	ClarificationID string `json:"clarificationId"`
+	ContestID       string `json:"contestId"`
+	UserID          string `json:"userId"`
+	ProblemID       string `json:"problemId,omitempty"`
+	Question        string `json:"question"`
+	Timestamp       string `json:"timestamp"`
+}
+
+// ClarificationAnsweredEvent answers a clarification. Public answers go to
+// the whole contest, others only to the user who asked.
+type ClarificationAnsweredEvent struct {
+	ClarificationID string `json:"clarificationId"`
+	ContestID       string `json:"contestId"`
+	UserID          string `json:"userId"`
+	ProblemID       string `json:"problemId,omitempty"`
+	Question        string `json:"question"`
+	Answer          string `json:"answer"`
+	Public          bool   `json:"public"`
+	AnsweredBy      string `json:"answeredBy,omitempty"`
+	Timestamp       string `json:"timestamp"`
+}
+
+type AnnouncementEvent struct {
+	AnnouncementID string `json:"announcementId"`
+	ContestID      string `json:"contestId"`
+	ProblemID      string `json:"problemId,omitempty"`
+	Title          string `json:"title"`
+	Body           string `json:"body"`
+	CreatedBy      string `json:"createdBy,omitempty"`
+	Timestamp      string `json:"timestamp"`
+}
+
 type ParticipantUnregisteredEvent struct {
 	ContestID string `json:"contestId"`
 	UserID    string `json:"userId"`
@@ -105,3 +170,28 @@ type ProblemRemovedEvent struct {
 	ProblemID string `json:"problemId"`
 	Timestamp string `json:"timestamp"`
 }
+
+// SignalMultipleSessions is the ProctoringSignalEvent type emitted when a
+// contestant's other connections are removed from an exclusive room.
+const SignalMultipleSessions = "multiple_sessions"
+
+// ProctoringSignalEvent is published by the socket service for the
+// proctoring pipeline.
+type ProctoringSignalEvent struct {
+	ContestID string `json:"contestId"`
+	UserID    string `json:"userId"`
+	Type      string `json:"type"`
+	// ClientID is the connection that was kept.
+	ClientID string `json:"clientId"`
+	// RemovedConnections counts the connections removed on InstanceID.
+	RemovedConnections int    `json:"removedConnections"`
+	InstanceID         string `json:"instanceId"`
+	Timestamp          string `json:"timestamp"`
+}
+
+type SessionRevokedEvent struct {
+	UserID    string `json:"userId"`
+	SessionID string `json:"sessionId"`
+	Reason    string `json:"reason"`
+	Timestamp string `json:"timestamp"`
+}
@@ -3,31 +3,68 @@ package protocol
 import (
 	"encoding/json"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 type MessageType string
 
 const (
-	MsgJoinRoom    MessageType = "JOIN_ROOM"
-	MsgLeaveRoom   MessageType = "LEAVE_ROOM"
-	MsgPing        MessageType = "PING"
-	MsgSubscribe   MessageType = "SUBSCRIBE"
-	MsgUnsubscribe MessageType = "UNSUBSCRIBE"
-
-	MsgSubmissionCreated    MessageType = "SUBMISSION_CREATED"
-	MsgSubmissionResult     MessageType = "SUBMISSION_RESULT"
-	MsgLeaderboardUpdate    MessageType = "LEADERBOARD_UPDATE"
-	MsgLeaderboardFrozen    MessageType = "LEADERBOARD_FROZEN"
-	MsgLeaderboardUnfrozen  MessageType = "LEADERBOARD_UNFROZEN"
-	MsgContestEvent         MessageType = "CONTEST_EVENT"
-	MsgParticipantEvent     MessageType = "PARTICIPANT_EVENT"
-	MsgProctoringViolation  MessageType = "PROCTORING_VIOLATION"
-	MsgPresenceUpdate       MessageType = "PRESENCE_UPDATE"
-	MsgRoomJoined           MessageType = "ROOM_JOINED"
-	MsgRoomLeft             MessageType = "ROOM_LEFT"
-	MsgPong                 MessageType = "PONG"
-	MsgError                MessageType = "ERROR"
-	MsgConnected            MessageType = "CONNECTED"
+	MsgJoinRoom     MessageType = "JOIN_ROOM"
+	MsgLeaveRoom    MessageType = "LEAVE_ROOM"
+	MsgPing         MessageType = "PING"
+	MsgSubscribe    MessageType = "SUBSCRIBE"
+	MsgUnsubscribe  MessageType = "UNSUBSCRIBE"
+	MsgAuthenticate MessageType = "AUTHENTICATE"
+	MsgTimeSync     MessageType = "TIME_SYNC"
+
+	MsgGetContestState MessageType = "GET_CONTEST_STATE"
+	MsgMarkRead        MessageType = "MARK_READ"
+	MsgGetUnread       MessageType = "GET_UNREAD"
+
+	MsgSubmissionCreated       MessageType = "SUBMISSION_CREATED"
+	MsgSubmissionResult        MessageType = "SUBMISSION_RESULT"
+	MsgLeaderboardUpdate       MessageType = "LEADERBOARD_UPDATE"
+	MsgLeaderboardFrozen       MessageType = "LEADERBOARD_FROZEN"
+	MsgLeaderboardUnfrozen     MessageType = "LEADERBOARD_UNFROZEN"
+	MsgContestEvent            MessageType = "CONTEST_EVENT"
+	MsgParticipantEvent        MessageType = "PARTICIPANT_EVENT"
+	MsgParticipantUnregistered MessageType = "PARTICIPANT_UNREGISTERED"
+	MsgContestProblemAdded     MessageType = "CONTEST_PROBLEM_ADDED"
+	MsgContestProblemRemoved   MessageType = "CONTEST_PROBLEM_REMOVED"
+	MsgProctoringViolation     MessageType = "PROCTORING_VIOLATION"
+	MsgPresenceUpdate          MessageType = "PRESENCE_UPDATE"
+	MsgRoomJoined              MessageType = "ROOM_JOINED"
+	MsgRoomLeft                MessageType = "ROOM_LEFT"
+	MsgPong                    MessageType = "PONG"
+	MsgError                   MessageType = "ERROR"
+	MsgConnected               MessageType = "CONNECTED"
+	MsgTokenExpiring           MessageType = "TOKEN_EXPIRING"
+	MsgAuthenticated           MessageType = "AUTHENTICATED"
+	MsgSessionReplaced         MessageType = "SESSION_REPLACED"
+	MsgTimeSyncResponse        MessageType = "TIME_SYNC_RESPONSE"
+	MsgContestTick             MessageType = "CONTEST_TICK"
+	MsgContestMilestone        MessageType = "CONTEST_MILESTONE"
+	MsgContestState            MessageType = "CONTEST_STATE"
+	MsgTeamPresence            MessageType = "TEAM_PRESENCE"
+	MsgClarificationRequested  MessageType = "CLARIFICATION_REQUESTED"
+	MsgClarificationAnswered   MessageType = "CLARIFICATION_ANSWERED"
+	MsgContestAnnouncement     MessageType = "CONTEST_ANNOUNCEMENT"
+	MsgContestAnnouncements    MessageType = "CONTEST_ANNOUNCEMENTS"
+	MsgUnreadCount             MessageType = "UNREAD_COUNT"
+)
+
+// Application WebSocket close codes.
+const (
+	// CloseTokenExpired is sent when a connection's token expired without a
+	// valid AUTHENTICATE refresh.
+	CloseTokenExpired = 4001
+	// CloseSessionRevoked is sent when the user's session was revoked or
+	// the user was banned.
+	CloseSessionRevoked = 4003
+	// CloseSessionReplaced is sent when a newer connection of the same user
+	// took this connection's place.
+	CloseSessionReplaced = 4004
 )
 
 type Message struct {
@@ -35,6 +72,16 @@ type Message struct {
 	Payload   json.RawMessage `json:"payload,omitempty"`
 	Timestamp int64           `json:"timestamp"`
 	RequestID string          `json:"requestId,omitempty"`
+	// History marks a message replayed from a room's history on join
+	// rather than delivered live.
+	History bool `json:"history,omitempty"`
+
+	// EventTime is when the originating backend event was produced. It is
+	// not sent to clients and is zero for messages not caused by an event.
+	EventTime time.Time `json:"-"`
+	// SpanContext links the socket write back to the trace of the event
+	// that caused it. It is not sent to clients.
+	SpanContext trace.SpanContext `json:"-"`
 }
 
 func NewMessage(msgType MessageType, payload interface{}) (*Message, error) {
@@ -102,6 +149,95 @@ type RoomJoinedPayload struct {
 
 type RoomLeftPayload struct {
 	RoomID string `json:"roomId"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type AuthenticatePayload struct {
+	Token string `json:"token"`
+}
+
+type TokenExpiringPayload struct {
+	ExpiresAt int64 `json:"expiresAt"`
+	// CloseAt is when the connection is closed unless it re-authenticates.
+	CloseAt int64 `json:"closeAt"`
+}
+
+type AuthenticatedPayload struct {
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+}
+
+type SessionReplacedPayload struct {
+	Reason string `json:"reason"`
+}
+
+// TimeSyncPayload starts an NTP-style exchange. Times are Unix
+// milliseconds.
+type TimeSyncPayload struct {
+	ClientSendTime int64 `json:"clientSendTime"`
+}
+
+// TimeSyncResponsePayload lets the client estimate its clock offset as
+// ((serverReceiveTime - clientSendTime) + (serverSendTime - clientReceiveTime)) / 2
+// and the round trip as
+// (clientReceiveTime - clientSendTime) - (serverSendTime - serverReceiveTime).
+type TimeSyncResponsePayload struct {
+	ClientSendTime    int64 `json:"clientSendTime"`
+	ServerReceiveTime int64 `json:"serverReceiveTime"`
+	ServerSendTime    int64 `json:"serverSendTime"`
+}
+
+// ContestTickPayload is pushed periodically to contest rooms. Times are
+// Unix milliseconds.
+type ContestTickPayload struct {
+	ContestID   string `json:"contestId"`
+	Phase       string `json:"phase"` // "before_start", "running" or "frozen"
+	StartTime   int64  `json:"startTime"`
+	EndTime     int64  `json:"endTime,omitempty"`
+	FreezeTime  int64  `json:"freezeTime,omitempty"`
+	RemainingMs int64  `json:"remainingMs"`
+	ServerTime  int64  `json:"serverTime"`
+}
+
+// ContestMilestonePayload announces a contest milestone at the time it is
+// reached. Milestone is "STARTED", "TIME_LEFT", "FROZEN" or "ENDED".
+type ContestMilestonePayload struct {
+	ContestID   string `json:"contestId"`
+	Milestone   string `json:"milestone"`
+	RemainingMs int64  `json:"remainingMs,omitempty"`
+	ServerTime  int64  `json:"serverTime"`
+}
+
+type GetContestStatePayload struct {
+	ContestID string `json:"contestId"`
+}
+
+// ContestStatePayload is the registry's view of a contest. Times are Unix
+// milliseconds, zero when unknown.
+type ContestStatePayload struct {
+	ContestID      string `json:"contestId"`
+	Title          string `json:"title,omitempty"`
+	Slug           string `json:"slug,omitempty"`
+	Visibility     string `json:"visibility,omitempty"`
+	OrganizationID string `json:"organizationId,omitempty"`
+	ScoringMode    string `json:"scoringMode,omitempty"`
+	Phase          string `json:"phase"` // "created", "running", "frozen" or "ended"
+	StartTime      int64  `json:"startTime,omitempty"`
+	EndTime        int64  `json:"endTime,omitempty"`
+	FreezeTime     int64  `json:"freezeTime,omitempty"`
+	// Frozen stays set after the contest ends until the scoreboard is
+	// unfrozen.
+	Frozen           bool             `json:"frozen"`
+	Proctored        bool             `json:"proctored,omitempty"`
+	Problems         []ContestProblem `json:"problems"`
+	ParticipantCount int              `json:"participantCount"`
+	// Registered tells the requesting user whether they are a participant.
+	Registered bool  `json:"registered"`
+	UpdatedAt  int64 `json:"updatedAt"`
+}
+
+type ContestProblem struct {
+	ProblemID string `json:"problemId"`
+	Label     string `json:"label"`
 }
 
 type PresenceUpdatePayload struct {
@@ -111,6 +247,64 @@ type PresenceUpdatePayload struct {
 	RoomID   string `json:"roomId,omitempty"`
 }
 
+// TeamPresencePayload tells a team room which teammates are connected.
+type TeamPresencePayload struct {
+	ContestID   string   `json:"contestId"`
+	TeamID      string   `json:"teamId"`
+	Online      []string `json:"online"`
+	OnlineCount int      `json:"onlineCount"`
+	MemberCount int      `json:"memberCount"`
+}
+
+// ClarificationPayload carries a clarification request or its answer. The
+// asking user is left out of public answers.
+type ClarificationPayload struct {
+	ClarificationID string `json:"clarificationId"`
+	ContestID       string `json:"contestId"`
+	UserID          string `json:"userId,omitempty"`
+	ProblemID       string `json:"problemId,omitempty"`
+	Question        string `json:"question"`
+	Answer          string `json:"answer,omitempty"`
+	Public          bool   `json:"public,omitempty"`
+	Timestamp       string `json:"timestamp"`
+}
+
+type AnnouncementPayload struct {
+	AnnouncementID string `json:"announcementId"`
+	ContestID      string `json:"contestId"`
+	ProblemID      string `json:"problemId,omitempty"`
+	Title          string `json:"title,omitempty"`
+	Body           string `json:"body"`
+	Timestamp      string `json:"timestamp"`
+	// CreatedAt is when the announcement was made, in Unix milliseconds,
+	// or when the service received it if the event carries no time.
+	CreatedAt int64 `json:"createdAt"`
+}
+
+// AnnouncementsPayload is sent after joining a contest room with the
+// recent announcements, oldest first.
+type AnnouncementsPayload struct {
+	ContestID     string                `json:"contestId"`
+	Announcements []AnnouncementPayload `json:"announcements"`
+	Unread        int                   `json:"unread"`
+}
+
+// MarkReadPayload marks everything in a contest received so far as read.
+type MarkReadPayload struct {
+	ContestID string `json:"contestId"`
+}
+
+type GetUnreadPayload struct {
+	ContestID string `json:"contestId"`
+}
+
+// UnreadCountPayload counts the announcements and clarification answers
+// for the user that arrived since they last marked the contest read.
+type UnreadCountPayload struct {
+	ContestID string `json:"contestId"`
+	Unread    int    `json:"unread"`
+}
+
 func NewErrorMessage(code, message string, requestID string) (*Message, error) {
 	return NewMessageWithRequestID(MsgError, ErrorPayload{
 		Code:    code,